@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avprobe
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// probeFLAC 从第一个元数据块 STREAMINFO 中读取采样率和总采样数
+func probeFLAC(r io.ReaderAt, start int64, size int64) (*Info, error) {
+	if size-start < 4+4+34 {
+		return nil, ErrInvalid
+	}
+	block := make([]byte, 4+34)
+	if err := readAt(r, block, start+4); err != nil {
+		return nil, err
+	}
+	if block[0]&0x7F != 0 || int(block[1])<<16|int(block[2])<<8|int(block[3]) < 34 {
+		return nil, ErrInvalid
+	}
+
+	//采样率 20 位，声道数 3 位，位深 5 位，总采样数 36 位
+	si := block[4:]
+	rate := uint64(si[10])<<12 | uint64(si[11])<<4 | uint64(si[12])>>4
+	samples := uint64(si[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(si[14:]))
+	if rate == 0 {
+		return nil, ErrInvalid
+	}
+
+	info := &Info{AudioCodec: "flac"}
+	//总采样数为 0 表示未知
+	if d, ok := scaleDuration(samples, rate); ok {
+		info.Duration = d
+	}
+	return info, nil
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avprobe
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// 用到的 EBML 元素 ID
+const (
+	idEBML          = 0x1A45DFA3
+	idSegment       = 0x18538067
+	idSeekHead      = 0x114D9B74
+	idSeek          = 0x4DBB
+	idSeekID        = 0x53AB
+	idSeekPosition  = 0x53AC
+	idInfo          = 0x1549A966
+	idTimecodeScale = 0x2AD7B1
+	idDuration      = 0x4489
+	idTracks        = 0x1654AE6B
+	idTrackEntry    = 0xAE
+	idTrackType     = 0x83
+	idCodecID       = 0x86
+	idVideo         = 0xE0
+	idPixelWidth    = 0xB0
+	idPixelHeight   = 0xBA
+	idCluster       = 0x1F43B675
+)
+
+// mkvCodecs CodecID 对应的编码名，未列出的去掉 V_、A_ 前缀后转小写
+var mkvCodecs = map[string]string{
+	"V_MPEG4/ISO/AVC": "h264", "V_MPEGH/ISO/HEVC": "hevc", "V_AV1": "av1", "V_VP8": "vp8", "V_VP9": "vp9",
+	"V_MPEG4/ISO/ASP": "mpeg4", "V_MPEG2": "mpeg2", "V_MJPEG": "mjpeg",
+	"A_AAC": "aac", "A_OPUS": "opus", "A_VORBIS": "vorbis", "A_AC3": "ac3", "A_EAC3": "eac3", "A_DTS": "dts",
+	"A_FLAC": "flac", "A_MPEG/L3": "mp3", "A_MPEG/L2": "mp2", "A_TRUEHD": "truehd",
+	"A_PCM/INT/LIT": "pcm", "A_PCM/INT/BIG": "pcm", "A_PCM/FLOAT/IEEE": "pcm",
+}
+
+// maxMasterSize Info、Tracks 等元素全部读入内存解析，正常文件远小于该值
+const maxMasterSize = 4 << 20
+
+// element 元素头，size 为 -1 表示长度未知
+type element struct {
+	id     uint64
+	size   int64
+	header int64
+}
+
+// vint 解析 EBML 变长整数，返回值和占用的字节数。ID 保留长度标记位，长度不保留。
+func vint(b []byte, keepMarker bool) (uint64, int, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false
+	}
+	n := bits.LeadingZeros8(b[0]) + 1
+	if len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= 0xFF >> n
+	}
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, true
+}
+
+// readElement 读取 off 处的元素头，元素不能超出 end
+func readElement(r io.ReaderAt, off int64, end int64) (element, error) {
+	buf := make([]byte, 12)
+	if end-off < int64(len(buf)) {
+		buf = buf[:end-off]
+	}
+	if err := readAt(r, buf, off); err != nil {
+		return element{}, err
+	}
+	id, n, ok := vint(buf, true)
+	if !ok {
+		return element{}, ErrInvalid
+	}
+	size, m, ok := vint(buf[n:], false)
+	if !ok {
+		return element{}, ErrInvalid
+	}
+
+	e := element{id: id, size: int64(size), header: int64(n + m)}
+	if size == 1<<(7*uint(m))-1 {
+		e.size = -1
+	} else if size > uint64(end-off-e.header) {
+		return element{}, ErrInvalid
+	}
+	return e, nil
+}
+
+// elements 依次遍历内存中的子元素，长度未知或超出范围的元素截到 data 结尾
+func elements(data []byte, fn func(id uint64, payload []byte)) {
+	for len(data) > 0 {
+		id, n, ok := vint(data, true)
+		if !ok {
+			return
+		}
+		size, m, ok := vint(data[n:], false)
+		if !ok {
+			return
+		}
+		data = data[n+m:]
+		if size > uint64(len(data)) {
+			size = uint64(len(data))
+		}
+		fn(id, data[:size])
+		data = data[size:]
+	}
+}
+
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func probeMKV(r io.ReaderAt, size int64) (*Info, error) {
+	e, err := readElement(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+	if e.id != idEBML || e.size < 0 {
+		return nil, ErrInvalid
+	}
+
+	//跳过 EBML 头之后的 Void 等元素找到 Segment
+	off := e.header + e.size
+	for {
+		if e, err = readElement(r, off, size); err != nil {
+			return nil, err
+		}
+		if e.id == idSegment {
+			break
+		}
+		if e.size < 0 {
+			return nil, ErrInvalid
+		}
+		off += e.header + e.size
+	}
+	segStart, segEnd := off+e.header, size
+	if e.size >= 0 {
+		segEnd = segStart + e.size
+	}
+
+	info := &Info{}
+	var haveInfo, haveTracks bool
+	seek := map[uint64]int64{}
+	for off := segStart; off < segEnd && !(haveInfo && haveTracks); {
+		e, err := readElement(r, off, segEnd)
+		if err != nil {
+			return nil, err
+		}
+		if e.id == idCluster || e.size < 0 {
+			break
+		}
+		switch e.id {
+		case idSeekHead, idInfo, idTracks:
+			data, err := readPayload(r, off, e)
+			if err != nil {
+				return nil, err
+			}
+			switch e.id {
+			case idSeekHead:
+				parseSeekHead(data, seek)
+			case idInfo:
+				parseInfo(data, info)
+				haveInfo = true
+			case idTracks:
+				parseTracks(data, info)
+				haveTracks = true
+			}
+		}
+		off += e.header + e.size
+	}
+
+	//Info 或 Tracks 在 Cluster 之后时按 SeekHead 中的位置读取
+	if !haveInfo {
+		if haveInfo, err = readSeeked(r, seek[idInfo], idInfo, segStart, segEnd, parseInfo, info); err != nil {
+			return nil, err
+		}
+	}
+	if !haveTracks {
+		if haveTracks, err = readSeeked(r, seek[idTracks], idTracks, segStart, segEnd, parseTracks, info); err != nil {
+			return nil, err
+		}
+	}
+	if !haveInfo && !haveTracks {
+		return nil, ErrInvalid
+	}
+	return info, nil
+}
+
+// readSeeked 读取并解析 SeekHead 中记录的元素，pos 为 0 表示没有记录
+func readSeeked(r io.ReaderAt, pos int64, id uint64, segStart int64, segEnd int64, parse func([]byte, *Info), info *Info) (bool, error) {
+	if pos <= 0 || pos >= segEnd-segStart {
+		return false, nil
+	}
+	e, err := readElement(r, segStart+pos, segEnd)
+	if err == ErrInvalid || (err == nil && (e.id != id || e.size < 0)) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	data, err := readPayload(r, segStart+pos, e)
+	if err != nil {
+		return false, err
+	}
+	parse(data, info)
+	return true, nil
+}
+
+func readPayload(r io.ReaderAt, off int64, e element) ([]byte, error) {
+	if e.size > maxMasterSize {
+		return nil, ErrInvalid
+	}
+	data := make([]byte, e.size)
+	if err := readAt(r, data, off+e.header); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parseSeekHead 记录各顶级元素相对 Segment 内容开头的位置
+func parseSeekHead(data []byte, seek map[uint64]int64) {
+	elements(data, func(id uint64, payload []byte) {
+		if id != idSeek {
+			return
+		}
+		var target uint64
+		pos := int64(-1)
+		elements(payload, func(id uint64, v []byte) {
+			switch id {
+			case idSeekID:
+				target = ebmlUint(v)
+			case idSeekPosition:
+				if p := ebmlUint(v); p < math.MaxInt64 {
+					pos = int64(p)
+				}
+			}
+		})
+		if _, ok := seek[target]; !ok && pos >= 0 {
+			seek[target] = pos
+		}
+	})
+}
+
+func parseInfo(data []byte, info *Info) {
+	scale := uint64(1000000)
+	var duration float64
+	elements(data, func(id uint64, v []byte) {
+		switch id {
+		case idTimecodeScale:
+			if s := ebmlUint(v); s > 0 {
+				scale = s
+			}
+		case idDuration:
+			switch len(v) {
+			case 4:
+				duration = float64(math.Float32frombits(binary.BigEndian.Uint32(v)))
+			case 8:
+				duration = math.Float64frombits(binary.BigEndian.Uint64(v))
+			}
+		}
+	})
+	//Duration 以 TimecodeScale 纳秒为单位
+	if ns := duration * float64(scale); ns > 0 && ns < maxSeconds*float64(time.Second) {
+		info.Duration = time.Duration(ns)
+	}
+}
+
+// parseTracks 取第一个视频轨和第一个音频轨的编码，视频轨同时取宽高
+func parseTracks(data []byte, info *Info) {
+	elements(data, func(id uint64, entry []byte) {
+		if id != idTrackEntry {
+			return
+		}
+		var typ uint64
+		var codec string
+		var w, h int
+		elements(entry, func(id uint64, v []byte) {
+			switch id {
+			case idTrackType:
+				typ = ebmlUint(v)
+			case idCodecID:
+				codec = mkvCodec(strings.TrimRight(string(v), "\x00"))
+			case idVideo:
+				elements(v, func(id uint64, v []byte) {
+					switch id {
+					case idPixelWidth:
+						w = int(ebmlUint(v))
+					case idPixelHeight:
+						h = int(ebmlUint(v))
+					}
+				})
+			}
+		})
+
+		switch typ {
+		case 1:
+			if len(info.VideoCodec) == 0 {
+				info.VideoCodec, info.Width, info.Height = codec, w, h
+			}
+		case 2:
+			if len(info.AudioCodec) == 0 {
+				info.AudioCodec = codec
+			}
+		}
+	})
+}
+
+func mkvCodec(id string) string {
+	if name, ok := mkvCodecs[id]; ok {
+		return name
+	}
+	//A_AAC/MPEG4/LC 等旧的写法
+	if strings.HasPrefix(id, "A_AAC") {
+		return "aac"
+	}
+	if len(id) > 2 && (strings.HasPrefix(id, "V_") || strings.HasPrefix(id, "A_")) {
+		return strings.ToLower(id[2:])
+	}
+	return ""
+}
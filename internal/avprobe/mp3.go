@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avprobe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// mp3Bitrates 码率表，单位 kbps，下标依次为 MPEG1/MPEG2、层 1-3、帧头中的码率序号
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates 下标依次为帧头中的版本号（0 为 MPEG2.5，1 保留）和采样率序号
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// mp3ScanSize 在音频数据开头查找第一帧的范围
+const mp3ScanSize = 64 << 10
+
+type mp3Frame struct {
+	mpeg1      bool
+	layer      int
+	bitrate    int //bps
+	sampleRate int
+	samples    int //每帧的采样数
+	mono       bool
+	size       int //帧长度，含帧头
+}
+
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version, layerBits := h[1]>>3&3, h[1]>>1&3
+	bitrateIdx, rateIdx := h[2]>>4, h[2]>>2&3
+	//不支持 free format
+	if version == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || rateIdx == 3 {
+		return mp3Frame{}, false
+	}
+
+	f := mp3Frame{
+		mpeg1:      version == 3,
+		layer:      4 - int(layerBits),
+		sampleRate: mp3SampleRates[version][rateIdx],
+		mono:       h[3]>>6 == 3,
+	}
+	table := 1
+	if f.mpeg1 {
+		table = 0
+	}
+	f.bitrate = mp3Bitrates[table][f.layer-1][bitrateIdx] * 1000
+
+	padding := int(h[2] >> 1 & 1)
+	switch {
+	case f.layer == 1:
+		f.samples = 384
+		f.size = (12*f.bitrate/f.sampleRate + padding) * 4
+	case f.layer == 2 || f.mpeg1:
+		f.samples = 1152
+		f.size = 144*f.bitrate/f.sampleRate + padding
+	default:
+		f.samples = 576
+		f.size = 72*f.bitrate/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// probeMP3 从 start 开始查找连续的两个帧头，VBR 文件按 Xing/VBRI 头中的帧数计算时长，CBR 按码率估算
+func probeMP3(r io.ReaderAt, start int64, size int64) (*Info, error) {
+	n := size - start
+	if n > mp3ScanSize {
+		n = mp3ScanSize
+	}
+	buf := make([]byte, n)
+	if err := readAt(r, buf, start); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+4 <= len(buf); i++ {
+		f, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+		//要求下一帧也是合法帧头，避免把数据误认为帧头
+		if next := i + f.size; next+4 <= len(buf) {
+			if nf, ok := parseMP3Frame(buf[next:]); !ok || nf.sampleRate != f.sampleRate || nf.layer != f.layer {
+				continue
+			}
+		} else if int64(next) < size-start {
+			continue
+		}
+
+		info := &Info{AudioCodec: [...]string{"mp1", "mp2", "mp3"}[f.layer-1]}
+		if frames, ok := mp3VBRFrames(buf[i:], f); ok {
+			if d, ok := scaleDuration(uint64(frames)*uint64(f.samples), uint64(f.sampleRate)); ok {
+				info.Duration = d
+			}
+			return info, nil
+		}
+
+		audio := size - start - int64(i)
+		//结尾的 ID3v1 标签
+		tag := make([]byte, 3)
+		if size >= 128 && readAt(r, tag, size-128) == nil && string(tag) == "TAG" {
+			audio -= 128
+		}
+		if audio > 0 {
+			info.Duration = time.Duration(float64(audio) * 8 / float64(f.bitrate) * float64(time.Second))
+		}
+		return info, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// mp3VBRFrames 读取第一帧中 Xing/Info 或 VBRI 头记录的总帧数
+func mp3VBRFrames(frame []byte, f mp3Frame) (uint32, bool) {
+	if len(frame) < 4+32 {
+		return 0, false
+	}
+	//Xing 头位于 side information 之后
+	side := 17
+	switch {
+	case f.mpeg1 && !f.mono:
+		side = 32
+	case !f.mpeg1 && f.mono:
+		side = 9
+	}
+	if x := frame[4+side:]; len(x) >= 12 && (bytes.HasPrefix(x, []byte("Xing")) || bytes.HasPrefix(x, []byte("Info"))) {
+		if binary.BigEndian.Uint32(x[4:])&1 != 0 {
+			return binary.BigEndian.Uint32(x[8:]), true
+		}
+		return 0, false
+	}
+	if v := frame[36:]; len(v) >= 18 && bytes.HasPrefix(v, []byte("VBRI")) {
+		return binary.BigEndian.Uint32(v[14:]), true
+	}
+	return 0, false
+}
@@ -0,0 +1,206 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avprobe
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// mp4TopBoxes MP4/MOV 文件开头可能出现的 box
+var mp4TopBoxes = map[string]bool{
+	"ftyp": true, "moov": true, "mdat": true, "free": true, "skip": true, "wide": true, "pnot": true,
+}
+
+// mp4Codecs 样本描述中的 fourcc 对应的编码名
+var mp4Codecs = map[string]string{
+	"avc1": "h264", "avc3": "h264", "hvc1": "hevc", "hev1": "hevc", "av01": "av1",
+	"vp08": "vp8", "vp09": "vp9", "mp4v": "mpeg4", "jpeg": "mjpeg",
+	"apch": "prores", "apcn": "prores", "apcs": "prores", "apco": "prores", "ap4h": "prores",
+	"mp4a": "aac", ".mp3": "mp3", "ac-3": "ac3", "ec-3": "eac3", "Opus": "opus",
+	"fLaC": "flac", "alac": "alac", "sowt": "pcm", "twos": "pcm", "lpcm": "pcm",
+}
+
+// maxMoovSize moov 全部读入内存解析，正常文件远小于该值
+const maxMoovSize = 64 << 20
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	hdr := make([]byte, 16)
+	for off := int64(0); off+8 <= size; {
+		if err := readAt(r, hdr[:8], off); err != nil {
+			return nil, err
+		}
+		boxSize, headerLen := int64(binary.BigEndian.Uint32(hdr)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if err := readAt(r, hdr[8:], off+8); err != nil {
+				return nil, err
+			}
+			boxSize, headerLen = int64(binary.BigEndian.Uint64(hdr[8:])), 16
+		}
+		if boxSize < headerLen || boxSize > size-off {
+			return nil, ErrInvalid
+		}
+
+		if string(hdr[4:8]) == "moov" {
+			if boxSize-headerLen > maxMoovSize {
+				return nil, ErrInvalid
+			}
+			moov := make([]byte, boxSize-headerLen)
+			if err := readAt(r, moov, off+headerLen); err != nil {
+				return nil, err
+			}
+			return parseMoov(moov)
+		}
+		off += boxSize
+	}
+	return nil, ErrInvalid
+}
+
+// boxes 依次遍历 data 中的 box，fn 的参数为类型和去掉头部的内容
+func boxes(data []byte, fn func(typ string, payload []byte)) {
+	for len(data) >= 8 {
+		n, headerLen := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch n {
+		case 0:
+			n = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return
+			}
+			n, headerLen = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if n < headerLen || n > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[headerLen:n])
+		data = data[n:]
+	}
+}
+
+// findBox 按路径逐层查找第一个匹配的 box
+func findBox(data []byte, path ...string) []byte {
+	for _, typ := range path {
+		var found []byte
+		boxes(data, func(t string, payload []byte) {
+			if found == nil && t == typ {
+				found = payload
+			}
+		})
+		if found == nil {
+			return nil
+		}
+		data = found
+	}
+	return data
+}
+
+func parseMoov(moov []byte) (*Info, error) {
+	mvhd := findBox(moov, "mvhd")
+	if len(mvhd) < 20 {
+		return nil, ErrInvalid
+	}
+
+	//version 1 的时间字段为 64 位
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return nil, ErrInvalid
+		}
+		timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[20:])), binary.BigEndian.Uint64(mvhd[24:])
+	} else {
+		timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[12:])), uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	}
+	//分片的 MP4 时长在 mehd 中
+	if mehd := findBox(moov, "mvex", "mehd"); duration == 0 && len(mehd) >= 8 {
+		if mehd[0] == 1 && len(mehd) >= 12 {
+			duration = binary.BigEndian.Uint64(mehd[4:])
+		} else {
+			duration = uint64(binary.BigEndian.Uint32(mehd[4:]))
+		}
+	}
+
+	info := &Info{}
+	if d, ok := scaleDuration(duration, timescale); ok {
+		info.Duration = d
+	}
+	boxes(moov, func(typ string, trak []byte) {
+		if typ == "trak" {
+			parseTrak(trak, info)
+		}
+	})
+	return info, nil
+}
+
+// parseTrak 取第一个视频轨和第一个音频轨的编码，视频轨同时取宽高
+func parseTrak(trak []byte, info *Info) {
+	hdlr := findBox(trak, "mdia", "hdlr")
+	stsd := findBox(trak, "mdia", "minf", "stbl", "stsd")
+	if len(hdlr) < 12 || len(stsd) < 16 {
+		return
+	}
+	codec := mp4Codec(string(stsd[12:16]))
+
+	switch string(hdlr[8:12]) {
+	case "vide":
+		if len(info.VideoCodec) > 0 {
+			return
+		}
+		info.VideoCodec = codec
+		info.Width, info.Height = tkhdSize(findBox(trak, "tkhd"))
+		//tkhd 没有宽高时取样本描述中的编码宽高
+		if entry := stsd[8:]; info.Width == 0 && len(entry) >= 36 {
+			info.Width, info.Height = int(binary.BigEndian.Uint16(entry[32:])), int(binary.BigEndian.Uint16(entry[34:]))
+		}
+	case "soun":
+		if len(info.AudioCodec) == 0 {
+			info.AudioCodec = codec
+		}
+	}
+}
+
+func mp4Codec(fourcc string) string {
+	if name, ok := mp4Codecs[fourcc]; ok {
+		return name
+	}
+	for _, c := range fourcc {
+		if c < 0x20 || c > 0x7E {
+			return ""
+		}
+	}
+	return strings.TrimSpace(fourcc)
+}
+
+// tkhdSize 轨道的显示宽高，旋转 90 或 270 度时交换宽高
+func tkhdSize(tkhd []byte) (int, int) {
+	matrix := 40
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		matrix = 52
+	}
+	if len(tkhd) < matrix+44 {
+		return 0, 0
+	}
+	//宽高为 16.16 定点数，位于 3x3 矩阵之后
+	w := int(binary.BigEndian.Uint32(tkhd[matrix+36:]) >> 16)
+	h := int(binary.BigEndian.Uint32(tkhd[matrix+40:]) >> 16)
+	a, b := int32(binary.BigEndian.Uint32(tkhd[matrix:])), int32(binary.BigEndian.Uint32(tkhd[matrix+4:]))
+	if a == 0 && b != 0 {
+		w, h = h, w
+	}
+	return w, h
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package avprobe 从音视频文件的容器头部解析时长、分辨率和编码，不解码媒体数据
+package avprobe
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported media format")
+	ErrInvalid     = errors.New("invalid media file")
+)
+
+// Info 解析出的音视频信息，未包含的字段为零值
+type Info struct {
+	Duration   time.Duration
+	Width      int    //视频显示宽，已按旋转角度调整
+	Height     int    //视频显示高
+	VideoCodec string //如 h264、hevc
+	AudioCodec string //如 aac、mp3
+}
+
+// maxSeconds 超过该时长的视为无效数据
+const maxSeconds = 1 << 31
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Probe 根据文件头识别格式并解析，r 中 [0, size) 为完整的文件
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	head := make([]byte, 12)
+	if size < int64(len(head)) {
+		return nil, ErrUnsupported
+	}
+	if err := readAt(r, head, 0); err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, ebmlMagic):
+		return probeMKV(r, size)
+	case mp4TopBoxes[string(head[4:8])]:
+		return probeMP4(r, size)
+	case bytes.HasPrefix(head, []byte("RIFF")), bytes.HasPrefix(head, []byte("OggS")):
+		//WAV、AVI、Ogg 暂不支持，避免下面按 MP3 帧头误判
+		return nil, ErrUnsupported
+	}
+
+	//音频文件可能以 ID3v2 标签开头
+	start := int64(0)
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		//标签长度为 4 个 7 位的字节，不含 10 字节的头部
+		start = 10 + (int64(head[6]&0x7F)<<21 | int64(head[7]&0x7F)<<14 | int64(head[8]&0x7F)<<7 | int64(head[9]&0x7F))
+		if head[5]&0x10 != 0 {
+			start += 10
+		}
+	}
+	magic := make([]byte, 4)
+	if start+4 > size {
+		return nil, ErrUnsupported
+	}
+	if err := readAt(r, magic, start); err != nil {
+		return nil, err
+	}
+	if string(magic) == "fLaC" {
+		return probeFLAC(r, start, size)
+	}
+	return probeMP3(r, start, size)
+}
+
+// readAt 读满 p，文件提前结束时返回 ErrInvalid
+func readAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalid
+	}
+	return err
+}
+
+// scaleDuration 把以 1/timescale 秒为单位的时长转为 time.Duration
+func scaleDuration(d, timescale uint64) (time.Duration, bool) {
+	if timescale == 0 || d/timescale > maxSeconds {
+		return 0, false
+	}
+	return time.Duration(d/timescale)*time.Second + time.Duration(d%timescale*uint64(time.Second)/timescale), true
+}
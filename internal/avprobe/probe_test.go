@@ -0,0 +1,202 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package avprobe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	data := bytes.Join(payload, nil)
+	return append(append(u32(uint32(8+len(data))), typ...), data...)
+}
+
+func tkhd(width, height uint32, rotate bool) []byte {
+	matrix := [9]uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+	if rotate {
+		matrix[0], matrix[1], matrix[3], matrix[4] = 0, 0x10000, 0xFFFF0000, 0
+	}
+	b := make([]byte, 40)
+	for _, v := range matrix {
+		b = append(b, u32(v)...)
+	}
+	return box("tkhd", b, u32(width<<16), u32(height<<16))
+}
+
+func trak(handler string, fourcc string, tk []byte) []byte {
+	hdlr := box("hdlr", make([]byte, 8), []byte(handler), make([]byte, 12))
+	stsd := box("stsd", u32(0), u32(1), box(fourcc, make([]byte, 28)))
+	return box("trak", tk, box("mdia", hdlr, box("minf", box("stbl", stsd))))
+}
+
+func TestProbeMP4(t *testing.T) {
+	mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(61500), make([]byte, 80))
+	moov := box("moov", mvhd,
+		trak("vide", "avc1", tkhd(1920, 1080, true)),
+		trak("soun", "mp4a", nil),
+		trak("vide", "hvc1", tkhd(640, 480, false)))
+	//moov 在 mdat 之后
+	data := bytes.Join([][]byte{box("ftyp", []byte("isom"), u32(0)), box("mdat", make([]byte, 1000)), moov}, nil)
+
+	info, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Info{Duration: 61500 * time.Millisecond, Width: 1080, Height: 1920, VideoCodec: "h264", AudioCodec: "aac"}
+	if *info != want {
+		t.Errorf("got %+v, want %+v", *info, want)
+	}
+
+	//分片 MP4，mvhd 为 version 1
+	mvhd = box("mvhd", u32(1<<24), u64(0), u64(0), u32(90000), u64(0), make([]byte, 80))
+	moov = box("moov", mvhd, box("mvex", box("mehd", u32(0), u32(90000*3))), trak("soun", "Opus", nil))
+	data = append(box("ftyp", []byte("iso6"), u32(0)), moov...)
+	info, err = Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Info{Duration: 3 * time.Second, AudioCodec: "opus"}); *info != want {
+		t.Errorf("fragmented: got %+v, want %+v", *info, want)
+	}
+
+	//截断的文件
+	if _, err := Probe(bytes.NewReader(data[:len(data)-10]), int64(len(data)-10)); err != ErrInvalid {
+		t.Errorf("truncated: got %v, want ErrInvalid", err)
+	}
+}
+
+func ebml(id uint32, payload ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	data := bytes.Join(payload, nil)
+	size := u64(uint64(len(data)))
+	size[0] = 0x01
+	return append(append(b, size...), data...)
+}
+
+func TestProbeMKV(t *testing.T) {
+	header := ebml(idEBML, ebml(0x4282, []byte("webm")))
+	info := ebml(idInfo, ebml(idTimecodeScale, u64(1000000)), ebml(idDuration, u64(math.Float64bits(5000.5))))
+	tracks := ebml(idTracks,
+		ebml(idTrackEntry, ebml(idTrackType, []byte{1}), ebml(idCodecID, []byte("V_VP9")),
+			ebml(idVideo, ebml(idPixelWidth, u16(1280)), ebml(idPixelHeight, u16(720)))),
+		ebml(idTrackEntry, ebml(idTrackType, []byte{2}), ebml(idCodecID, []byte("A_AAC/MPEG4/LC"))))
+	cluster := ebml(idCluster, make([]byte, 100))
+	//Segment 长度未知
+	segment := func(children ...[]byte) []byte {
+		return bytes.Join(append([][]byte{header, {0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}, children...), nil)
+	}
+	want := Info{Duration: 5000500 * time.Microsecond, Width: 1280, Height: 720, VideoCodec: "vp9", AudioCodec: "aac"}
+
+	data := segment(info, tracks, cluster)
+	got, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("got %+v, want %+v", *got, want)
+	}
+
+	//Tracks 在 Cluster 之后，按 SeekHead 读取
+	seekHead := func(pos uint64) []byte {
+		return ebml(idSeekHead, ebml(idSeek, ebml(idSeekID, u32(idTracks)), ebml(idSeekPosition, u64(pos))))
+	}
+	pos := len(seekHead(0)) + len(info) + len(cluster)
+	data = segment(seekHead(uint64(pos)), info, cluster, tracks)
+	got, err = Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != want {
+		t.Errorf("seek head: got %+v, want %+v", *got, want)
+	}
+}
+
+func TestProbeMP3(t *testing.T) {
+	//MPEG1 Layer3 128kbps 44100Hz 立体声，帧长 417 字节
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	id3 := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, 20}, make([]byte, 20)...)
+
+	cbr := append(id3, bytes.Repeat(frame, 100)...)
+	info, err := Probe(bytes.NewReader(cbr), int64(len(cbr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 41700 * 8 * time.Second / 128000; info.AudioCodec != "mp3" || info.Duration != want {
+		t.Errorf("cbr: got %+v, want %v", *info, want)
+	}
+
+	//Xing 头记录了总帧数
+	xing := append([]byte{}, frame...)
+	copy(xing[4+32:], append([]byte("Xing"), append(u32(1), u32(1000)...)...))
+	vbr := append(append(id3, xing...), bytes.Repeat(frame, 10)...)
+	info, err = Probe(bytes.NewReader(vbr), int64(len(vbr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := scaleDuration(1000*1152, 44100); info.Duration != want {
+		t.Errorf("vbr: got %v, want %v", info.Duration, want)
+	}
+}
+
+func TestProbeFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	//44100Hz，2 声道，16 位，441000 个采样
+	copy(streamInfo[10:], []byte{0x0A, 0xC4, 0x42, 0xF0, 0x00, 0x06, 0xBA, 0xA8})
+	data := append(append([]byte("fLaC"), 0x80, 0, 0, 34), streamInfo...)
+
+	info, err := Probe(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Info{Duration: 10 * time.Second, AudioCodec: "flac"}); *info != want {
+		t.Errorf("got %+v, want %+v", *info, want)
+	}
+}
+
+func TestProbeUnsupported(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("plain text, not a media file")} {
+		if _, err := Probe(bytes.NewReader(data), int64(len(data))); err != ErrUnsupported {
+			t.Errorf("%q: got %v, want ErrUnsupported", data, err)
+		}
+	}
+}
@@ -14,7 +14,10 @@
 
 package env
 
-import "aofs/internal/config"
+import (
+	"aofs/internal/config"
+	"path/filepath"
+)
 
 var (
 	SQL_HOST     string // eulixspace-postgresql
@@ -43,6 +46,29 @@ var (
 	BACKUP_RESERVED_SPACE     int64
 	ASYNC_TASK_THRESHOLD      int
 	APP_BOX_DEPLOY_METHOD     string
+	DISK_ALLOC_POLICY         string //新文件的磁盘分配策略: most-free, round-robin, primary-first, fill-first
+	REBALANCE_RATE_LIMIT      int64  //磁盘均衡搬迁限速，单位字节/秒，0 不限速
+	REBALANCE_AFTER_EXPAND    bool   //扩容完成后是否自动均衡
+	DISK_INFO_WATCH_INTERVAL  int    //检查 disk_info.json 变化的间隔，单位秒，0 不检查
+	SCRUB_RATE_LIMIT          int64  //对象校验读取限速，单位字节/秒，0 不限速
+	SCRUB_INTERVAL_HOURS      int    //对象校验周期，单位小时，0 不定期校验
+	FSCK_ON_STARTUP           string //启动时执行 fsck: 空不执行, check 只检查, repair 检查并修复
+	BETAG_CACHE_SIZE          int    //betag 索引缓存的条目数，0 不缓存
+	ENCRYPT_AT_REST           bool   //新写入的对象是否加密存储
+	MASTER_KEY_PATH           string //加密存储的主密钥文件，应放在数据盘以外
+	COMPRESS_AT_REST          bool   //文本、文档等可压缩类型的新对象是否压缩存储
+	PREVIEW_CACHE_MAX_AGE     int    //缩略图、预览图的浏览器缓存时间，单位秒
+	UPLOAD_MAX_SIZE           int64  //单次请求上传的最大文件大小，更大的文件需分片上传
+	USAGE_RECONCILE_HOURS     int    //已用空间计数的校准周期，单位小时，0 不定期校准
+	TIERING_RULES             string //分层规则，JSON 数组，如 [{"idleDays":90},{"category":"video","minSize":1073741824}]
+	TIERING_INTERVAL_HOURS    int    //按规则搬迁冷数据的周期，单位小时，0 不定期执行
+	TIERING_RATE_LIMIT        int64  //冷数据搬迁限速，单位字节/秒，0 不限速，访问时搬回主存储也按此限速
+	TIERING_RECALL_HOLD_DAYS  int    //访问时搬回主存储的对象在该天数内不再按规则搬到次存储
+	REPLICA_INTERVAL_HOURS    int    //按副本规则检查和修复副本的周期，单位小时，0 不定期执行
+	REPLICA_RATE_LIMIT        int64  //副本复制限速，单位字节/秒，0 不限速
+	THUMB_CONCURRENCY         int    //同时生成缩略图的数量，0 不在本地生成
+	THUMB_MAX_WAITING         int    //等待生成缩略图的请求数上限，超过时直接返回 503
+	THUMB_MAX_PIXELS          int64  //本地生成缩略图的原图像素上限，防止解码占用过多内存
 )
 
 func init() {
@@ -68,4 +94,27 @@ func init() {
 	MULTIPART_TASK_LRU_SECOND = config.ReadInt("MULTIPART_TASK_LIFECYCLE", 30*86400)
 	ASYNC_TASK_THRESHOLD = config.ReadInt("ASYNC_TASK_THRESHOLD", 1000)
 	APP_BOX_DEPLOY_METHOD = config.ReadString("APP_BOX_DEPLOY_METHOD", "box")
+	DISK_ALLOC_POLICY = config.ReadString("DISK_ALLOC_POLICY", "primary-first")
+	REBALANCE_RATE_LIMIT = config.ReadInt64("REBALANCE_RATE_LIMIT", 32*1024*1024)
+	REBALANCE_AFTER_EXPAND = config.ReadBool("REBALANCE_AFTER_EXPAND", true)
+	DISK_INFO_WATCH_INTERVAL = config.ReadInt("DISK_INFO_WATCH_INTERVAL", 30)
+	SCRUB_RATE_LIMIT = config.ReadInt64("SCRUB_RATE_LIMIT", 16*1024*1024)
+	SCRUB_INTERVAL_HOURS = config.ReadInt("SCRUB_INTERVAL_HOURS", 7*24)
+	FSCK_ON_STARTUP = config.ReadString("FSCK_ON_STARTUP", "")
+	BETAG_CACHE_SIZE = config.ReadInt("BETAG_CACHE_SIZE", 100000)
+	ENCRYPT_AT_REST = config.ReadBool("ENCRYPT_AT_REST", false)
+	MASTER_KEY_PATH = config.ReadString("MASTER_KEY_PATH", filepath.Join(DATA_PATH, ".master.key"))
+	COMPRESS_AT_REST = config.ReadBool("COMPRESS_AT_REST", false)
+	PREVIEW_CACHE_MAX_AGE = config.ReadInt("PREVIEW_CACHE_MAX_AGE", 86400)
+	UPLOAD_MAX_SIZE = config.ReadInt64("UPLOAD_MAX_SIZE", 16*1024*1024)
+	USAGE_RECONCILE_HOURS = config.ReadInt("USAGE_RECONCILE_HOURS", 24)
+	TIERING_RULES = config.ReadString("TIERING_RULES", "")
+	TIERING_INTERVAL_HOURS = config.ReadInt("TIERING_INTERVAL_HOURS", 24)
+	TIERING_RATE_LIMIT = config.ReadInt64("TIERING_RATE_LIMIT", 16*1024*1024)
+	TIERING_RECALL_HOLD_DAYS = config.ReadInt("TIERING_RECALL_HOLD_DAYS", 30)
+	REPLICA_INTERVAL_HOURS = config.ReadInt("REPLICA_INTERVAL_HOURS", 24)
+	REPLICA_RATE_LIMIT = config.ReadInt64("REPLICA_RATE_LIMIT", 16*1024*1024)
+	THUMB_CONCURRENCY = config.ReadInt("THUMB_CONCURRENCY", 2)
+	THUMB_MAX_WAITING = config.ReadInt("THUMB_MAX_WAITING", 32)
+	THUMB_MAX_PIXELS = config.ReadInt64("THUMB_MAX_PIXELS", 50000000)
 }
@@ -0,0 +1,317 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exif
+
+//此文件完成 JPEG 中 EXIF（TIFF 格式）元数据的解析，只依赖标准库
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrNoExif = errors.New("no exif")
+
+// 用到的 EXIF 标签
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagPixelXDimension  = 0xA002
+	tagPixelYDimension  = 0xA003
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+)
+
+const dateTimeLayout = "2006:01:02 15:04:05"
+
+// Exif 从图片中解析出的元数据，未包含的字段为零值
+type Exif struct {
+	Orientation      int    //1-8，见 EXIF 规范，1 为正常方向
+	Make             string //相机厂商
+	Model            string //相机型号
+	DateTimeOriginal string //拍摄时间，格式 2006:01:02 15:04:05，没有时区
+	OffsetOriginal   string //拍摄时间的时区，如 +08:00
+	Width            int    //原始像素宽高，未按 Orientation 旋转
+	Height           int
+	GPS              *GPS
+}
+
+// GPS 拍摄位置，经纬度为度，南纬、西经为负
+type GPS struct {
+	Latitude    float64
+	Longitude   float64
+	Altitude    float64 //海拔，单位米，海平面以下为负
+	HasAltitude bool
+}
+
+// TakenTime 拍摄时间，没有时区信息时按 loc 解析
+func (x *Exif) TakenTime(loc *time.Location) (time.Time, bool) {
+	if len(x.DateTimeOriginal) == 0 {
+		return time.Time{}, false
+	}
+	if len(x.OffsetOriginal) > 0 {
+		if t, err := time.Parse(dateTimeLayout+"-07:00", x.DateTimeOriginal+x.OffsetOriginal); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.ParseInLocation(dateTimeLayout, x.DateTimeOriginal, loc)
+	return t, err == nil
+}
+
+// DecodeJPEG 从 JPEG 文件头部数据中解析 EXIF，data 需包含 APP1 段
+func DecodeJPEG(data []byte) (*Exif, error) {
+	payload, err := jpegApp1(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTiff(payload)
+}
+
+// jpegApp1 查找 JPEG 中 Exif 的 APP1 段，返回其中的 TIFF 数据
+func jpegApp1(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrNoExif
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return nil, ErrNoExif
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			//填充字节
+			pos++
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			//图像数据开始，之后不会再有 APP 段
+			return nil, ErrNoExif
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 {
+			return nil, ErrNoExif
+		}
+		end := pos + 2 + length
+		if end > len(data) {
+			end = len(data)
+		}
+		seg := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:], nil
+		}
+		pos += 2 + length
+	}
+	return nil, ErrNoExif
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type entry struct {
+	typ   uint16
+	count uint32
+	value []byte //count 个值的原始数据
+}
+
+// 各数据类型每个值的字节数，下标为 TIFF 类型编号
+var typeSize = [...]uint32{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+func decodeTiff(data []byte) (*Exif, error) {
+	t, ifd0, err := newTiff(data)
+	if err != nil {
+		return nil, err
+	}
+	entries := t.ifd(ifd0)
+
+	var x Exif
+	if e, ok := entries[tagOrientation]; ok {
+		if o, ok := t.uint(e); ok && o >= 1 && o <= 8 {
+			x.Orientation = int(o)
+		}
+	}
+	x.Make = t.string(entries[tagMake])
+	x.Model = t.string(entries[tagModel])
+	x.DateTimeOriginal = t.string(entries[tagDateTime])
+
+	if e, ok := entries[tagExifIFD]; ok {
+		if off, ok := t.uint(e); ok {
+			sub := t.ifd(off)
+			if dt := t.string(sub[tagDateTimeOriginal]); len(dt) > 0 {
+				x.DateTimeOriginal = dt
+			}
+			x.OffsetOriginal = t.string(sub[tagOffsetOriginal])
+			if w, ok := t.uint(sub[tagPixelXDimension]); ok {
+				x.Width = int(w)
+			}
+			if h, ok := t.uint(sub[tagPixelYDimension]); ok {
+				x.Height = int(h)
+			}
+		}
+	}
+	if e, ok := entries[tagGPSIFD]; ok {
+		if off, ok := t.uint(e); ok {
+			x.GPS = t.gps(t.ifd(off))
+		}
+	}
+	//未填写的时间通常为全 0 或空格
+	if strings.HasPrefix(x.DateTimeOriginal, "0000") || len(strings.Trim(x.DateTimeOriginal, " :")) == 0 {
+		x.DateTimeOriginal = ""
+	}
+	return &x, nil
+}
+
+func (t *tiff) gps(entries map[uint16]entry) *GPS {
+	lat, ok1 := t.degrees(entries[tagGPSLatitude])
+	lon, ok2 := t.degrees(entries[tagGPSLongitude])
+	if !ok1 || !ok2 || lat > 90 || lon > 180 {
+		return nil
+	}
+	if t.string(entries[tagGPSLatitudeRef]) == "S" {
+		lat = -lat
+	}
+	if t.string(entries[tagGPSLongitudeRef]) == "W" {
+		lon = -lon
+	}
+
+	g := &GPS{Latitude: lat, Longitude: lon}
+	if alt, ok := t.rational(entries[tagGPSAltitude], 0); ok {
+		g.Altitude, g.HasAltitude = alt, true
+		if ref, ok := t.uint(entries[tagGPSAltitudeRef]); ok && ref == 1 {
+			g.Altitude = -alt
+		}
+	}
+	return g
+}
+
+// degrees 度、分、秒三个 RATIONAL 转为度
+func (t *tiff) degrees(e entry) (float64, bool) {
+	if e.count < 3 {
+		return 0, false
+	}
+	var v float64
+	for i, div := range []float64{1, 60, 3600} {
+		r, ok := t.rational(e, i)
+		if !ok {
+			return 0, false
+		}
+		v += r / div
+	}
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func newTiff(data []byte) (*tiff, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrNoExif
+	}
+	t := &tiff{data: data}
+	switch string(data[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, 0, ErrNoExif
+	}
+	if t.order.Uint16(data[2:]) != 42 {
+		return nil, 0, ErrNoExif
+	}
+	return t, t.order.Uint32(data[4:]), nil
+}
+
+// ifd 读取 offset 处的 IFD，越界或无法识别的条目被忽略
+func (t *tiff) ifd(offset uint32) map[uint16]entry {
+	entries := map[uint16]entry{}
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return entries
+	}
+	n := uint32(t.order.Uint16(t.data[offset:]))
+	for i := uint32(0); i < n; i++ {
+		p := uint64(offset) + 2 + uint64(i)*12
+		if p+12 > uint64(len(t.data)) {
+			break
+		}
+		raw := t.data[p : p+12]
+		tag, typ, count := t.order.Uint16(raw), t.order.Uint16(raw[2:]), t.order.Uint32(raw[4:])
+		if int(typ) >= len(typeSize) || typeSize[typ] == 0 {
+			continue
+		}
+		size := uint64(typeSize[typ]) * uint64(count)
+		value := raw[8:12]
+		if size > 4 {
+			//超过 4 字节的值存放在偏移处
+			off := uint64(t.order.Uint32(raw[8:]))
+			if off+size > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[off : off+size]
+		}
+		entries[tag] = entry{typ: typ, count: count, value: value[:size]}
+	}
+	return entries
+}
+
+// string 读取 ASCII 类型的条目，去掉结尾的 0 和空格
+func (t *tiff) string(e entry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	if i := bytes.IndexByte(e.value, 0); i >= 0 {
+		return strings.TrimSpace(string(e.value[:i]))
+	}
+	return strings.TrimSpace(string(e.value))
+}
+
+// rational 读取 RATIONAL 类型条目的第 i 个值
+func (t *tiff) rational(e entry, i int) (float64, bool) {
+	if e.typ != 5 || uint32(i) >= e.count {
+		return 0, false
+	}
+	num, den := t.order.Uint32(e.value[i*8:]), t.order.Uint32(e.value[i*8+4:])
+	if den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// uint 读取 BYTE、SHORT、LONG 类型条目的第一个值
+func (t *tiff) uint(e entry) (uint32, bool) {
+	if e.count == 0 {
+		return 0, false
+	}
+	switch e.typ {
+	case 1:
+		return uint32(e.value[0]), true
+	case 3:
+		return uint32(t.order.Uint16(e.value)), true
+	case 4:
+		return t.order.Uint32(e.value), true
+	}
+	return 0, false
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTiff 生成只有 IFD0 的 TIFF 数据
+func buildTiff(order binary.ByteOrder, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, order)
+	order.PutUint32(buf.Bytes()[4:], writeIFD(&buf, order, entries))
+	return buf.Bytes()
+}
+
+func writeHeader(buf *bytes.Buffer, order binary.ByteOrder) {
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(buf, order, uint16(42))
+	binary.Write(buf, order, uint32(8))
+}
+
+// writeIFD 在 buf 末尾写入一个 IFD，超过 4 字节的值依次放在 IFD 之后，返回 IFD 的偏移
+func writeIFD(buf *bytes.Buffer, order binary.ByteOrder, entries []testEntry) uint32 {
+	offset := uint32(buf.Len())
+	binary.Write(buf, order, uint16(len(entries)))
+	extra := offset + uint32(2+12*len(entries)+4)
+	var data bytes.Buffer
+	for _, e := range entries {
+		binary.Write(buf, order, e.tag)
+		binary.Write(buf, order, e.typ)
+		binary.Write(buf, order, e.count)
+		if len(e.value) > 4 {
+			binary.Write(buf, order, extra+uint32(data.Len()))
+			data.Write(e.value)
+		} else {
+			v := make([]byte, 4)
+			copy(v, e.value)
+			buf.Write(v)
+		}
+	}
+	binary.Write(buf, order, uint32(0))
+	buf.Write(data.Bytes())
+	return offset
+}
+
+func buildJPEG(tiffData []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	//APP0，验证会跳过非 Exif 的段
+	buf.Write([]byte{0xFF, 0xE0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00})
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(&buf, binary.BigEndian, uint16(2+6+len(tiffData)))
+	buf.WriteString("Exif\x00\x00")
+	buf.Write(tiffData)
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02})
+	return buf.Bytes()
+}
+
+func short(order binary.ByteOrder, v uint16) []byte {
+	b := make([]byte, 2)
+	order.PutUint16(b, v)
+	return b
+}
+
+func long(order binary.ByteOrder, v uint32) []byte {
+	b := make([]byte, 4)
+	order.PutUint32(b, v)
+	return b
+}
+
+func ascii(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func rationals(order binary.ByteOrder, v ...uint32) []byte {
+	var b []byte
+	for _, x := range v {
+		b = append(b, long(order, x)...)
+	}
+	return b
+}
+
+func TestDecodeJPEGOrientation(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data := buildJPEG(buildTiff(order, []testEntry{{tagOrientation, 3, 1, short(order, 6)}}))
+		x, err := DecodeJPEG(data)
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if x.Orientation != 6 {
+			t.Errorf("%v: orientation %v, want 6", order, x.Orientation)
+		}
+	}
+}
+
+func TestDecodeJPEGInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":     nil,
+		"not jpeg":  []byte("\x89PNG\r\n\x1a\n"),
+		"no app1":   {0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02},
+		"truncated": buildJPEG(buildTiff(binary.BigEndian, nil))[:14],
+	}
+	for name, data := range tests {
+		if _, err := DecodeJPEG(data); err != ErrNoExif {
+			t.Errorf("%v: got %v, want ErrNoExif", name, err)
+		}
+	}
+
+	//越界的条目被忽略，不会 panic
+	tiffData := buildTiff(binary.LittleEndian, []testEntry{{tagOrientation, 3, 100, make([]byte, 200)}})
+	x, err := DecodeJPEG(buildJPEG(tiffData[:len(tiffData)-100]))
+	if err != nil || x.Orientation != 0 {
+		t.Errorf("out of range entry: %+v, %v", x, err)
+	}
+}
+
+func TestDecodeJPEGMetadata(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var buf bytes.Buffer
+		writeHeader(&buf, order)
+		exifIFD := writeIFD(&buf, order, []testEntry{
+			{tagDateTimeOriginal, 2, 20, ascii("2021:05:01 08:30:00")},
+			{tagOffsetOriginal, 2, 7, ascii("+08:00")},
+			{tagPixelXDimension, 4, 1, long(order, 4032)},
+			{tagPixelYDimension, 3, 1, short(order, 3024)},
+		})
+		gpsIFD := writeIFD(&buf, order, []testEntry{
+			{tagGPSLatitudeRef, 2, 2, ascii("N")},
+			{tagGPSLatitude, 5, 3, rationals(order, 39, 1, 54, 1, 1530, 100)},
+			{tagGPSLongitudeRef, 2, 2, ascii("W")},
+			{tagGPSLongitude, 5, 3, rationals(order, 116, 1, 24, 1, 0, 1)},
+			{tagGPSAltitudeRef, 1, 1, []byte{1}},
+			{tagGPSAltitude, 5, 1, rationals(order, 105, 2)},
+		})
+		ifd0 := writeIFD(&buf, order, []testEntry{
+			{tagMake, 2, 6, ascii("Canon")},
+			{tagModel, 2, 13, ascii("EOS R5      ")},
+			{tagOrientation, 3, 1, short(order, 1)},
+			{tagDateTime, 2, 20, ascii("2022:01:01 00:00:00")},
+			{tagExifIFD, 4, 1, long(order, exifIFD)},
+			{tagGPSIFD, 4, 1, long(order, gpsIFD)},
+		})
+		order.PutUint32(buf.Bytes()[4:], ifd0)
+
+		x, err := DecodeJPEG(buildJPEG(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v: %v", order, err)
+		}
+		if x.Make != "Canon" || x.Model != "EOS R5" || x.Width != 4032 || x.Height != 3024 {
+			t.Errorf("%v: got %+v", order, x)
+		}
+		taken, ok := x.TakenTime(time.UTC)
+		if want := time.Date(2021, 5, 1, 0, 30, 0, 0, time.UTC); !ok || !taken.Equal(want) {
+			t.Errorf("%v: taken %v, want %v", order, taken, want)
+		}
+		if x.GPS == nil {
+			t.Fatalf("%v: no gps", order)
+		}
+		if math.Abs(x.GPS.Latitude-39.90425) > 1e-9 || x.GPS.Longitude != -116.4 ||
+			!x.GPS.HasAltitude || x.GPS.Altitude != -52.5 {
+			t.Errorf("%v: gps %+v", order, *x.GPS)
+		}
+	}
+}
+
+func TestTakenTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	x := Exif{DateTimeOriginal: "2021:05:01 08:30:00"}
+	if taken, ok := x.TakenTime(loc); !ok || !taken.Equal(time.Date(2021, 5, 1, 8, 30, 0, 0, loc)) {
+		t.Errorf("without offset: %v %v", taken, ok)
+	}
+
+	//未填写的时间
+	tiffData := buildTiff(binary.LittleEndian, []testEntry{{tagDateTime, 2, 20, ascii("0000:00:00 00:00:00")}})
+	x2, err := DecodeJPEG(buildJPEG(tiffData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := x2.TakenTime(loc); ok {
+		t.Errorf("empty date time: %q", x2.DateTimeOriginal)
+	}
+	if x2.GPS != nil {
+		t.Errorf("unexpected gps %+v", *x2.GPS)
+	}
+}
@@ -19,8 +19,17 @@ type BETagInfo struct {
 	VolId      uint16 `gorm:"column:vol_id" json:"volId" form:"volId" `
 	CreateTime int64  `gorm:"column:created_time" json:"createdAt" form:"createdAt"`
 	ModifyTime int64  `gorm:"column:modify_time" json:"modifyAt" form:"modifyAt"`
+	Tier       uint8  `gorm:"column:tier;default:0" json:"tier" form:"tier"`                //对象所在存储层，见 TierPrimary
+	AccessTime int64  `gorm:"column:access_time;default:0" json:"accessAt" form:"accessAt"` //最近访问时间，按天更新，0 表示未访问过
+	RecallTime int64  `gorm:"column:recall_time;default:0" json:"recallAt" form:"recallAt"` //最近因访问从次存储搬回主存储的时间，0 表示未搬回过
 }
 
+// 对象所在的存储层
+const (
+	TierPrimary   uint8 = 0 //主存储
+	TierSecondary uint8 = 1 //次存储
+)
+
 func (BETagInfo) TableName() string {
 	return "aofs_betag_infos"
 }
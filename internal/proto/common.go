@@ -52,20 +52,21 @@ type Fids struct {
 type UserIdType uint
 
 type FileInfoPub struct {
-	Id            string `gorm:"column:uuid;PRIMARY_KEY;index" json:"uuid" form:"uuid"`
-	ParentUuid    string `gorm:"column:parent_uuid" json:"parentUuid" form:"parentUuid" `
-	IsDir         bool   `gorm:"column:is_dir" json:"isDir" form:"isDir" `
-	Name          string `gorm:"column:name;uniqueIndex:totalPath" json:"name" form:"name"`
-	Path          string `gorm:"column:path;uniqueIndex:totalPath" json:"path" form:"path"`
-	BETag         string `gorm:"column:betag" json:"betag" form:"betag"`
-	CreateTime    int64  `gorm:"column:created_time" json:"createdAt" form:"createdAt"`
-	ModifyTime    int64  `gorm:"column:modify_time" json:"modifyAt" form:"modifyAt"`
-	OperationTime int64  `gorm:"column:operation_time" json:"operationAt" form:"operationAt"`
-	Size          int64  `gorm:"column:size" json:"size" form:"size"`
-	Category      string `gorm:"column:category" json:"category" form:"category"`
-	Mime          string `gorm:"column:mime" json:"mime" form:"mime"`
-	Trashed       uint32 `gorm:"column:trashed;uniqueIndex:totalPath" json:"trashed" form:"trashed"` //0-normal; 1-Logical delete, put into the recycle bin; 2-Has been cleared from the recycle bin and is to be physically deleted
-	FileCount     uint32 `gorm:"column:file_count" json:"fileCount" form:"fileCount"`
+	Id            string         `gorm:"column:uuid;PRIMARY_KEY;index" json:"uuid" form:"uuid"`
+	ParentUuid    string         `gorm:"column:parent_uuid" json:"parentUuid" form:"parentUuid" `
+	IsDir         bool           `gorm:"column:is_dir" json:"isDir" form:"isDir" `
+	Name          string         `gorm:"column:name;uniqueIndex:totalPath" json:"name" form:"name"`
+	Path          string         `gorm:"column:path;uniqueIndex:totalPath" json:"path" form:"path"`
+	BETag         string         `gorm:"column:betag" json:"betag" form:"betag"`
+	CreateTime    int64          `gorm:"column:created_time" json:"createdAt" form:"createdAt"`
+	ModifyTime    int64          `gorm:"column:modify_time" json:"modifyAt" form:"modifyAt"`
+	OperationTime int64          `gorm:"column:operation_time" json:"operationAt" form:"operationAt"`
+	Size          int64          `gorm:"column:size" json:"size" form:"size"`
+	Category      string         `gorm:"column:category" json:"category" form:"category"`
+	Mime          string         `gorm:"column:mime" json:"mime" form:"mime"`
+	Trashed       uint32         `gorm:"column:trashed;uniqueIndex:totalPath" json:"trashed" form:"trashed"` //0-normal; 1-Logical delete, put into the recycle bin; 2-Has been cleared from the recycle bin and is to be physically deleted
+	FileCount     uint32         `gorm:"column:file_count" json:"fileCount" form:"fileCount"`
+	FileInfoExt   datatypes.JSON `gorm:"column:ext" json:"ext" form:"ext"`
 }
 
 type FileInfoPubLst []FileInfoPub
@@ -73,17 +74,40 @@ type FileInfoPubLst []FileInfoPub
 type FileInfo struct {
 	FileInfoPub
 
-	UserId        UserIdType     `gorm:"column:user_id;uniqueIndex:totalPath" json:"userId" form:"userId"`
-	Tags          string         `gorm:"column:tags" json:"tags" form:"tags"`
-	Executable    bool           `gorm:"column:executable" json:"executable" form:"executable"`
-	Version       uint32         `gorm:"column:version" json:"version" form:"version"`
-	BucketName    string         `gorm:"column:bucketname" json:"bucketName" form:"bucketName"`
-	TransactionId int64          `gorm:"column:transaction_id;default:0" json:"transactionId" form:"transactionId"`
-	FileInfoExt   datatypes.JSON `gorm:"column:ext" json:"ext" form:"ext"`
+	UserId        UserIdType `gorm:"column:user_id;uniqueIndex:totalPath" json:"userId" form:"userId"`
+	Tags          string     `gorm:"column:tags" json:"tags" form:"tags"`
+	Executable    bool       `gorm:"column:executable" json:"executable" form:"executable"`
+	Version       uint32     `gorm:"column:version" json:"version" form:"version"`
+	BucketName    string     `gorm:"column:bucketname" json:"bucketName" form:"bucketName"`
+	TransactionId int64      `gorm:"column:transaction_id;default:0" json:"transactionId" form:"transactionId"`
 }
 
 type FileInfoExt struct {
-	Charset string `json:"charset" form:"charset"`
+	Charset string    `json:"charset,omitempty" form:"charset"`
+	Photo   *PhotoExt `json:"photo,omitempty" form:"-"` //照片元数据，已解析但没有元数据时为空对象
+	Media   *MediaExt `json:"media,omitempty" form:"-"` //音视频元数据，已解析但不能识别时为空对象
+}
+
+// MediaExt 从音视频容器头部解析出的元数据
+type MediaExt struct {
+	Duration   int64  `json:"duration,omitempty"`   //时长，单位毫秒
+	Width      int    `json:"width,omitempty"`      //视频显示宽，已按旋转角度调整
+	Height     int    `json:"height,omitempty"`     //视频显示高
+	VideoCodec string `json:"videoCodec,omitempty"` //视频编码，如 h264、hevc
+	AudioCodec string `json:"audioCodec,omitempty"` //音频编码，如 aac、mp3
+}
+
+// PhotoExt 从图片头部和 EXIF 解析出的照片元数据
+type PhotoExt struct {
+	TakenTime   int64    `json:"takenAt,omitempty"`     //拍摄时间，单位毫秒
+	Make        string   `json:"make,omitempty"`        //相机厂商
+	Model       string   `json:"model,omitempty"`       //相机型号
+	Width       int      `json:"width,omitempty"`       //按 orientation 旋转后的宽
+	Height      int      `json:"height,omitempty"`      //按 orientation 旋转后的高
+	Orientation int      `json:"orientation,omitempty"` //EXIF 方向，1-8
+	Latitude    *float64 `json:"latitude,omitempty"`    //纬度，南纬为负
+	Longitude   *float64 `json:"longitude,omitempty"`   //经度，西经为负
+	Altitude    *float64 `json:"altitude,omitempty"`    //海拔，单位米
 }
 
 type FileInfoLst []FileInfo
@@ -70,6 +70,11 @@ const (
 
 	CodeFailedToCreateSymlink  CodeType = 1061 //失败去创建符号链接
 	CodeGetAsyncTaskInfoFailed CodeType = 1062 // 获取异步任务状态失败
+	CodeNoPermission           CodeType = 1063 //无权限
+	CodeStorageTaskRunning     CodeType = 1064 //存储后台任务正在运行
+	CodeFsckReportNotFound     CodeType = 1065 //尚无 fsck 报告
+	CodeUnsupportedArchive     CodeType = 1066 //不支持的压缩包格式
+	CodeThumbBusy              CodeType = 1067 //缩略图生成繁忙，稍后重试
 )
 
 //错误码对应描述在此部分定义
@@ -90,6 +95,11 @@ func init() {
 	codeMessageMap[CodeUserIdError] = "User Id Error！Should Be Greater than or equal 1"
 	codeMessageMap[CodeCopyIdError] = "File Operation: DestPath could not be itself"
 	codeMessageMap[CodeNotEnoughSpace] = "Normal Upload: not enough space"
+	codeMessageMap[CodeNoPermission] = "Permission denied"
+	codeMessageMap[CodeStorageTaskRunning] = "Storage task is running"
+	codeMessageMap[CodeFsckReportNotFound] = "Fsck report not found"
+	codeMessageMap[CodeUnsupportedArchive] = "Unsupported archive format"
+	codeMessageMap[CodeThumbBusy] = "Too many thumbnail requests, retry later"
 }
 
 // GetMessageByCode 根据错误码获取描述
@@ -42,6 +42,19 @@ type GetListReq struct {
 	IsDir    bool   `json:"isDir" form:"isDir"`
 	OrderBy  string `json:"orderBy" form:"orderBy"`
 	Category string `json:"category" form:"category"`
+	PhotoFilter
+}
+
+// PhotoFilter 按照片元数据筛选，未设置的条件不生效
+type PhotoFilter struct {
+	TakenAfter  int64  `json:"takenAfter" form:"takenAfter"`   //拍摄时间不早于，单位毫秒
+	TakenBefore int64  `json:"takenBefore" form:"takenBefore"` //拍摄时间早于，单位毫秒
+	Camera      string `json:"camera" form:"camera"`           //相机厂商或型号，模糊匹配
+	HasLocation bool   `json:"hasLocation" form:"hasLocation"` //只返回带拍摄位置的照片
+}
+
+func (f *PhotoFilter) IsSet() bool {
+	return *f != PhotoFilter{}
 }
 
 type GetListRspData struct {
@@ -88,6 +101,31 @@ type PathName struct {
 	Name string `json:"name" form:"name"`
 }
 
+// ArchiveReq 打包下载参数
+type ArchiveReq struct {
+	Uuids []string `json:"uuids" form:"uuids" binding:"required"` //要打包的文件和文件夹
+	Name  string   `json:"name" form:"name"`                      //压缩包文件名，默认为第一项的名称
+}
+
+// ExtractReq 解压参数
+type ExtractReq struct {
+	Uuid     string `json:"uuid" form:"uuid" binding:"required"` //压缩包文件，支持 zip、tar、tar.gz
+	FolderId string `json:"folderId" form:"folderId"`            //解压到的文件夹，为空时在压缩包所在目录下新建同名文件夹
+}
+
+// ZipEntry zip 压缩包中的一项
+type ZipEntry struct {
+	Name           string `json:"name"` //包内完整路径，文件夹以 / 结尾
+	IsDir          bool   `json:"isDir"`
+	Size           int64  `json:"size"` //解压后大小
+	CompressedSize int64  `json:"compressedSize"`
+	ModifyTime     int64  `json:"modifyAt"` //毫秒
+}
+
+type ZipEntriesRsp struct {
+	Entries []ZipEntry `json:"entries"`
+}
+
 // Uuids --查询返回值，复制文件夹时候用到
 type Uuids struct {
 	CopyUuid string `gorm:"column:uuid" json:"copyUuid" form:"copyUuid"`
@@ -99,6 +137,7 @@ type SearchReq struct {
 	ObjectName string `json:"name" form:"name"`         //搜索的对象名
 	Category   string `json:"category" form:"category"` //分类
 	OrderBy    string `json:"orderBy" form:"orderBy"`   //排序
+	PhotoFilter
 }
 
 //获取单个文件信息
@@ -113,7 +152,7 @@ type FileInfoForInnerReq = Fid
 type FileInfoForTrendsReq = Fids
 type FileInfoForInnerRsp struct {
 	FileInfoForTrends
-	RelativePath string `json:"relativePath" form:"relativePath"`
+	RelativePath string `json:"relativePath" form:"relativePath"` //加密或压缩存储的对象为空
 }
 
 type FileInfoForTrendsRsp struct {
@@ -131,7 +170,7 @@ type VodSymlinkRsp struct {
 
 type FileInfoForTrends struct {
 	FileInfo
-	Duration int64 `json:"duration" form:"duration"`
+	Duration int64 `json:"duration" form:"duration"` //音视频时长，单位毫秒；尚未解析元数据的旧文件沿用 t_photo_exif 中的值
 }
 
 type FileChangePushMsg struct {
@@ -0,0 +1,58 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// fsck 发现的问题类型
+const (
+	FsckUnindexed    = "unindexed"       //盘上有文件，没有 betag 索引
+	FsckDuplicate    = "duplicate"       //索引指向其他盘，本盘多出一份
+	FsckMissing      = "missing"         //有索引，文件不存在
+	FsckUnreferenced = "unreferenced"    //有索引，没有文件记录引用
+	FsckStaleTmp     = "stale_tmp"       //遗留的 .tmp 文件
+	FsckStaleMP      = "stale_multipart" //没有任务信息的分片上传数据
+	FsckOrphanDerive = "orphan_derive"   //预览图等派生文件没有对应的对象
+)
+
+// fsck 修复动作
+const (
+	FsckActionNone       = ""           //只报告
+	FsckActionReindex    = "reindex"    //重建索引
+	FsckActionQuarantine = "quarantine" //移到隔离目录
+	FsckActionDelete     = "delete"     //删除文件或索引
+	FsckActionLost       = "lost"       //对象已丢失且仍被引用，无法修复
+)
+
+type FsckIssue struct {
+	Kind   string `json:"kind"`
+	BETag  string `json:"betag,omitempty"`
+	VolId  int    `json:"volId"`
+	Path   string `json:"path,omitempty"`
+	Action string `json:"action,omitempty"` //修复模式下执行的动作
+	Err    string `json:"err,omitempty"`
+}
+
+type FsckReport struct {
+	Repair    bool           `json:"repair"`
+	StartTime int64          `json:"startAt"`
+	EndTime   int64          `json:"endAt"`
+	Counts    map[string]int `json:"counts"` //各类问题的数量
+	Issues    []FsckIssue    `json:"issues"` //问题明细，最多保留 FsckMaxIssues 条
+}
+
+const FsckMaxIssues = 1000
+
+type FsckReq struct {
+	Repair bool `json:"repair" form:"repair"` //false: 只检查并报告; true: 修复
+}
@@ -32,6 +32,16 @@ type CreateMultipartTaskParam struct {
 
 type CreateMultipartTaskReq = CreateMultipartTaskParam
 
+// UploadFileReq 单次请求上传小文件的参数，文件内容在请求体中
+type UploadFileReq struct {
+	FileName   string `json:"fileName" form:"fileName" binding:"required"`
+	FolderId   string `json:"folderId" form:"folderId"`
+	FolderPath string `json:"folderPath" form:"folderPath"`
+	BETag      string `json:"betag" form:"betag"` //可选，提供时校验内容并支持秒传
+	CreateTime int64  `json:"createTime" form:"createTime"`
+	ModifyTime int64  `json:"modifyTime" form:"modifyTime"`
+}
+
 type CreateMultipartTaskSuccRsp struct {
 	UploadId string `json:"uploadId" form:"uploadId"`
 	PartSize int64  `json:"partSize"`
@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// UserQuota 用户空间配额，没有记录或 Limit 为 0 表示不限制
+type UserQuota struct {
+	UserId     UserIdType `gorm:"column:user_id;PRIMARY_KEY" json:"userId"`
+	Limit      int64      `gorm:"column:quota_limit" json:"limit"` //单位字节
+	UpdateTime int64      `gorm:"column:update_time" json:"updateAt"`
+}
+
+func (UserQuota) TableName() string {
+	return "aofs_user_quotas"
+}
+
+type SetQuotaReq struct {
+	TargetUserId UserIdType `json:"targetUserId" form:"targetUserId" binding:"required"`
+	Limit        int64      `json:"limit" form:"limit" validate:"gte=0"` //单位字节，0 表示不限制
+}
+
+type QuotaRsp struct {
+	UserId UserIdType `json:"userId"`
+	Limit  int64      `json:"limit"`
+	Used   int64      `json:"used"`
+}
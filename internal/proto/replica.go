@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// BETagReplica 对象主副本之外的副本，主副本所在盘见 BETagInfo.VolId
+type BETagReplica struct {
+	BETag      string `gorm:"column:betag;primaryKey" json:"betag"`
+	VolId      uint16 `gorm:"column:vol_id;primaryKey" json:"volId"`
+	CreateTime int64  `gorm:"column:created_time" json:"createdAt"`
+}
+
+func (BETagReplica) TableName() string {
+	return "aofs_betag_replicas"
+}
+
+// ReplicaRule 副本规则，文件夹（含子文件夹）下或指定分类的文件保持 Copies 份，FolderId 和 Category 只设置一个
+type ReplicaRule struct {
+	Id         uint       `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	UserId     UserIdType `gorm:"column:user_id;index" json:"userId"`
+	FolderId   string     `gorm:"column:folder_id" json:"folderId"`
+	Category   string     `gorm:"column:category" json:"category"`
+	Copies     int        `gorm:"column:copies" json:"copies"`
+	CreateTime int64      `gorm:"column:created_time" json:"createdAt"`
+}
+
+func (ReplicaRule) TableName() string {
+	return "aofs_replica_rules"
+}
+
+type AddReplicaRuleReq struct {
+	FolderId string `json:"folderId" form:"folderId"`
+	Category string `json:"category" form:"category"`                    //如 picture、video
+	Copies   int    `json:"copies" form:"copies" validate:"gte=2,lte=8"` //包括主副本在内的份数
+}
+
+type DeleteReplicaRuleReq struct {
+	Id uint `json:"id" form:"id" binding:"required"`
+}
+
+type ReplicaRepairReq struct {
+	RateLimit int64 `json:"rateLimit" form:"rateLimit"` //复制限速，单位字节/秒，不传使用默认配置
+}
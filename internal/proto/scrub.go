@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// 对象校验失败原因
+const (
+	FaultMissing    = "missing"    //对象文件不存在
+	FaultMismatch   = "mismatch"   //内容与 betag 不一致
+	FaultUnreadable = "unreadable" //读取失败
+)
+
+// BETagFault 校验失败的对象，重新校验通过后删除
+type BETagFault struct {
+	BETag      string `gorm:"column:betag;PRIMARY_KEY" json:"betag"`
+	VolId      uint16 `gorm:"column:vol_id" json:"volId"`
+	Reason     string `gorm:"column:reason" json:"reason"`
+	Actual     string `gorm:"column:actual" json:"actual"` //实际计算出的 betag
+	Message    string `gorm:"column:message" json:"message"`
+	DetectTime int64  `gorm:"column:detect_time" json:"detectAt"`
+}
+
+func (BETagFault) TableName() string {
+	return "aofs_betag_faults"
+}
+
+type ScrubReq struct {
+	RateLimit int64 `json:"rateLimit" form:"rateLimit"` //读取限速，单位字节/秒，不传使用默认配置
+}
+
+type ListFaultsRsp struct {
+	List     []BETagFault `json:"list"`
+	PageInfo PageInfoExt  `json:"pageInfo"`
+}
+
+// StorageFaultPushMsg 对象损坏通知，uuids 为该用户受影响的文件
+type StorageFaultPushMsg struct {
+	BETag  string   `json:"betag"`
+	Reason string   `json:"reason"`
+	Uuids  []string `json:"uuids"`
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// AdminUserId 管理员用户，存储管理类接口只允许管理员调用
+const AdminUserId UserIdType = 1
+
+type RebalanceReq struct {
+	RateLimit int64 `json:"rateLimit" form:"rateLimit"` //搬迁限速，单位字节/秒，不传使用默认配置
+}
+
+type TieringReq struct {
+	RateLimit int64 `json:"rateLimit" form:"rateLimit"` //搬迁限速，单位字节/秒，不传使用默认配置
+}
+
+type DrainDiskReq struct {
+	DiskId    int   `json:"diskId" form:"diskId" binding:"required"` //盘序号，即 DeviceSequenceNumber
+	Cancel    bool  `json:"cancel" form:"cancel"`                    //取消下线
+	RateLimit int64 `json:"rateLimit" form:"rateLimit"`              //搬迁限速，单位字节/秒，不传使用默认配置
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proto
+
+// UserUsage 用户已用空间。Logical 为所有文件记录大小之和；Physical 按 betag 去重，同一用户相同内容只计一次。
+// 文件记录在物理清除前（包括回收站中的文件）都计入。
+type UserUsage struct {
+	UserId     UserIdType `gorm:"column:user_id;PRIMARY_KEY" json:"userId"`
+	Logical    int64      `gorm:"column:logical" json:"logical"`
+	Physical   int64      `gorm:"column:physical" json:"physical"`
+	UpdateTime int64      `gorm:"column:update_time" json:"updateAt"`
+}
+
+func (UserUsage) TableName() string {
+	return "aofs_user_usages"
+}
@@ -19,5 +19,7 @@ type User struct {
 }
 
 type Storage struct {
-	UserStorage int64 `json:"userStorage" form:"userStorage"`
+	UserStorage    int64 `json:"userStorage" form:"userStorage"`       //按 betag 去重后的已用空间
+	LogicalStorage int64 `json:"logicalStorage" form:"logicalStorage"` //不去重的已用空间
+	Limit          int64 `json:"limit" form:"limit"`                   //空间配额，0 表示不限制
 }
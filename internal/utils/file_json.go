@@ -31,17 +31,16 @@ func WriteJsonToFile(file string, obj interface{}) error {
 	dir, _ := filepath.Split(file)
 	os.MkdirAll(dir, os.ModePerm)
 
-	if f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, os.ModePerm); err != nil {
+	if f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm); err != nil {
 		return err
 	} else {
+		defer f.Close()
 		if n, err := f.Write(data); err != nil {
 			return err
 		} else if n != len(data) {
 			return fmt.Errorf("The length of data written is insufficient.")
 		}
-		f.Sync()
-		f.Close()
-		return nil
+		return f.Sync()
 	}
 }
 
@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter 按字节数限速，用于后台搬迁、校验等任务，避免占满磁盘带宽
+type RateLimiter struct {
+	mu   sync.Mutex
+	rate int64     //每秒字节数，<=0 不限速
+	next time.Time //下一次允许读写的时间
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSec}
+}
+
+// Wait 消耗 n 个字节的额度，额度不足时阻塞
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || l.rate <= 0 || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.l.Wait(n)
+	return n, err
+}
+
+// NewLimitedReader 返回按 l 限速的 Reader，l 为 nil 时直接返回 r
+func NewLimitedReader(r io.Reader, l *RateLimiter) io.Reader {
+	if l == nil || l.rate <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
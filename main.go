@@ -36,8 +36,15 @@ import (
 	"aofs/routers/api"
 	_ "aofs/routers/api/docs"
 	"aofs/routers/routers"
+	"aofs/services/fsck"
 	"aofs/services/multipart"
+	"aofs/services/rebalance"
 	"aofs/services/recycled"
+	"aofs/services/replica"
+	"aofs/services/scrub"
+	"aofs/services/thumb"
+	"aofs/services/tiering"
+	"aofs/services/usage"
 	"fmt"
 
 	"os"
@@ -82,6 +89,13 @@ func Init() {
 	api.Init()
 	recycled.Init() //回收站初始化
 	multipart.Init()
+	rebalance.Init()
+	scrub.Init()
+	fsck.Init()
+	usage.Init()
+	tiering.Init()
+	replica.Init()
+	thumb.Init()
 }
 
 func main() {
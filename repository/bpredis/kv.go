@@ -18,8 +18,6 @@ import (
 	"time"
 )
 
-const UsedSpace = "UsedSpace-"
-
 func (br *bpRedis) Set(key string, value interface{}, expiration time.Duration) error {
 	err := br.Client.Set(key, value, expiration).Err()
 	if err != nil {
@@ -14,7 +14,10 @@
 
 package dbutils
 
-import "aofs/internal/proto"
+import (
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+)
 
 type betagIndex struct {
 }
@@ -54,6 +57,135 @@ func (*betagIndex) Add(betag string, volId int) error {
 	return trans.addBETagInfo(betag, volId)
 }
 
+func (*betagIndex) Update(betag string, volId int) error {
+	trans, err := newTrans()
+	if err != nil {
+		return err
+	}
+
+	defer trans.Commit()
+	return trans.updateBETagInfo(betag, volId)
+}
+
+// Count 实现 storage.IndexCounter，副本也计入，盘上还有副本时不能移除
+func (*betagIndex) Count(volId int) (int64, error) {
+	count, err := CountBETagByVol(volId)
+	if err != nil {
+		return 0, err
+	}
+	replicas, err := CountReplicasByVol(volId)
+	return count + replicas, err
+}
+
+// CountByDisk 实现 storage.DiskObjectCounter，主副本和副本分开统计，对象大小取引用该 betag 的文件记录
+func (*betagIndex) CountByDisk() (map[int]storage.DiskObjects, error) {
+	var rows []struct {
+		VolId        int   `gorm:"column:vol_id"`
+		Objects      int64 `gorm:"column:objects"`
+		Bytes        int64 `gorm:"column:bytes"`
+		Replicas     int64 `gorm:"column:replicas"`
+		ReplicaBytes int64 `gorm:"column:replica_bytes"`
+	}
+	result := db.Raw(`SELECT o.vol_id,
+			COUNT(*) FILTER (WHERE NOT o.replica) AS objects,
+			COALESCE(SUM(f.size) FILTER (WHERE NOT o.replica), 0) AS bytes,
+			COUNT(*) FILTER (WHERE o.replica) AS replicas,
+			COALESCE(SUM(f.size) FILTER (WHERE o.replica), 0) AS replica_bytes
+		FROM (SELECT betag, vol_id, false AS replica FROM aofs_betag_infos
+			UNION ALL SELECT betag, vol_id, true AS replica FROM aofs_betag_replicas) o
+		LEFT JOIN (SELECT betag, MAX(size) AS size FROM aofs_file_infos GROUP BY betag) f
+		ON f.betag = o.betag GROUP BY o.vol_id`).Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	objects := make(map[int]storage.DiskObjects, len(rows))
+	for _, row := range rows {
+		objects[row.VolId] = storage.DiskObjects{Objects: row.Objects, Bytes: row.Bytes,
+			Replicas: row.Replicas, ReplicaBytes: row.ReplicaBytes}
+	}
+	return objects, nil
+}
+
 func NewBETagIndexer() *betagIndex {
 	return &betagIndex{}
 }
+
+// BETagObject 对象索引及其大小，大小取引用该 betag 的文件记录
+type BETagObject struct {
+	BETag string `gorm:"column:betag"`
+	VolId int    `gorm:"column:vol_id"`
+	Size  int64  `gorm:"column:size"`
+	Tier  uint8  `gorm:"column:tier"`
+}
+
+// GetBETagObjectsByVol 按 betag 升序分页获取 volId 盘上的对象
+func GetBETagObjectsByVol(volId int, after string, limit int) ([]BETagObject, error) {
+	var objs []BETagObject
+	result := db.Raw(`SELECT b.betag, b.vol_id, b.tier, COALESCE(MAX(f.size), 0) AS size
+		FROM aofs_betag_infos b LEFT JOIN aofs_file_infos f ON f.betag = b.betag
+		WHERE b.vol_id = ? AND b.betag > ?
+		GROUP BY b.betag, b.vol_id, b.tier ORDER BY b.betag LIMIT ?`, volId, after, limit).Scan(&objs)
+	return objs, result.Error
+}
+
+// CountBETagByVol 统计 volId 盘上的对象数
+func CountBETagByVol(volId int) (int64, error) {
+	var count int64
+	result := db.Model(proto.BETagInfo{}).Where("vol_id=?", volId).Count(&count)
+	return count, result.Error
+}
+
+func GetBETagInfo(betag string) (*proto.BETagInfo, error) {
+	var bi proto.BETagInfo
+	if err := db.Model(proto.BETagInfo{}).Where("betag=?", betag).First(&bi).Error; err != nil {
+		return nil, err
+	}
+	return &bi, nil
+}
+
+// CountFilesByBETag 统计引用 betag 的文件记录数，包括回收站中的文件
+func CountFilesByBETag(betag string) (count int64, err error) {
+	err = db.Model(proto.FileInfo{}).Where("betag = ?", betag).Count(&count).Error
+	return count, err
+}
+
+// GetBETagSize 获取对象的原始大小，取引用该 betag 的文件记录，与加密或压缩后的文件大小无关
+func GetBETagSize(betag string) (size int64, err error) {
+	err = db.Model(proto.FileInfo{}).Select("COALESCE(MAX(size), 0)").Where("betag = ?", betag).Scan(&size).Error
+	return size, err
+}
+
+// TieringObject 参与分层判断的对象，大小和分类取引用该 betag 的文件记录
+type TieringObject struct {
+	BETag      string `gorm:"column:betag"`
+	VolId      int    `gorm:"column:vol_id"`
+	AccessTime int64  `gorm:"column:access_time"` //未访问过时为创建时间，单位秒
+	RecallTime int64  `gorm:"column:recall_time"` //最近因访问搬回主存储的时间，单位秒
+	Size       int64  `gorm:"column:size"`
+	Category   string `gorm:"column:category"`
+}
+
+// GetTieringObjects 按 betag 升序分页获取 tier 层上仍被文件引用的对象
+func GetTieringObjects(tier uint8, after string, limit int) ([]TieringObject, error) {
+	var objs []TieringObject
+	result := db.Raw(`SELECT b.betag, b.vol_id, GREATEST(b.access_time, b.created_time) AS access_time, b.recall_time,
+		MAX(f.size) AS size, MAX(f.category) AS category
+		FROM aofs_betag_infos b JOIN aofs_file_infos f ON f.betag = b.betag
+		WHERE b.tier = ? AND b.betag > ?
+		GROUP BY b.betag, b.vol_id, b.access_time, b.created_time, b.recall_time ORDER BY b.betag LIMIT ?`, tier, after, limit).Scan(&objs)
+	return objs, result.Error
+}
+
+func SetBETagTier(betag string, tier uint8) error {
+	return db.Model(proto.BETagInfo{}).Where("betag=?", betag).Update("tier", tier).Error
+}
+
+// SetBETagRecalled 记录对象因访问搬回主存储，同时更新访问时间
+func SetBETagRecalled(betag string, now int64) error {
+	return db.Model(proto.BETagInfo{}).Where("betag=?", betag).
+		Updates(map[string]interface{}{"recall_time": now, "access_time": now}).Error
+}
+
+func UpdateBETagAccessTime(betag string, accessTime int64) error {
+	return db.Model(proto.BETagInfo{}).Where("betag=?", betag).Update("access_time", accessTime).Error
+}
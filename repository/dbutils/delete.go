@@ -267,9 +267,27 @@ func RecycledFromPhyToException(uuid string) (affect int, err error) {
 
 func DeleteByUuid(uuid string) (affect int64, err error) {
 
-	tx := db.Delete(&proto.FileInfo{}, "uuid = ?", uuid)
-	affect = tx.RowsAffected
-	err = tx.Error
+	tx := db.Begin()
+
+	//删除记录和更新已用空间在同一事务中完成
+	var fi proto.FileInfo
+	if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("uuid = ?", uuid).Find(&fi).Error; err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	res := tx.Delete(&proto.FileInfo{}, "uuid = ?", uuid)
+	if res.Error != nil {
+		tx.Rollback()
+		return 0, res.Error
+	}
+	if affect = res.RowsAffected; affect > 0 {
+		if err = addUsage(tx, &fi, -1); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	err = tx.Commit().Error
 	return
 }
 
@@ -16,6 +16,7 @@ package dbutils
 
 import (
 	"aofs/internal/proto"
+	"strings"
 )
 
 func GetFileInfoWithUid(userId proto.UserIdType, uuid string) (*proto.FileInfo, error) {
@@ -27,15 +28,17 @@ func GetFileInfoWithUid(userId proto.UserIdType, uuid string) (*proto.FileInfo,
 	return &file, nil
 }
 
+// durationColumn 音视频时长，优先取 ext 中解析出的元数据，单位毫秒。
+// 尚未解析的文件沿用 t_photo_exif 中相册服务写入的时长，原样返回，执行媒体元数据补全后以 ext 为准。
+const durationColumn = `COALESCE(("aofs_file_infos".ext->'media'->>'duration')::bigint, e.duration, 0) AS duration`
+
+const photoExifJoin = `LEFT JOIN t_photo_exif e ON "aofs_file_infos".uuid = e.uuid`
+
 func GetFileInfoForTrends(userId proto.UserIdType, uuid string) (*proto.FileInfoForTrends, error) {
 	var file proto.FileInfoForTrends
-	value := db.First(&file, "user_id= ? AND uuid = ?", userId, uuid)
-	db.Debug().Model(&proto.FileInfo{}).
-		Select("\"aofs_file_infos\".*, e.duration").
-		Joins("left join t_photo_exif e on \"aofs_file_infos\".uuid = e.uuid ").
-		Where("\"aofs_file_infos\".user_id = ? AND \"aofs_file_infos\".uuid = ?", userId, uuid).
-		Scan(&file)
-
+	value := db.Model(&proto.FileInfo{}).Select(`"aofs_file_infos".*, `+durationColumn).Joins(photoExifJoin).
+		Where(`"aofs_file_infos".user_id = ? AND "aofs_file_infos".uuid = ?`, userId, uuid).
+		Take(&file)
 	if value.Error != nil {
 		return nil, value.Error
 	}
@@ -44,11 +47,8 @@ func GetFileInfoForTrends(userId proto.UserIdType, uuid string) (*proto.FileInfo
 
 func GetFileInfosForTrends(userId proto.UserIdType, uuids []string) ([]proto.FileInfoForTrends, error) {
 	var file []proto.FileInfoForTrends
-	//value := db.First(&file, "user_id= ? AND uuid = ?", userId, uuid)
-	res := db.Model(&proto.FileInfo{}).
-		Select("\"aofs_file_infos\".*, e.duration").
-		Joins("left join t_photo_exif e on \"aofs_file_infos\".uuid = e.uuid ").
-		Where("\"aofs_file_infos\".user_id = ? AND \"aofs_file_infos\".uuid IN (?)", userId, uuids).
+	res := db.Model(&proto.FileInfo{}).Select(`"aofs_file_infos".*, `+durationColumn).Joins(photoExifJoin).
+		Where(`"aofs_file_infos".user_id = ? AND "aofs_file_infos".uuid IN (?)`, userId, uuids).
 		Scan(&file)
 
 	if res.Error != nil {
@@ -113,3 +113,42 @@ func GetSubFilesInUuids(userId proto.UserIdType, uuids []string, trashed []uint3
 	logdb.LogD().Interface("subUuids", allSubFiles).Msg("print sub  uuids")
 	return allSubFiles, nil
 }
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetFolderFilesSize 统计文件夹下（含各级子文件夹）正常状态文件的总大小
+func GetFolderFilesSize(userId proto.UserIdType, absPath string) (size int64, err error) {
+	err = db.Model(&proto.FileInfo{}).Select("COALESCE(SUM(size), 0)").
+		Where("user_id = ? AND is_dir = false AND trashed = ? AND path LIKE ?", userId, proto.TrashStatusNormal, likeEscaper.Replace(absPath)+"%").
+		Scan(&size).Error
+	return size, err
+}
+
+// GetSelectionSize 统计选中的文件和文件夹的总大小，文件夹包含其下所有文件
+func GetSelectionSize(userId proto.UserIdType, uuids []string) (int64, error) {
+	var total int64
+	for _, uuid := range uuids {
+		fi, err := GetFileInfoWithUid(userId, uuid)
+		if err != nil {
+			return 0, err
+		}
+		if !fi.IsDir {
+			total += fi.Size
+			continue
+		}
+		size, err := GetFolderFilesSize(userId, fi.AbsPath())
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// GetFileInfosInFolder 获取文件夹下（含各级子文件夹）所有正常状态的文件和文件夹，按路径排序
+func GetFileInfosInFolder(userId proto.UserIdType, absPath string) ([]proto.FileInfo, error) {
+	var files []proto.FileInfo
+	err := db.Model(&proto.FileInfo{}).Where("user_id = ? AND path LIKE ? AND trashed = ?", userId, likeEscaper.Replace(absPath)+"%", proto.TrashStatusNormal).
+		Order("path, name").Find(&files).Error
+	return files, err
+}
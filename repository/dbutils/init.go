@@ -251,5 +251,15 @@ func createTables() {
 	CreateTable(proto.BETagInfo{})
 	CreateTable(proto.FileInfo{})
 	CreateTable(proto.SyncInfo{})
+	CreateTable(proto.BETagFault{})
+	CreateTable(proto.UserQuota{})
+	CreateTable(proto.UserUsage{})
+	CreateTable(proto.BETagReplica{})
+	CreateTable(proto.ReplicaRule{})
+
+	if err := registerUsageCallbacks(db); err != nil {
+		logdb.LogF().Err(err).Msg("failed to register callbacks")
+		panic(any(err))
+	}
 
 }
@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbutils
+
+import (
+	"aofs/internal/proto"
+	"encoding/json"
+
+	"gorm.io/gorm"
+)
+
+// FileFilter 按元数据筛选文件列表
+type FileFilter struct {
+	Path       string   //只返回该目录下的直接子项，为空不限制
+	Name       string   //文件名模糊匹配，为空不限制
+	Categories []string //为空不限制
+	IsDir      bool     //只返回文件夹
+	Photo      proto.PhotoFilter
+}
+
+// FilterFiles 分页获取符合条件的文件，同时返回总数
+func FilterFiles(userId proto.UserIdType, f *FileFilter, order string, page uint32, pageSize uint32) (proto.FileInfoLst, int64, error) {
+	tx := db.Model(&proto.FileInfo{}).Where("user_id = ? AND trashed = ?", userId, 0)
+	if len(f.Path) > 0 {
+		tx = tx.Where("path = ?", f.Path)
+	}
+	if len(f.Name) > 0 {
+		tx = tx.Where("name ILIKE ?", "%"+f.Name+"%")
+	}
+	if len(f.Categories) > 0 {
+		tx = tx.Where("category IN ?", f.Categories)
+	}
+	if f.IsDir {
+		tx = tx.Where("is_dir = true")
+	}
+	tx = tx.Scopes(photoScope(&f.Photo))
+
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var fileInfos proto.FileInfoLst
+	err := tx.Order(order).Limit(int(pageSize)).Offset(int(page-1) * int(pageSize)).Find(&fileInfos).Error
+	return fileInfos, total, err
+}
+
+// photoScope 照片元数据条件，没有解析出对应字段的文件不会匹配
+func photoScope(f *proto.PhotoFilter) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if f.TakenAfter > 0 {
+			tx = tx.Where("(ext->'photo'->>'takenAt')::bigint >= ?", f.TakenAfter)
+		}
+		if f.TakenBefore > 0 {
+			tx = tx.Where("(ext->'photo'->>'takenAt')::bigint < ?", f.TakenBefore)
+		}
+		if len(f.Camera) > 0 {
+			tx = tx.Where("concat_ws(' ', ext->'photo'->>'make', ext->'photo'->>'model') ILIKE ?", "%"+likeEscaper.Replace(f.Camera)+"%")
+		}
+		if f.HasLocation {
+			tx = tx.Where("ext->'photo'->'latitude' IS NOT NULL")
+		}
+		return tx
+	}
+}
+
+// 需要解析元数据的文件，与 services/media 中的判断一致
+const (
+	photoCond = "category = 'picture'"
+	mediaCond = "(mime LIKE 'video/%' OR mime LIKE 'audio/%')"
+)
+
+// MediaObject 待解析元数据的对象，大小取引用该 betag 的文件记录
+type MediaObject struct {
+	BETag string `gorm:"column:betag"`
+	Size  int64  `gorm:"column:size"`
+}
+
+// GetPhotosWithoutExt 按 betag 升序分页获取还没有解析照片元数据的图片
+func GetPhotosWithoutExt(after string, limit int) ([]MediaObject, error) {
+	return objectsWithoutExt(photoCond, "photo", after, limit)
+}
+
+// GetMediaWithoutExt 按 betag 升序分页获取还没有解析元数据的音视频
+func GetMediaWithoutExt(after string, limit int) ([]MediaObject, error) {
+	return objectsWithoutExt(mediaCond, "media", after, limit)
+}
+
+func objectsWithoutExt(cond string, key string, after string, limit int) ([]MediaObject, error) {
+	var objs []MediaObject
+	result := db.Raw(`SELECT betag, MAX(size) AS size FROM aofs_file_infos
+		WHERE `+cond+` AND is_dir = false AND betag > ? AND ext->'`+key+`' IS NULL
+		GROUP BY betag ORDER BY betag LIMIT ?`, after, limit).Scan(&objs)
+	return objs, result.Error
+}
+
+// SetPhotoExt 写入引用该 betag 的所有图片的照片元数据，保留 ext 中的其他字段
+func SetPhotoExt(betag string, photo *proto.PhotoExt) error {
+	return setExt(photoCond, "photo", betag, photo)
+}
+
+// SetMediaExt 写入引用该 betag 的所有音视频的元数据，保留 ext 中的其他字段
+func SetMediaExt(betag string, media *proto.MediaExt) error {
+	return setExt(mediaCond, "media", betag, media)
+}
+
+func setExt(cond string, key string, betag string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return db.Model(&proto.FileInfo{}).Where("betag = ? AND "+cond, betag).
+		Update("ext", gorm.Expr(`(CASE WHEN jsonb_typeof(ext) = 'object' THEN ext ELSE '{}'::jsonb END) ||
+			jsonb_build_object(?::text, ?::jsonb)`, key, string(data))).Error
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbutils
+
+import (
+	"aofs/internal/proto"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetUserQuota 获取用户配额，未设置时返回 0
+func GetUserQuota(userId proto.UserIdType) (int64, error) {
+	var quota proto.UserQuota
+	err := db.Model(proto.UserQuota{}).Where("user_id = ?", userId).First(&quota).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return quota.Limit, err
+}
+
+// SetUserQuota 设置用户配额，已存在则更新
+func SetUserQuota(userId proto.UserIdType, limit int64) error {
+	quota := proto.UserQuota{UserId: userId, Limit: limit, UpdateTime: time.Now().UnixNano() / 1e6}
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&quota).Error
+}
+
+func DeleteUserQuota(userId proto.UserIdType) error {
+	return db.Delete(proto.UserQuota{}, "user_id = ?", userId).Error
+}
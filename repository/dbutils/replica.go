@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbutils
+
+import (
+	"aofs/internal/proto"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// GetReplicas、AddReplica、DeleteReplica 实现 storage.ReplicaIndexer
+func (*betagIndex) GetReplicas(betag string) ([]int, error) {
+	var volIds []int
+	err := db.Model(proto.BETagReplica{}).Where("betag=?", betag).Order("created_time").Pluck("vol_id", &volIds).Error
+	return volIds, err
+}
+
+func (*betagIndex) AddReplica(betag string, volId int) error {
+	replica := proto.BETagReplica{BETag: betag, VolId: uint16(volId), CreateTime: time.Now().Unix()}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&replica).Error
+}
+
+func (*betagIndex) DeleteReplica(betag string, volId int) error {
+	return db.Delete(proto.BETagReplica{}, "betag=? AND vol_id=?", betag, volId).Error
+}
+
+// GetReplicatedBETags 获取所有有副本的对象
+func GetReplicatedBETags() (betags []string, err error) {
+	err = db.Model(proto.BETagReplica{}).Distinct("betag").Pluck("betag", &betags).Error
+	return
+}
+
+// GetReplicaBETagsByVol 按 betag 升序分页获取 volId 盘上的副本
+func GetReplicaBETagsByVol(volId int, after string, limit int) (betags []string, err error) {
+	err = db.Model(proto.BETagReplica{}).Where("vol_id=? AND betag>?", volId, after).
+		Order("betag").Limit(limit).Pluck("betag", &betags).Error
+	return
+}
+
+// CountReplicasByVol 统计 volId 盘上的副本数
+func CountReplicasByVol(volId int) (count int64, err error) {
+	err = db.Model(proto.BETagReplica{}).Where("vol_id=?", volId).Count(&count).Error
+	return
+}
+
+func GetReplicaRules(userId proto.UserIdType) (rules []proto.ReplicaRule, err error) {
+	err = db.Model(proto.ReplicaRule{}).Where("user_id = ?", userId).Order("id").Find(&rules).Error
+	return
+}
+
+func GetAllReplicaRules() (rules []proto.ReplicaRule, err error) {
+	err = db.Model(proto.ReplicaRule{}).Order("id").Find(&rules).Error
+	return
+}
+
+func AddReplicaRule(rule *proto.ReplicaRule) error {
+	rule.CreateTime = time.Now().UnixNano() / 1e6
+	return db.Create(rule).Error
+}
+
+func DeleteReplicaRule(userId proto.UserIdType, id uint) (int64, error) {
+	res := db.Delete(proto.ReplicaRule{}, "user_id = ? AND id = ?", userId, id)
+	return res.RowsAffected, res.Error
+}
+
+func DeleteUserReplicaRules(userId proto.UserIdType) error {
+	return db.Delete(proto.ReplicaRule{}, "user_id = ?", userId).Error
+}
+
+// GetBETagsInFolder 获取文件夹下（含子文件夹）未删除文件的 betag
+func GetBETagsInFolder(userId proto.UserIdType, absPath string) (betags []string, err error) {
+	err = db.Model(proto.FileInfo{}).Distinct("betag").
+		Where("user_id = ? AND is_dir = false AND trashed = ? AND path LIKE ?", userId, proto.TrashStatusNormal,
+			likeEscaper.Replace(absPath)+"%").Pluck("betag", &betags).Error
+	return
+}
+
+// GetBETagsByCategory 获取某分类下未删除文件的 betag
+func GetBETagsByCategory(userId proto.UserIdType, category string) (betags []string, err error) {
+	err = db.Model(proto.FileInfo{}).Distinct("betag").
+		Where("user_id = ? AND is_dir = false AND trashed = ? AND category = ?", userId, proto.TrashStatusNormal, category).
+		Pluck("betag", &betags).Error
+	return
+}
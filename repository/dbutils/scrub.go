@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbutils
+
+import (
+	"aofs/internal/proto"
+
+	"gorm.io/gorm/clause"
+)
+
+// GetBETagInfos 按 betag 升序分页遍历对象索引
+func GetBETagInfos(after string, limit int) ([]proto.BETagInfo, error) {
+	var infos []proto.BETagInfo
+	result := db.Model(proto.BETagInfo{}).Where("betag > ?", after).Order("betag").Limit(limit).Find(&infos)
+	return infos, result.Error
+}
+
+// SaveBETagFault 记录校验失败的对象，已存在则更新
+func SaveBETagFault(fault proto.BETagFault) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&fault).Error
+}
+
+// DeleteBETagFault 对象重新校验通过或已删除时清除记录
+func DeleteBETagFault(betag string) (int64, error) {
+	result := db.Delete(proto.BETagFault{}, "betag=?", betag)
+	return result.RowsAffected, result.Error
+}
+
+func GetBETagFault(betag string) (*proto.BETagFault, error) {
+	var fault proto.BETagFault
+	if err := db.Model(proto.BETagFault{}).Where("betag=?", betag).First(&fault).Error; err != nil {
+		return nil, err
+	}
+	return &fault, nil
+}
+
+func ListBETagFaults(page uint32, pageSize uint32) (faults []proto.BETagFault, count int64, err error) {
+	if err = db.Model(proto.BETagFault{}).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+	err = db.Model(proto.BETagFault{}).Order("detect_time desc").
+		Offset(int((page - 1) * pageSize)).Limit(int(pageSize)).Find(&faults).Error
+	return faults, count, err
+}
+
+// GetFilesByBETag 获取引用 betag 的文件记录
+func GetFilesByBETag(betag string) (files []proto.FileInfo, err error) {
+	err = db.Model(proto.FileInfo{}).Where("betag = ?", betag).Find(&files).Error
+	return files, err
+}
+
+func CountBETagInfos() (count int64, err error) {
+	err = db.Model(proto.BETagInfo{}).Count(&count).Error
+	return count, err
+}
+
+// DeleteOrphanFaults 清除对象已被删除的校验失败记录
+func DeleteOrphanFaults() (int64, error) {
+	result := db.Exec("DELETE FROM aofs_betag_faults WHERE betag NOT IN (SELECT betag FROM aofs_betag_infos)")
+	return result.RowsAffected, result.Error
+}
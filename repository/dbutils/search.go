@@ -423,19 +423,6 @@ func GetSyncedFiles(deviceId string, path string, timestamp uint64, userId proto
 	return nil, err
 }
 
-func GetUsedSpaceByUser(userId proto.UserIdType) (storage int64, err error) {
-
-	err = db.Raw(fmt.Sprintf("select sum(size) from (select distinct(betag),size from \"aofs_file_infos\" "+
-		"where is_dir = false AND user_id = %d AND trashed in (%d,%d,%d)) as subQuery ",
-		userId, proto.TrashStatusNormal, proto.TrashStatusLogicDeleted,
-		proto.TrashStatusSubFilesLogicDeleted)).Pluck("subQuery", &storage).Error
-	if err != nil {
-		return 0, err
-	} else {
-		return storage, nil
-	}
-}
-
 //func GetBackupStatusByBoxId(boxId string) (status uint8,err error) {
 //}
 
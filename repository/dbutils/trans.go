@@ -282,3 +282,14 @@ func (tr *trans) addBETagInfo(betag string, volId int) error {
 	return result.Error
 
 }
+
+func (tr *trans) updateBETagInfo(betag string, volId int) error {
+	result := tr.tx.Model(proto.BETagInfo{}).Where("betag=?", betag).
+		Updates(map[string]interface{}{"vol_id": uint16(volId), "modify_time": time.Now().Unix()})
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dbutils
+
+//此文件维护用户已用空间计数：文件记录增删时在同一事务中更新，并提供按文件记录重新统计的校准
+
+import (
+	"aofs/internal/proto"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 同一用户还有其他记录引用该 betag 时，物理空间不变
+const usageDeltaSql = `UPDATE aofs_user_usages SET logical = logical + @size,
+	physical = physical + CASE WHEN EXISTS (SELECT 1 FROM aofs_file_infos
+		WHERE user_id = @user AND betag = @betag AND uuid <> @uuid AND is_dir = false) THEN 0 ELSE @size END,
+	update_time = @now WHERE user_id = @user`
+
+const usageCalcSql = `SELECT COALESCE(SUM(total), 0) AS logical, COALESCE(SUM(size), 0) AS physical
+	FROM (SELECT SUM(size) AS total, MAX(size) AS size FROM aofs_file_infos
+		WHERE user_id = ? AND is_dir = false GROUP BY betag) AS t`
+
+// addUsage 在 tx 中按文件记录增减计数，sign 为 1 表示新增、-1 表示删除。
+// 须在记录插入之后或删除之后调用；用户还没有计数时不处理，首次读取时会完整统计。
+func addUsage(tx *gorm.DB, fi *proto.FileInfo, sign int64) error {
+	if fi.IsDir || fi.UserId == 0 {
+		return nil
+	}
+	return tx.Exec(usageDeltaSql, map[string]interface{}{
+		"size":  sign * fi.Size,
+		"user":  fi.UserId,
+		"betag": fi.BETag,
+		"uuid":  fi.Id,
+		"now":   time.Now().UnixNano() / 1e6,
+	}).Error
+}
+
+// usageAfterCreate 插入文件记录后更新计数，与插入处于同一事务，失败时一并回滚
+func usageAfterCreate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != (proto.FileInfo{}).TableName() {
+		return
+	}
+
+	session := tx.Session(&gorm.Session{NewDB: true})
+	add := func(v reflect.Value) {
+		if fi, ok := v.Interface().(proto.FileInfo); ok {
+			if err := addUsage(session, &fi, 1); err != nil {
+				tx.AddError(err)
+			}
+		}
+	}
+
+	rv := reflect.Indirect(tx.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Struct:
+		add(rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(reflect.Indirect(rv.Index(i)))
+		}
+	}
+}
+
+func registerUsageCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().After("gorm:create").Register("aofs:usage", usageAfterCreate)
+}
+
+// GetUserUsage 获取用户已用空间计数，没有计数时返回 nil
+func GetUserUsage(userId proto.UserIdType) (*proto.UserUsage, error) {
+	var usage proto.UserUsage
+	err := db.Model(proto.UserUsage{}).Where("user_id = ?", userId).First(&usage).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// ReconcileUserUsage 按文件记录重新统计用户已用空间并保存，返回校准前后的计数。
+// 统计期间锁住计数行，并发的增删会等待校准完成后再累加。
+func ReconcileUserUsage(userId proto.UserIdType) (before proto.UserUsage, after proto.UserUsage, err error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return before, after, tx.Error
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit().Error
+		}
+	}()
+
+	now := time.Now().UnixNano() / 1e6
+	if err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&proto.UserUsage{UserId: userId, UpdateTime: now}).Error; err != nil {
+		return
+	}
+	if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userId).First(&before).Error; err != nil {
+		return
+	}
+
+	after = proto.UserUsage{UserId: userId, UpdateTime: now}
+	if err = tx.Raw(usageCalcSql, userId).Scan(&after).Error; err != nil {
+		return
+	}
+	err = tx.Model(proto.UserUsage{}).Where("user_id = ?", userId).
+		Updates(map[string]interface{}{"logical": after.Logical, "physical": after.Physical, "update_time": now}).Error
+	return
+}
+
+// GetUsageUserIds 获取有文件记录或已用空间计数的用户
+func GetUsageUserIds() (userIds []proto.UserIdType, err error) {
+	err = db.Raw("SELECT DISTINCT user_id FROM aofs_file_infos UNION SELECT user_id FROM aofs_user_usages").
+		Scan(&userIds).Error
+	return
+}
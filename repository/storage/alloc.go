@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 磁盘分配策略名称，通过环境变量 DISK_ALLOC_POLICY 选择
+const (
+	AllocMostFree     = "most-free"     //剩余空间最多的盘
+	AllocRoundRobin   = "round-robin"   //按盘序号轮询
+	AllocPrimaryFirst = "primary-first" //优先主存储，主存储写满后再用次存储
+	AllocFillFirst    = "fill-first"    //按盘序号依次写满
+)
+
+// AllocDisk 参与分配的候选盘
+type AllocDisk struct {
+	Id        int
+	Path      string
+	IsPrimary bool
+	Free      uint64
+}
+
+// AllocPolicy 磁盘分配策略。
+// disks 已按 Id 升序排列，且都满足 RESERVED_SPACE + size 的剩余空间要求，不会为空。
+type AllocPolicy interface {
+	Name() string
+	Select(disks []AllocDisk) AllocDisk
+}
+
+func NewAllocPolicy(name string) (AllocPolicy, error) {
+	switch name {
+	case AllocMostFree:
+		return mostFreePolicy{}, nil
+	case AllocRoundRobin:
+		return &roundRobinPolicy{last: -1}, nil
+	case AllocPrimaryFirst:
+		return primaryFirstPolicy{}, nil
+	case AllocFillFirst:
+		return fillFirstPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown disk alloc policy:%v", name)
+	}
+}
+
+type mostFreePolicy struct{}
+
+func (mostFreePolicy) Name() string { return AllocMostFree }
+
+func (mostFreePolicy) Select(disks []AllocDisk) AllocDisk {
+	sel := disks[0]
+	for _, d := range disks[1:] {
+		if d.Free > sel.Free {
+			sel = d
+		}
+	}
+	return sel
+}
+
+type roundRobinPolicy struct {
+	mu   sync.Mutex
+	last int //上次分配的盘序号
+}
+
+func (*roundRobinPolicy) Name() string { return AllocRoundRobin }
+
+// Select 选择序号大于上次分配的第一块盘，没有则从头开始
+func (p *roundRobinPolicy) Select(disks []AllocDisk) AllocDisk {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sel := disks[0]
+	for _, d := range disks {
+		if d.Id > p.last {
+			sel = d
+			break
+		}
+	}
+	p.last = sel.Id
+	return sel
+}
+
+type primaryFirstPolicy struct{}
+
+func (primaryFirstPolicy) Name() string { return AllocPrimaryFirst }
+
+// Select 主存储中选剩余空间最多的盘，没有可用主存储时再在次存储中选
+func (primaryFirstPolicy) Select(disks []AllocDisk) AllocDisk {
+	var primary []AllocDisk
+	for _, d := range disks {
+		if d.IsPrimary {
+			primary = append(primary, d)
+		}
+	}
+	if len(primary) > 0 {
+		return mostFreePolicy{}.Select(primary)
+	}
+	return mostFreePolicy{}.Select(disks)
+}
+
+type fillFirstPolicy struct{}
+
+func (fillFirstPolicy) Name() string { return AllocFillFirst }
+
+func (fillFirstPolicy) Select(disks []AllocDisk) AllocDisk {
+	return disks[0]
+}
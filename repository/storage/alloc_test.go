@@ -0,0 +1,206 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAllocPolicy(t *testing.T) {
+	disks := []AllocDisk{
+		{Id: 1, Free: 100, IsPrimary: false},
+		{Id: 2, Free: 300, IsPrimary: false},
+		{Id: 3, Free: 200, IsPrimary: true},
+		{Id: 4, Free: 50, IsPrimary: true},
+	}
+
+	tests := []struct {
+		policy string
+		want   []int
+	}{
+		{AllocMostFree, []int{2, 2, 2}},
+		{AllocRoundRobin, []int{1, 2, 3, 4, 1}},
+		{AllocPrimaryFirst, []int{3, 3}},
+		{AllocFillFirst, []int{1, 1}},
+	}
+
+	for _, tt := range tests {
+		p, err := NewAllocPolicy(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name() != tt.policy {
+			t.Errorf("name: got %v, want %v", p.Name(), tt.policy)
+		}
+		for i, want := range tt.want {
+			if got := p.Select(disks).Id; got != want {
+				t.Errorf("%v select #%v: got %v, want %v", tt.policy, i, got, want)
+			}
+		}
+	}
+
+	if _, err := NewAllocPolicy("random"); err == nil {
+		t.Error("unknown policy should fail")
+	}
+
+	// 没有主存储时退化为剩余空间最多
+	if got := (primaryFirstPolicy{}).Select(disks[:2]).Id; got != 2 {
+		t.Errorf("primary-first without primary: got %v", got)
+	}
+}
+
+func TestGenPath(t *testing.T) {
+	root := t.TempDir()
+	free := map[string]uint64{}
+	m := multiDisk{mapDisk: map[int]string{}, primary: map[int]bool{}}
+	for id, f := range map[int]uint64{1: 10 * GB, 2: 20 * GB, 3: 1 * GB} {
+		path := filepath.Join(root, string(rune('a'+id)))
+		m.mapDisk[id] = path
+		free[path] = f
+	}
+	m.primary[1] = true
+
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return DiskStatus{Free: free[path]} }
+	defer func() { diskUsage = old }()
+
+	oldReserved := env.RESERVED_SPACE
+	env.RESERVED_SPACE = 2 * GB
+	defer func() { env.RESERVED_SPACE = oldReserved }()
+
+	key := "0a1b2c3d"
+	tests := []struct {
+		policy string
+		size   int64
+		want   int
+		err    error
+	}{
+		{AllocPrimaryFirst, 1 * GB, 1, nil},
+		{AllocPrimaryFirst, 9 * GB, 2, nil}, //主存储空间不足
+		{AllocMostFree, 1 * GB, 2, nil},
+		{AllocFillFirst, 1 * GB, 1, nil},
+		{AllocFillFirst, 30 * GB, 0, ErrEnoughSpace},
+	}
+	for _, tt := range tests {
+		m.policy, _ = NewAllocPolicy(tt.policy)
+		diskId, dir, err := m.GenPath("bucketa", key, tt.size)
+		if err != tt.err {
+			t.Errorf("%v: err got %v, want %v", tt.policy, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if diskId != tt.want {
+			t.Errorf("%v: diskId got %v, want %v", tt.policy, diskId, tt.want)
+		}
+		if !strings.HasPrefix(dir, m.mapDisk[diskId]) || !strings.HasSuffix(dir, filepath.Join("bucketa", "0a", "1b")) {
+			t.Errorf("%v: unexpected dir %v", tt.policy, dir)
+		}
+	}
+}
+
+func TestGenPathSkipDraining(t *testing.T) {
+	root := t.TempDir()
+	oldDataPath := env.DATA_PATH
+	env.DATA_PATH = root
+	defer func() { env.DATA_PATH = oldDataPath }()
+
+	m := multiDisk{
+		mapDisk: map[int]string{1: filepath.Join(root, "d1"), 2: filepath.Join(root, "d2")},
+		primary: map[int]bool{1: true},
+		policy:  primaryFirstPolicy{},
+	}
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return DiskStatus{Free: 100 * GB} }
+	defer func() { diskUsage = old }()
+
+	if err := m.SetDraining(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDraining(2, true); err == nil {
+		t.Error("the last available disk should not be drained")
+	}
+	if diskId, _, err := m.GenPath("bucketa", "0a1b2c3d", 1); err != nil || diskId != 2 {
+		t.Errorf("draining disk allocated: %v, %v", diskId, err)
+	}
+	if diskId, _ := m.GetMultipartPath(); diskId != 2 {
+		t.Errorf("multipart path on draining disk: %v", diskId)
+	}
+
+	// 下线前开始的分片上传完成时改放到其他盘
+	m.indexer = &MockIndexer{mapDiskFile: map[string]int{}}
+	data := filepath.Join(root, "upload.data")
+	if err := ioutil.WriteFile(data, []byte("hello"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.MoveFile(data, 1, "bucketa", "0a1b2c3d4e", nil); err != nil {
+		t.Fatal(err)
+	}
+	if diskId, _ := m.indexer.Get("0a1b2c3d4e"); diskId != 2 || !m.objectExists(2, "bucketa", "0a1b2c3d4e") {
+		t.Errorf("object moved to draining disk: %v", diskId)
+	}
+
+	// 状态持久化，重新加载后保持
+	var m2 multiDisk
+	if err := m2.loadDiskState(); err != nil || !m2.IsDraining(1) {
+		t.Errorf("reload disk state: %v, %v", m2.draining, err)
+	}
+
+	if err := m.SetDraining(1, false); err != nil || m.IsDraining(1) {
+		t.Errorf("cancel draining: %v", err)
+	}
+}
+
+func TestAllocTierDisk(t *testing.T) {
+	m := multiDisk{
+		mapDisk: map[int]string{1: "/d1", 2: "/d2", 3: "/d3", 4: "/d4"},
+		primary: map[int]bool{1: true, 2: true},
+	}
+	free := map[string]uint64{"/d1": 10 * GB, "/d2": 20 * GB, "/d3": 5 * GB, "/d4": 8 * GB}
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return DiskStatus{Free: free[path]} }
+	defer func() { diskUsage = old }()
+
+	oldReserved := env.RESERVED_SPACE
+	env.RESERVED_SPACE = 2 * GB
+	defer func() { env.RESERVED_SPACE = oldReserved }()
+
+	tests := []struct {
+		primary bool
+		size    int64
+		want    int
+		err     error
+	}{
+		{true, 1 * GB, 2, nil},
+		{false, 1 * GB, 4, nil},
+		{false, 4 * GB, 4, nil},
+		{false, 7 * GB, 0, ErrEnoughSpace}, //次存储空间不足时不会选主存储
+	}
+	for _, tt := range tests {
+		diskId, err := m.AllocTierDisk(tt.primary, tt.size)
+		if err != tt.err || diskId != tt.want {
+			t.Errorf("AllocTierDisk(%v, %v) = %v, %v, want %v, %v", tt.primary, tt.size, diskId, err, tt.want, tt.err)
+		}
+	}
+	if !m.IsPrimary(1) || m.IsPrimary(3) {
+		t.Error("IsPrimary mismatch")
+	}
+}
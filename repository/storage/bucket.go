@@ -34,9 +34,10 @@ type MultiDisker interface {
 }
 
 type Indexer interface {
-	Get(key string) (int, error)      //获取索引信息
-	Delete(key string) (int, error)   //删除索引
-	Add(key string, diskId int) error //增加 1 条索引
+	Get(key string) (int, error)         //获取索引信息
+	Delete(key string) (int, error)      //删除索引
+	Add(key string, diskId int) error    //增加 1 条索引
+	Update(key string, diskId int) error //修改索引所在盘，用于对象搬迁
 }
 
 type Bucketer interface {
@@ -15,32 +15,29 @@
 package storage
 
 import (
-	"bufio"
 	"aofs/internal/env"
-	"os"
+	"io"
 
 	"github.com/saintfish/chardet"
 )
 
 func GetCharset(betag string) string {
-	filePath, err := GetStor().GetFileAbsPath(env.NORMAL_BUCKET, betag)
+	rc, err := GetStor().Get(env.NORMAL_BUCKET, betag, nil)
 	if err != nil {
 		return ""
 	}
-	f, err := os.Open(filePath)
-	if err != nil {
-		return ""
-	}
-	defer f.Close()
+	defer rc.Close()
 
-	reader := bufio.NewReader(f)
 	buf := make([]byte, 1024)
-	_, err = reader.Read(buf)
-	if err != nil {
+	n, err := io.ReadFull(rc, buf)
+	if n == 0 {
 		return ""
 	}
 	detector := chardet.NewTextDetector()
-	charset, err := detector.DetectBest(buf)
+	charset, err := detector.DetectBest(buf[:n])
+	if err != nil {
+		return ""
+	}
 
 	return charset.Charset
 }
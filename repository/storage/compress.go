@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"strings"
+)
+
+// 小于该大小的对象压缩收益不大，不压缩
+const compressMinSize = 4 * 1024
+
+// PutOptions 写入对象时的可选参数
+type PutOptions struct {
+	Mime string //文件类型，用于判断是否压缩存储
+}
+
+// 除 text/* 以外可压缩的类型。docx、xlsx 等本身已是 zip，不在此列
+var compressibleMimes = map[string]bool{
+	"application/json":              true,
+	"application/xml":               true,
+	"application/javascript":        true,
+	"application/x-javascript":      true,
+	"application/x-sh":              true,
+	"application/x-tar":             true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/postscript":        true,
+	"image/svg+xml":                 true,
+	"image/bmp":                     true,
+}
+
+// IsCompressibleMime 判断该类型的内容是否值得压缩
+func IsCompressibleMime(mime string) bool {
+	mime = strings.ToLower(strings.TrimSpace(strings.Split(mime, ";")[0]))
+	return strings.HasPrefix(mime, "text/") || compressibleMimes[mime]
+}
+
+func shouldCompress(opts *PutOptions, size int64) bool {
+	return env.COMPRESS_AT_REST && opts != nil && size >= compressMinSize && IsCompressibleMime(opts.Mime)
+}
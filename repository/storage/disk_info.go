@@ -71,3 +71,8 @@ func getDiskInfo() (*SharedDiskInfo, error) {
 	return &sdi, nil
 
 }
+
+// GetSharedDiskInfo 读取共享目录中的磁盘信息
+func GetSharedDiskInfo() (*SharedDiskInfo, error) {
+	return getDiskInfo()
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IndexCounter 可选接口，统计某块盘上的索引数（含副本），用于判断盘能否安全移除
+type IndexCounter interface {
+	Count(diskId int) (int64, error)
+}
+
+type ReloadResult struct {
+	Added    []int `json:"added"`    //新增的盘
+	Removed  []int `json:"removed"`  //已移除的盘
+	Retained []int `json:"retained"` //已不在磁盘信息中，但仍有对象，保留并标记为下线中
+}
+
+var reloadMu sync.Mutex
+
+// Reload 重新读取 disk_info.json，更新盘映射。
+// 已不在磁盘信息中、但仍有对象的盘不会移除，改为下线中，等对象搬走后再次加载时移除。
+func (m *multiDisk) Reload() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	sdi, err := getDiskInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	oldDisk, oldPrimary := m.disks()
+	mapDisk, primary := m.loadDisks(sdi)
+
+	var result ReloadResult
+	for _, diskId := range sortedDiskIds(mapDisk) {
+		if _, ok := oldDisk[diskId]; !ok {
+			result.Added = append(result.Added, diskId)
+		}
+	}
+	for _, diskId := range sortedDiskIds(oldDisk) {
+		if _, ok := mapDisk[diskId]; ok {
+			continue
+		}
+		if m.canRemove(diskId) {
+			result.Removed = append(result.Removed, diskId)
+			continue
+		}
+		mapDisk[diskId] = oldDisk[diskId]
+		primary[diskId] = oldPrimary[diskId]
+		result.Retained = append(result.Retained, diskId)
+	}
+
+	m.mu.Lock()
+	m.mapDisk, m.primary = mapDisk, primary
+	m.mu.Unlock()
+
+	for _, diskId := range result.Retained {
+		if !m.IsDraining(diskId) {
+			if err := m.SetDraining(diskId, true); err != nil {
+				logger.LogW().Err(err).Int("diskId", diskId).Msg("failed to drain retired disk")
+			}
+		}
+	}
+	for _, diskId := range result.Removed {
+		if m.IsDraining(diskId) {
+			m.stateMu.Lock()
+			delete(m.draining, diskId)
+			m.saveDiskState()
+			m.stateMu.Unlock()
+		}
+	}
+
+	logger.LogI().Interface("result", result).Interface("dirs", mapDisk).Msg("reload disk info")
+	return &result, nil
+}
+
+// canRemove 盘上没有主副本和副本的索引时才允许移除，无法统计时保守处理
+func (m *multiDisk) canRemove(diskId int) bool {
+	counter, ok := m.indexer.(IndexCounter)
+	if !ok {
+		return false
+	}
+	count, err := counter.Count(diskId)
+	if err != nil {
+		logger.LogW().Err(err).Int("diskId", diskId).Msg("failed to count objects")
+		return false
+	}
+	return count == 0
+}
+
+// watchDiskInfo 轮询 disk_info.json 的修改时间，有变化时重新加载
+func (m *multiDisk) watchDiskInfo(interval time.Duration) {
+	file := filepath.Join(env.SHARED_PATH, "disk_info.json")
+	var lastMod time.Time
+	if fi, err := os.Stat(file); err == nil {
+		lastMod = fi.ModTime()
+	}
+
+	for {
+		time.Sleep(interval)
+		fi, err := os.Stat(file)
+		if err != nil || fi.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = fi.ModTime()
+		if _, err := m.Reload(); err != nil {
+			logger.LogE().Err(err).Msg("failed to reload disk info")
+		}
+	}
+}
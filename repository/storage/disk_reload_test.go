@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/utils"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeDiskInfo(t *testing.T, ids ...int64) {
+	var sdi SharedDiskInfo
+	sdi.FileStorageVolumePathPrefix = "part_"
+	for _, id := range ids {
+		sdi.DiskMountInfos = append(sdi.DiskMountInfos, &DiskMountInfo{
+			DeviceSequenceNumber: id,
+			DataFolderRoot:       string(rune('a' + id)),
+			IsPrimaryStorage:     id == 1,
+		})
+	}
+	if err := utils.WriteJsonToFile(filepath.Join(env.SHARED_PATH, "disk_info.json"), sdi); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	root := t.TempDir()
+	oldData, oldShared := env.DATA_PATH, env.SHARED_PATH
+	env.DATA_PATH, env.SHARED_PATH = filepath.Join(root, "data"), filepath.Join(root, "shared")
+	defer func() { env.DATA_PATH, env.SHARED_PATH = oldData, oldShared }()
+
+	writeDiskInfo(t, 1, 2)
+	mi := &MockIndexer{mapDiskFile: map[string]int{"0a1b2c3d": 2}}
+	var m multiDisk
+	if err := m.Init(mi); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新增盘 3，移除仍有对象的盘 2
+	writeDiskInfo(t, 1, 3)
+	result, err := m.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, &ReloadResult{Added: []int{3}, Retained: []int{2}}) {
+		t.Errorf("reload result: %+v", result)
+	}
+	if ids := m.GetDiskIds(); !reflect.DeepEqual(ids, []int{1, 2, 3}) {
+		t.Errorf("disk ids: %v", ids)
+	}
+	if !m.IsDraining(2) {
+		t.Error("retained disk should be draining")
+	}
+	if _, err := m.GetDiskPath(2); err != nil {
+		t.Errorf("retained disk should still be readable: %v", err)
+	}
+
+	// 对象搬走后再次加载，盘 2 被移除
+	mi.mapDiskFile["0a1b2c3d"] = 3
+	result, err = m.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, &ReloadResult{Removed: []int{2}}) {
+		t.Errorf("reload result: %+v", result)
+	}
+	if _, err := m.GetDiskPath(2); err == nil {
+		t.Error("removed disk should not be found")
+	}
+	if m.IsDraining(2) {
+		t.Error("removed disk should not stay draining")
+	}
+}
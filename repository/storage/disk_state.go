@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// diskState 运行时的磁盘状态，保存在 DATA_PATH/disk_state.json，重启后保持
+type diskState struct {
+	Draining []int `json:"draining"` //下线中的盘序号，不再分配新文件
+}
+
+func diskStatePath() string {
+	return filepath.Join(env.DATA_PATH, "disk_state.json")
+}
+
+func (m *multiDisk) loadDiskState() error {
+	m.draining = make(map[int]bool)
+
+	var ds diskState
+	if err := utils.ReadJsonFromFile(diskStatePath(), &ds); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, diskId := range ds.Draining {
+		m.draining[diskId] = true
+	}
+	return nil
+}
+
+func (m *multiDisk) saveDiskState() error {
+	var ds diskState
+	for diskId, draining := range m.draining {
+		if draining {
+			ds.Draining = append(ds.Draining, diskId)
+		}
+	}
+	sort.Ints(ds.Draining)
+	return utils.WriteJsonToFile(diskStatePath(), ds)
+}
+
+// SetDraining 标记盘为下线中或取消下线
+func (m *multiDisk) SetDraining(diskId int, draining bool) error {
+	if _, err := m.GetDiskPath(diskId); err != nil {
+		return err
+	}
+
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	if m.draining == nil {
+		m.draining = make(map[int]bool)
+	}
+	if draining {
+		for _, id := range m.GetDiskIds() {
+			if id != diskId && !m.draining[id] {
+				m.draining[diskId] = true
+				return m.saveDiskState()
+			}
+		}
+		return fmt.Errorf("disk %v is the last available disk", diskId)
+	}
+
+	delete(m.draining, diskId)
+	return m.saveDiskState()
+}
+
+func (m *multiDisk) IsDraining(diskId int) bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.draining[diskId]
+}
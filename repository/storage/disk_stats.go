@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "aofs/internal/env"
+
+// DiskObjects 盘上主副本和副本的对象数和字节数
+type DiskObjects struct {
+	Objects      int64 `json:"objects"`
+	Bytes        int64 `json:"bytes"`
+	Replicas     int64 `json:"replicas"`     //其他对象在该盘上的副本数，不计入 Objects
+	ReplicaBytes int64 `json:"replicaBytes"` //副本的字节数
+}
+
+// DiskObjectCounter 可选接口，按盘统计对象数和字节数
+type DiskObjectCounter interface {
+	CountByDisk() (map[int]DiskObjects, error)
+}
+
+// DiskStat 盘的挂载信息、空间和状态
+type DiskStat struct {
+	DiskId  int            `json:"diskId"` //盘序号，即 DeviceSequenceNumber
+	Path    string         `json:"path"`
+	Mount   *DiskMountInfo `json:"mount"` //已不在磁盘信息中、保留待搬空的盘为空
+	Primary bool           `json:"primary"`
+	Online  bool           `json:"online"` //能否读取盘的空间信息
+
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Free      uint64 `json:"free"`
+	Reserved  int64  `json:"reserved"`  //保留空间，剩余空间低于保留空间时不再分配新文件
+	Available int64  `json:"available"` //扣除保留空间后可写入的空间
+
+	DiskObjects
+
+	ReadOnly bool `json:"readOnly"`
+	Draining bool `json:"draining"` //下线中，不再分配新文件
+}
+
+// DiskStats 获取所有盘的状态，按盘序号升序
+func (m *multiDisk) DiskStats() ([]DiskStat, error) {
+	mounts := map[int]*DiskMountInfo{}
+	if sdi, err := getDiskInfo(); err != nil {
+		logger.LogW().Err(err).Msg("failed to read disk info")
+	} else {
+		for _, di := range sdi.DiskMountInfos {
+			mounts[int(di.DeviceSequenceNumber)] = di
+		}
+	}
+
+	var objects map[int]DiskObjects
+	if counter, ok := m.indexer.(DiskObjectCounter); ok {
+		var err error
+		if objects, err = counter.CountByDisk(); err != nil {
+			return nil, err
+		}
+	}
+
+	mapDisk, primary := m.disks()
+	stats := make([]DiskStat, 0, len(mapDisk))
+	for _, diskId := range sortedDiskIds(mapDisk) {
+		usage := diskUsage(mapDisk[diskId])
+		stat := DiskStat{
+			DiskId:      diskId,
+			Path:        mapDisk[diskId],
+			Mount:       mounts[diskId],
+			Primary:     primary[diskId],
+			Online:      usage.All > 0,
+			Total:       usage.All,
+			Used:        usage.Used,
+			Free:        usage.Free,
+			Reserved:    env.RESERVED_SPACE,
+			DiskObjects: objects[diskId],
+			ReadOnly:    usage.ReadOnly,
+			Draining:    m.IsDraining(diskId),
+		}
+		if free := int64(usage.Free) - env.RESERVED_SPACE; free > 0 {
+			stat.Available = free
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"testing"
+)
+
+type mockCountIndexer struct {
+	MockIndexer
+	replicas map[string][]int
+}
+
+func (mi *mockCountIndexer) CountByDisk() (map[int]DiskObjects, error) {
+	objects := map[int]DiskObjects{}
+	for _, diskId := range mi.mapDiskFile {
+		o := objects[diskId]
+		o.Objects++
+		objects[diskId] = o
+	}
+	for _, diskIds := range mi.replicas {
+		for _, diskId := range diskIds {
+			o := objects[diskId]
+			o.Replicas++
+			objects[diskId] = o
+		}
+	}
+	return objects, nil
+}
+
+func TestDiskStats(t *testing.T) {
+	m := multiDisk{
+		mapDisk:  map[int]string{2: "/d2", 1: "/d1", 3: "/d3"},
+		primary:  map[int]bool{1: true},
+		draining: map[int]bool{3: true},
+		indexer: &mockCountIndexer{MockIndexer{mapDiskFile: map[string]int{"a": 1, "b": 1, "c": 2}},
+			map[string][]int{"a": {2}, "c": {3}}},
+	}
+	usage := map[string]DiskStatus{
+		"/d1": {All: 10 * GB, Used: 9 * GB, Free: 1 * GB},
+		"/d2": {All: 10 * GB, Used: 2 * GB, Free: 8 * GB, ReadOnly: true},
+	}
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return usage[path] }
+	defer func() { diskUsage = old }()
+
+	oldReserved := env.RESERVED_SPACE
+	env.RESERVED_SPACE = 2 * GB
+	defer func() { env.RESERVED_SPACE = oldReserved }()
+
+	stats, err := m.DiskStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 3 {
+		t.Fatalf("got %v disks", len(stats))
+	}
+	for i, want := range []DiskStat{
+		{DiskId: 1, Path: "/d1", Primary: true, Online: true, Total: 10 * GB, Used: 9 * GB, Free: 1 * GB,
+			Reserved: 2 * GB, Available: 0, DiskObjects: DiskObjects{Objects: 2}},
+		{DiskId: 2, Path: "/d2", Online: true, Total: 10 * GB, Used: 2 * GB, Free: 8 * GB,
+			Reserved: 2 * GB, Available: 6 * GB, DiskObjects: DiskObjects{Objects: 1, Replicas: 1}, ReadOnly: true},
+		{DiskId: 3, Path: "/d3", Reserved: 2 * GB, DiskObjects: DiskObjects{Replicas: 1}, Draining: true},
+	} {
+		if stats[i] != want {
+			t.Errorf("disk %v: got %+v, want %+v", want.DiskId, stats[i], want)
+		}
+	}
+}
@@ -18,6 +18,8 @@ type DiskStatus struct {
 	All  uint64 `json:"all"`
 	Used uint64 `json:"used"`
 	Free uint64 `json:"free"`
+
+	ReadOnly bool `json:"readOnly"` //文件系统以只读方式挂载
 }
 
 const (
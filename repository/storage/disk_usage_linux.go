@@ -18,6 +18,9 @@ import (
 	"syscall"
 )
 
+// statfs 返回的 f_flags 中只读挂载的标志位
+const stRdonly = 0x1
+
 // disk usage of path/disk
 func DiskUsage(path string) (disk DiskStatus) {
 	fs := syscall.Statfs_t{}
@@ -28,5 +31,6 @@ func DiskUsage(path string) (disk DiskStatus) {
 	disk.All = fs.Blocks * uint64(fs.Bsize)
 	disk.Free = fs.Bfree * uint64(fs.Bsize)
 	disk.Used = disk.All - disk.Free
+	disk.ReadOnly = fs.Flags&stRdonly != 0
 	return
 }
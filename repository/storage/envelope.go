@@ -0,0 +1,499 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+//此文件定义对象落盘的封装格式，用于加密和压缩存储：
+//
+//	magic(8) | keyLen(1) | key | flags(1) | chunkSize(4) | plainSize(8) | [masterKeyId(4) | wrappedLen(1) | wrappedKey]
+//	chunk0 | chunk1 | ...
+//	[len0(4) | len1(4) | ... | chunkCount(4)]
+//
+//内容按 chunkSize 分块，每块单独压缩、加密，范围读取时只需处理涉及的块。
+//加密时每个对象使用随机的数据密钥，数据密钥用主密钥加密后放在头部，每块用 AES-GCM 加密。
+//压缩时每块用 deflate 单独压缩，文件末尾记录各块长度。
+//头部带有对象 key，用户数据恰好以 magic 开头时也不会被误认为封装格式。
+//没有封装头的文件按原始内容读取，兼容已有对象。
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"aofs/internal/env"
+	"aofs/internal/proto"
+)
+
+const (
+	envMagic          = "AOFSOBJ1"
+	envFlagEncrypted  = 1 << 0
+	envFlagCompressed = 1 << 1
+	envChunkSize      = 64 * 1024
+	envMaxHeaderSize  = 1024
+
+	frameDeflated = 1 << 31 //块长度的最高位表示该块经过压缩
+)
+
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type envHeader struct {
+	key       string
+	flags     byte
+	chunkSize int64
+	plainSize int64
+	keyId     [4]byte
+	wrapped   []byte //主密钥加密后的数据密钥
+
+	raw []byte //编码后的头部，作为每块的附加认证数据
+}
+
+func (h *envHeader) marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(envMagic)
+	buf.WriteByte(byte(len(h.key)))
+	buf.WriteString(h.key)
+	buf.WriteByte(h.flags)
+	binary.Write(&buf, binary.BigEndian, uint32(h.chunkSize))
+	binary.Write(&buf, binary.BigEndian, uint64(h.plainSize))
+	if h.flags&envFlagEncrypted != 0 {
+		buf.Write(h.keyId[:])
+		buf.WriteByte(byte(len(h.wrapped)))
+		buf.Write(h.wrapped)
+	}
+	h.raw = buf.Bytes()
+	return h.raw
+}
+
+// readEnvHeader 读取封装头，不是封装格式时返回 nil, nil
+func readEnvHeader(f io.ReaderAt, key string) (*envHeader, error) {
+	buf := make([]byte, envMaxHeaderSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+	if len(buf) < len(envMagic)+1+len(key) || string(buf[:len(envMagic)]) != envMagic {
+		return nil, nil
+	}
+	r := bytes.NewReader(buf[len(envMagic):])
+	if l, _ := r.ReadByte(); int(l) != len(key) || string(buf[len(envMagic)+1:len(envMagic)+1+len(key)]) != key {
+		return nil, nil
+	}
+	r.Seek(int64(len(key)), io.SeekCurrent)
+
+	h := &envHeader{key: key}
+	var chunkSize uint32
+	var plainSize uint64
+	h.flags, _ = r.ReadByte()
+	binary.Read(r, binary.BigEndian, &chunkSize)
+	if err := binary.Read(r, binary.BigEndian, &plainSize); err != nil {
+		return nil, fmt.Errorf("bad object header: %v", err)
+	}
+	h.chunkSize, h.plainSize = int64(chunkSize), int64(plainSize)
+	if h.chunkSize == 0 {
+		return nil, fmt.Errorf("bad object header: chunk size is 0")
+	}
+	if h.flags&envFlagEncrypted != 0 {
+		r.Read(h.keyId[:])
+		l, _ := r.ReadByte()
+		h.wrapped = make([]byte, l)
+		if _, err := io.ReadFull(r, h.wrapped); err != nil {
+			return nil, fmt.Errorf("bad object header: %v", err)
+		}
+	}
+	h.raw = buf[:len(buf)-r.Len()]
+	return h, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, i int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(i))
+	return nonce
+}
+
+func chunkAAD(raw []byte, i int64, deflated bool) []byte {
+	aad := make([]byte, len(raw)+9)
+	copy(aad, raw)
+	binary.BigEndian.PutUint64(aad[len(raw):], uint64(i))
+	if deflated {
+		aad[len(aad)-1] = 1
+	}
+	return aad
+}
+
+// masterKey 主密钥，只用于加密各对象的数据密钥
+type masterKey struct {
+	aead cipher.AEAD
+	id   [4]byte
+}
+
+var master *masterKey
+
+// initMasterKey 加载主密钥。开启加密且密钥文件不存在时生成新密钥；
+// 未开启加密但密钥文件存在时仍然加载，以便读取之前加密的对象。
+func initMasterKey() error {
+	master = nil
+	data, err := os.ReadFile(env.MASTER_KEY_PATH)
+	if os.IsNotExist(err) {
+		if !env.ENCRYPT_AT_REST {
+			return nil
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		data = []byte(hex.EncodeToString(key))
+		if err := os.MkdirAll(filepath.Dir(env.MASTER_KEY_PATH), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(env.MASTER_KEY_PATH, data, 0600); err != nil {
+			return err
+		}
+		logger.LogI().Str("path", env.MASTER_KEY_PATH).Msg("generate master key")
+	} else if err != nil {
+		return err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("master key %v should be 64 hex characters", env.MASTER_KEY_PATH)
+	}
+	return setMasterKey(key)
+}
+
+func setMasterKey(key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	mk := &masterKey{aead: aead}
+	sum := sha256.Sum256(key)
+	copy(mk.id[:], sum[:])
+	master = mk
+	return nil
+}
+
+// wrap 加密数据密钥，以对象 key 作为附加数据，防止头部被挪到其他对象上使用
+func (mk *masterKey) wrap(dataKey []byte, key string) ([]byte, error) {
+	nonce := make([]byte, mk.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return mk.aead.Seal(nonce, nonce, dataKey, []byte(key)), nil
+}
+
+func (mk *masterKey) unwrap(wrapped []byte, key string) ([]byte, error) {
+	ns := mk.aead.NonceSize()
+	if len(wrapped) < ns {
+		return nil, fmt.Errorf("bad wrapped key")
+	}
+	return mk.aead.Open(nil, wrapped[:ns], wrapped[ns:], []byte(key))
+}
+
+// encryptEnabled 新写入的对象是否加密
+func encryptEnabled() bool {
+	return env.ENCRYPT_AT_REST && master != nil
+}
+
+// writeObject 按配置把 r 中 size 字节写入 w，需要加密或压缩时写成封装格式
+func writeObject(w io.Writer, key string, r io.Reader, size int64, compress bool) error {
+	if !encryptEnabled() && !compress {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	h := envHeader{key: key, chunkSize: envChunkSize, plainSize: size}
+	var aead cipher.AEAD
+	if encryptEnabled() {
+		dataKey := make([]byte, 32)
+		if _, err := rand.Read(dataKey); err != nil {
+			return err
+		}
+		var err error
+		if aead, err = newGCM(dataKey); err != nil {
+			return err
+		}
+		if h.wrapped, err = master.wrap(dataKey, key); err != nil {
+			return err
+		}
+		h.flags |= envFlagEncrypted
+		h.keyId = master.id
+	}
+	var fw *flate.Writer
+	var cbuf bytes.Buffer
+	if compress {
+		h.flags |= envFlagCompressed
+		fw, _ = flate.NewWriter(&cbuf, flate.BestSpeed) //盒子 CPU 较弱，优先速度
+	}
+	raw := h.marshal()
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+
+	buf := make([]byte, envChunkSize)
+	var frames []uint32
+	var written int64
+	for i := int64(0); ; i++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			written += int64(n)
+			if written > size {
+				break
+			}
+
+			frame, deflated := buf[:n], false
+			if fw != nil {
+				cbuf.Reset()
+				fw.Reset(&cbuf)
+				fw.Write(frame)
+				fw.Close()
+				//压缩后没有变小的块按原样存储
+				if cbuf.Len() < n {
+					frame, deflated = cbuf.Bytes(), true
+				}
+			}
+			if aead != nil {
+				frame = aead.Seal(nil, chunkNonce(aead, i), frame, chunkAAD(raw, i, deflated))
+			}
+			if _, err := w.Write(frame); err != nil {
+				return err
+			}
+			if deflated {
+				frames = append(frames, uint32(len(frame))|frameDeflated)
+			} else {
+				frames = append(frames, uint32(len(frame)))
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if written != size {
+		return fmt.Errorf("size mismatch, expect %v, got %v", size, written)
+	}
+
+	if compress {
+		//压缩后每块长度不同，在文件末尾记录各块长度，用于范围读取时定位
+		trailer := make([]byte, 4*len(frames)+4)
+		for i, l := range frames {
+			binary.BigEndian.PutUint32(trailer[4*i:], l)
+		}
+		binary.BigEndian.PutUint32(trailer[4*len(frames):], uint32(len(frames)))
+		if _, err := w.Write(trailer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openEnvelope 返回还原后的 [part.Start, part.End] 内容，part 为 nil 时返回全部内容
+func openEnvelope(f *os.File, h *envHeader, part *proto.Part) (io.ReadCloser, error) {
+	if h.flags&^(envFlagEncrypted|envFlagCompressed) != 0 {
+		return nil, fmt.Errorf("unsupported object flags %v", h.flags)
+	}
+
+	start, end := int64(0), h.plainSize-1
+	if part != nil {
+		start = part.Start
+		if part.End != -1 {
+			end = part.End
+		}
+		if end >= h.plainSize || start > end+1 {
+			return nil, ErrRangeNotSatisfiable
+		}
+	}
+
+	er := &envReader{
+		f:      f,
+		h:      h,
+		chunk:  start / h.chunkSize,
+		skip:   start % h.chunkSize,
+		remain: end - start + 1,
+	}
+
+	if h.flags&envFlagEncrypted != 0 {
+		if master == nil {
+			return nil, fmt.Errorf("object is encrypted but master key is not loaded")
+		}
+		if master.id != h.keyId {
+			return nil, fmt.Errorf("object is encrypted with another master key")
+		}
+		dataKey, err := master.unwrap(h.wrapped, h.key)
+		if err != nil {
+			return nil, err
+		}
+		if er.aead, err = newGCM(dataKey); err != nil {
+			return nil, err
+		}
+	}
+	if h.flags&envFlagCompressed != 0 {
+		if err := er.loadFrames(); err != nil {
+			return nil, err
+		}
+	}
+	return er, nil
+}
+
+type envReader struct {
+	f      *os.File
+	h      *envHeader
+	aead   cipher.AEAD //未加密时为 nil
+	frames []uint32    //压缩存储时各块的长度
+	offs   []int64     //压缩存储时各块的起始位置
+	fr     io.ReadCloser
+	chunk  int64 //下一个要读取的块
+	skip   int64 //第一块中需要跳过的字节数
+	buf    []byte
+	remain int64 //还需返回的字节数
+}
+
+func (r *envReader) chunkCount() int64 {
+	return (r.h.plainSize + r.h.chunkSize - 1) / r.h.chunkSize
+}
+
+// loadFrames 读取文件末尾的块长度表
+func (r *envReader) loadFrames() error {
+	stat, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	count := r.chunkCount()
+	size := 4*count + 4
+	if stat.Size() < int64(len(r.h.raw))+size {
+		return fmt.Errorf("object %v is truncated", r.h.key)
+	}
+	trailer := make([]byte, size)
+	if _, err := r.f.ReadAt(trailer, stat.Size()-size); err != nil {
+		return err
+	}
+	if int64(binary.BigEndian.Uint32(trailer[4*count:])) != count {
+		return fmt.Errorf("object %v has bad frame table", r.h.key)
+	}
+
+	r.frames = make([]uint32, count)
+	r.offs = make([]int64, count)
+	off := int64(len(r.h.raw))
+	for i := range r.frames {
+		r.frames[i] = binary.BigEndian.Uint32(trailer[4*i:])
+		r.offs[i] = off
+		off += int64(r.frames[i] &^ frameDeflated)
+	}
+	if off != stat.Size()-size {
+		return fmt.Errorf("object %v has bad frame table", r.h.key)
+	}
+	return nil
+}
+
+func (r *envReader) Read(p []byte) (int, error) {
+	if r.remain <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remain -= int64(n)
+	return n, nil
+}
+
+func (r *envReader) next() error {
+	h := r.h
+	plainLen := h.plainSize - r.chunk*h.chunkSize
+	if plainLen > h.chunkSize {
+		plainLen = h.chunkSize
+	}
+
+	var off, storedLen int64
+	var deflated bool
+	if r.frames != nil {
+		off = r.offs[r.chunk]
+		storedLen = int64(r.frames[r.chunk] &^ frameDeflated)
+		deflated = r.frames[r.chunk]&frameDeflated != 0
+	} else {
+		var overhead int64
+		if r.aead != nil {
+			overhead = int64(r.aead.Overhead())
+		}
+		off = int64(len(h.raw)) + r.chunk*(h.chunkSize+overhead)
+		storedLen = plainLen + overhead
+	}
+
+	frame := make([]byte, storedLen)
+	if _, err := r.f.ReadAt(frame, off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if r.aead != nil {
+		var err error
+		frame, err = r.aead.Open(frame[:0], chunkNonce(r.aead, r.chunk), frame, chunkAAD(h.raw, r.chunk, deflated))
+		if err != nil {
+			return fmt.Errorf("chunk %v of %v: %v", r.chunk, h.key, err)
+		}
+	}
+	if deflated {
+		if r.fr == nil {
+			r.fr = flate.NewReader(bytes.NewReader(frame))
+		} else {
+			r.fr.(flate.Resetter).Reset(bytes.NewReader(frame), nil)
+		}
+		plain := make([]byte, plainLen)
+		if _, err := io.ReadFull(r.fr, plain); err != nil {
+			return fmt.Errorf("chunk %v of %v: %v", r.chunk, h.key, err)
+		}
+		frame = plain
+	}
+	if int64(len(frame)) != plainLen {
+		return fmt.Errorf("chunk %v of %v: bad length %v", r.chunk, h.key, len(frame))
+	}
+
+	r.buf = frame[r.skip:]
+	r.skip = 0
+	r.chunk++
+	return nil
+}
+
+func (r *envReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,215 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestObject(t *testing.T, key string, data []byte, compress bool) *os.File {
+	path := filepath.Join(t.TempDir(), key)
+	w, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeObject(w, key, bytes.NewReader(data), int64(len(data)), compress); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func readTestObject(t *testing.T, f *os.File, key string, part *proto.Part) ([]byte, error) {
+	h, err := readEnvHeader(f, key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		t.Fatal("envelope header not found")
+	}
+	rc, err := openEnvelope(f, h, part)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(rc)
+}
+
+func enableTestEncryption(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := setMasterKey(key); err != nil {
+		t.Fatal(err)
+	}
+	env.ENCRYPT_AT_REST = true
+	t.Cleanup(func() {
+		env.ENCRYPT_AT_REST = false
+		master = nil
+	})
+}
+
+func TestEnvelopeEncrypt(t *testing.T) {
+	enableTestEncryption(t)
+
+	key := "0a1b2c3d4e5f"
+	for _, size := range []int{0, 1, envChunkSize, 2*envChunkSize + 100} {
+		data := make([]byte, size)
+		rand.Read(data)
+		f := writeTestObject(t, key, data, false)
+		defer f.Close()
+
+		if stat, _ := f.Stat(); size > 16 && bytes.Contains(mustReadAll(t, f), data[:1+size/2]) {
+			t.Fatalf("size %v: plain text found in %v bytes", size, stat.Size())
+		}
+
+		got, err := readTestObject(t, f, key, nil)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("size %v: read all failed: %v", size, err)
+		}
+
+		if size < 16 {
+			continue
+		}
+		// 跨块的范围读取
+		for _, part := range []proto.Part{{Start: 0, End: 0}, {Start: int64(size) - 1, End: -1}, {Start: 10, End: int64(size) - 1}, {Start: int64(size) / 3, End: int64(size) * 2 / 3}} {
+			got, err := readTestObject(t, f, key, &part)
+			end := part.End
+			if end == -1 {
+				end = int64(size) - 1
+			}
+			if err != nil || !bytes.Equal(got, data[part.Start:end+1]) {
+				t.Errorf("size %v, part %v: range read failed: %v", size, part, err)
+			}
+		}
+		if _, err := readTestObject(t, f, key, &proto.Part{Start: 0, End: int64(size)}); err != ErrRangeNotSatisfiable {
+			t.Errorf("size %v: expect range error, got %v", size, err)
+		}
+	}
+}
+
+func mustReadAll(t *testing.T, f *os.File) []byte {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEnvelopeTamper(t *testing.T) {
+	enableTestEncryption(t)
+
+	key := "0a1b2c3d4e5f"
+	data := make([]byte, 3*envChunkSize)
+	rand.Read(data)
+	f := writeTestObject(t, key, data, false)
+	raw := mustReadAll(t, f)
+	f.Close()
+
+	raw[len(raw)-envChunkSize] ^= 1
+	path := filepath.Join(t.TempDir(), key)
+	os.WriteFile(path, raw, 0600)
+	f, _ = os.Open(path)
+	defer f.Close()
+
+	if _, err := readTestObject(t, f, key, nil); err == nil {
+		t.Error("tampered object should fail to decrypt")
+	}
+	// 未被修改的块仍可读取
+	if got, err := readTestObject(t, f, key, &proto.Part{Start: 0, End: envChunkSize - 1}); err != nil || !bytes.Equal(got, data[:envChunkSize]) {
+		t.Errorf("untouched chunk: %v", err)
+	}
+}
+
+func TestEnvelopePlain(t *testing.T) {
+	// 以 magic 开头但 key 不一致的用户数据按原始内容处理
+	data := append([]byte(envMagic), []byte("\x0cffffffffffff")...)
+	path := filepath.Join(t.TempDir(), "0a1b2c3d4e5f")
+	os.WriteFile(path, data, 0600)
+	f, _ := os.Open(path)
+	defer f.Close()
+
+	if h, err := readEnvHeader(f, "0a1b2c3d4e5f"); h != nil || err != nil {
+		t.Errorf("expect plain object, got %v %v", h, err)
+	}
+
+	// 未开启加密时按原样写入
+	f2 := writeTestObject(t, "0a1b2c3d4e5f", data, false)
+	defer f2.Close()
+	if !bytes.Equal(mustReadAll(t, f2), data) {
+		t.Error("object should be written as is")
+	}
+}
+
+func TestEnvelopeCompress(t *testing.T) {
+	key := "0a1b2c3d4e5f"
+	// 前半部分可压缩，后半部分是随机数据，覆盖压缩和原样存储两种块
+	var data []byte
+	for len(data) < 2*envChunkSize {
+		data = append(data, []byte("2022-01-01 00:00:00 INFO aofs started\n")...)
+	}
+	random := make([]byte, 2*envChunkSize+123)
+	rand.Read(random)
+	data = append(data, random...)
+
+	for _, encrypt := range []bool{false, true} {
+		if encrypt {
+			enableTestEncryption(t)
+		}
+		f := writeTestObject(t, key, data, true)
+		defer f.Close()
+
+		if stat, _ := f.Stat(); stat.Size() >= int64(len(data)) {
+			t.Errorf("encrypt %v: object is not compressed, %v >= %v", encrypt, stat.Size(), len(data))
+		}
+		if got, err := readTestObject(t, f, key, nil); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("encrypt %v: read all failed: %v", encrypt, err)
+		}
+		for _, part := range []proto.Part{{Start: 5, End: 5}, {Start: envChunkSize - 10, End: 3*envChunkSize + 10}, {Start: int64(len(data)) - 100, End: -1}} {
+			end := part.End
+			if end == -1 {
+				end = int64(len(data)) - 1
+			}
+			if got, err := readTestObject(t, f, key, &part); err != nil || !bytes.Equal(got, data[part.Start:end+1]) {
+				t.Errorf("encrypt %v, part %v: range read failed: %v", encrypt, part, err)
+			}
+		}
+	}
+}
+
+func TestIsCompressibleMime(t *testing.T) {
+	for mime, want := range map[string]bool{
+		"text/plain":               true,
+		"text/html; charset=utf-8": true,
+		"application/json":         true,
+		"application/pdf":          false,
+		"image/jpeg":               false,
+		"application/octet-stream": false,
+	} {
+		if got := IsCompressibleMime(mime); got != want {
+			t.Errorf("%v: got %v, want %v", mime, got, want)
+		}
+	}
+}
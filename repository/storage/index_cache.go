@@ -0,0 +1,188 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// CachedIndexer 在 Indexer 前增加一层 LRU 缓存，减少查询对象所在盘的数据库访问。
+// 写操作先写底层 Indexer，成功后再更新缓存。
+type CachedIndexer struct {
+	next     Indexer
+	capacity int
+
+	mu      sync.Mutex
+	ll      *list.List //最近使用的在前
+	items   map[string]*list.Element
+	version uint64 //每次失效加 1，避免并发的读把旧值写回缓存
+	hits    uint64
+	misses  uint64
+}
+
+type cacheEntry struct {
+	key    string
+	diskId int
+}
+
+// IndexCacheStats 缓存命中统计
+type IndexCacheStats struct {
+	Capacity int    `json:"capacity"`
+	Size     int    `json:"size"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+func NewCachedIndexer(next Indexer, capacity int) *CachedIndexer {
+	return &CachedIndexer{
+		next:     next,
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *CachedIndexer) Get(key string) (int, error) {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		c.hits++
+		diskId := e.Value.(*cacheEntry).diskId
+		c.mu.Unlock()
+		return diskId, nil
+	}
+	c.misses++
+	version := c.version
+	c.mu.Unlock()
+
+	diskId, err := c.next.Get(key)
+	if err != nil {
+		return diskId, err
+	}
+
+	c.mu.Lock()
+	if version == c.version {
+		c.put(key, diskId)
+	}
+	c.mu.Unlock()
+	return diskId, nil
+}
+
+func (c *CachedIndexer) Delete(key string) (int, error) {
+	diskId, err := c.next.Delete(key)
+	c.invalidate(key)
+	return diskId, err
+}
+
+func (c *CachedIndexer) Add(key string, diskId int) error {
+	if err := c.next.Add(key, diskId); err != nil {
+		c.invalidate(key)
+		return err
+	}
+	c.set(key, diskId)
+	return nil
+}
+
+func (c *CachedIndexer) Update(key string, diskId int) error {
+	if err := c.next.Update(key, diskId); err != nil {
+		c.invalidate(key)
+		return err
+	}
+	c.set(key, diskId)
+	return nil
+}
+
+// Count 透传给底层 Indexer，见 IndexCounter
+func (c *CachedIndexer) Count(diskId int) (int64, error) {
+	counter, ok := c.next.(IndexCounter)
+	if !ok {
+		return 0, fmt.Errorf("indexer does not support count")
+	}
+	return counter.Count(diskId)
+}
+
+// CountByDisk 透传给底层 Indexer，见 DiskObjectCounter
+func (c *CachedIndexer) CountByDisk() (map[int]DiskObjects, error) {
+	counter, ok := c.next.(DiskObjectCounter)
+	if !ok {
+		return nil, fmt.Errorf("indexer does not support count")
+	}
+	return counter.CountByDisk()
+}
+
+// GetReplicas、AddReplica、DeleteReplica 透传给底层 Indexer，副本不缓存，见 ReplicaIndexer
+func (c *CachedIndexer) GetReplicas(key string) ([]int, error) {
+	ri, ok := c.next.(ReplicaIndexer)
+	if !ok {
+		return nil, nil
+	}
+	return ri.GetReplicas(key)
+}
+
+func (c *CachedIndexer) AddReplica(key string, diskId int) error {
+	ri, ok := c.next.(ReplicaIndexer)
+	if !ok {
+		return ErrNoReplicaIndex
+	}
+	return ri.AddReplica(key, diskId)
+}
+
+func (c *CachedIndexer) DeleteReplica(key string, diskId int) error {
+	ri, ok := c.next.(ReplicaIndexer)
+	if !ok {
+		return ErrNoReplicaIndex
+	}
+	return ri.DeleteReplica(key, diskId)
+}
+
+func (c *CachedIndexer) Stats() IndexCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return IndexCacheStats{Capacity: c.capacity, Size: c.ll.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+func (c *CachedIndexer) set(key string, diskId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.put(key, diskId)
+}
+
+func (c *CachedIndexer) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// put 调用方需持有 mu
+func (c *CachedIndexer) put(key string, diskId int) {
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheEntry).diskId = diskId
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, diskId: diskId})
+	if c.ll.Len() > c.capacity {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
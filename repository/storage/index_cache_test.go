@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "testing"
+
+// countingIndexer 统计落到底层 Indexer 的查询次数
+type countingIndexer struct {
+	MockIndexer
+	gets int
+}
+
+func (ci *countingIndexer) Get(key string) (int, error) {
+	ci.gets++
+	return ci.MockIndexer.Get(key)
+}
+
+func TestCachedIndexer(t *testing.T) {
+	ci := &countingIndexer{MockIndexer: MockIndexer{mapDiskFile: map[string]int{}}}
+	c := NewCachedIndexer(ci, 2)
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	for i := 0; i < 3; i++ {
+		if diskId, err := c.Get("a"); err != nil || diskId != 1 {
+			t.Fatalf("get a: %v %v", diskId, err)
+		}
+	}
+	if ci.gets != 0 {
+		t.Errorf("add should fill the cache, got %v backend gets", ci.gets)
+	}
+
+	// 容量为 2，加入 c 后最久未用的 b 被淘汰
+	c.Add("c", 3)
+	if diskId, _ := c.Get("b"); diskId != 2 || ci.gets != 1 {
+		t.Errorf("b should be evicted: diskId %v, backend gets %v", diskId, ci.gets)
+	}
+
+	c.Update("b", 5)
+	if diskId, _ := c.Get("b"); diskId != 5 || ci.gets != 1 {
+		t.Errorf("update should write through: diskId %v, backend gets %v", diskId, ci.gets)
+	}
+
+	c.Delete("b")
+	if _, err := c.Get("b"); err == nil {
+		t.Errorf("deleted key should not be cached")
+	}
+
+	// 不存在的 key 不缓存
+	if _, err := c.Get("b"); err == nil || ci.gets != 3 {
+		t.Errorf("miss should not be cached, backend gets %v", ci.gets)
+	}
+
+	stats := c.Stats()
+	if stats.Size != 1 || stats.Capacity != 2 || stats.Hits != 4 || stats.Misses != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if count, err := c.Count(3); err != nil || count != 1 {
+		t.Errorf("count: %v %v", count, err)
+	}
+}
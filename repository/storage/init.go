@@ -17,22 +17,46 @@ package storage
 import (
 	"aofs/internal/env"
 	"fmt"
+	"time"
 )
 
 var mdstor MultiDiskStorager
+var indexCache *CachedIndexer
 
 func GetStor() MultiDiskStorager {
 	return mdstor
 }
 
+// GetIndexCacheStats 未开启索引缓存时返回 nil
+func GetIndexCacheStats() *IndexCacheStats {
+	if indexCache == nil {
+		return nil
+	}
+	stats := indexCache.Stats()
+	return &stats
+}
+
 func Init(betagIdx Indexer) error {
 
+	if err := initMasterKey(); err != nil {
+		return err
+	}
+
+	if env.BETAG_CACHE_SIZE > 0 {
+		indexCache = NewCachedIndexer(betagIdx, env.BETAG_CACHE_SIZE)
+		betagIdx = indexCache
+	}
+
 	if err := md.Init(betagIdx); err != nil {
 		return err
 	}
 
 	mdstor = &md
 
+	if env.DISK_INFO_WATCH_INTERVAL > 0 {
+		go md.watchDiskInfo(time.Duration(env.DISK_INFO_WATCH_INTERVAL) * time.Second)
+	}
+
 	logger.LogI().Msg(fmt.Sprintf("Init Disk Storage:%v", env.DATA_PATH))
 
 	return nil
@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/utils"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// keyLocker 按对象 key 加锁，保证同一对象的搬迁和删除互斥
+type keyLocker struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	ref int
+}
+
+func (kl *keyLocker) Lock(key string) {
+	kl.mu.Lock()
+	if kl.locks == nil {
+		kl.locks = make(map[string]*keyLock)
+	}
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.ref++
+	kl.mu.Unlock()
+
+	l.Lock()
+}
+
+func (kl *keyLocker) Unlock(key string) {
+	kl.mu.Lock()
+	l := kl.locks[key]
+	l.ref--
+	if l.ref == 0 {
+		delete(kl.locks, key)
+	}
+	kl.mu.Unlock()
+
+	l.Unlock()
+}
+
+// MoveObject 将对象及其 DERIVE_BUCKET 下的预览目录搬迁到 dstDiskId 盘。
+// 先复制并回读校验，再修改索引，最后删除源文件；返回搬迁的对象字节数。
+func (m *multiDisk) MoveObject(bucket string, key string, dstDiskId int, limiter *utils.RateLimiter) (int64, error) {
+	if len(key) < 4 {
+		return 0, fmt.Errorf("key(%v) is invalid", key)
+	}
+
+	m.keyLock.Lock(key)
+	defer m.keyLock.Unlock(key)
+
+	srcDiskId, err := m.indexer.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if srcDiskId == dstDiskId {
+		return 0, nil
+	}
+	for _, diskId := range m.replicas(key) {
+		if diskId == dstDiskId {
+			return m.promoteReplica(bucket, key, srcDiskId, dstDiskId, limiter)
+		}
+	}
+
+	srcDir, err := m.PreDir(srcDiskId, bucket, key, false)
+	if err != nil {
+		return 0, err
+	}
+	dstDir, err := m.PreDir(dstDiskId, bucket, key, true)
+	if err != nil {
+		return 0, err
+	}
+	srcFile := filepath.Join(srcDir, key)
+	dstFile := filepath.Join(dstDir, key)
+
+	n, err := copyFileVerified(srcFile, dstFile, limiter)
+	if err != nil {
+		return 0, err
+	}
+
+	srcDerive, _ := m.PreDir(srcDiskId, env.DERIVE_BUCKET, key, false)
+	dstDerive, _ := m.PreDir(dstDiskId, env.DERIVE_BUCKET, key, false)
+	srcDerive = filepath.Join(srcDerive, key)
+	dstDerive = filepath.Join(dstDerive, key)
+	if err := copyDir(srcDerive, dstDerive, limiter); err != nil {
+		os.Remove(dstFile)
+		return 0, err
+	}
+
+	if err := m.indexer.Update(key, dstDiskId); err != nil {
+		os.Remove(dstFile)
+		os.RemoveAll(dstDerive)
+		return 0, err
+	}
+
+	if err := os.Remove(srcFile); err != nil {
+		logger.LogW().Err(err).Str("key", key).Msg("failed to remove moved object")
+	}
+	os.RemoveAll(srcDerive)
+
+	logger.LogI().Str("key", key).Int("src", srcDiskId).Int("dst", dstDiskId).Int64("size", n).Msg("object moved")
+	return n, nil
+}
+
+// copyFileVerified 复制文件到 dst.tmp，刷盘后回读比对 md5，一致再重命名为 dst
+func copyFileVerified(src string, dst string, limiter *utils.RateLimiter) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return 0, err
+	}
+
+	srcHash := md5.New()
+	n, err := io.Copy(io.MultiWriter(out, srcHash), utils.NewLimitedReader(in, limiter))
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	dstHash := md5.New()
+	if f, err := os.Open(tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	} else {
+		_, err = io.Copy(dstHash, utils.NewLimitedReader(f, limiter))
+		f.Close()
+		if err != nil {
+			os.Remove(tmp)
+			return 0, err
+		}
+	}
+
+	if !bytes.Equal(srcHash.Sum(nil), dstHash.Sum(nil)) {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("verify %v failed: md5 mismatch", dst)
+	}
+
+	return n, os.Rename(tmp, dst)
+}
+
+// copyDir 复制预览目录，src 不存在时直接返回
+func copyDir(src string, dst string, limiter *utils.RateLimiter) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		s := filepath.Join(src, e.Name())
+		d := filepath.Join(dst, e.Name())
+		if e.IsDir() {
+			err = copyDir(s, d, limiter)
+		} else {
+			_, err = copyFileVerified(s, d, limiter)
+		}
+		if err != nil {
+			os.RemoveAll(dst)
+			return err
+		}
+	}
+	return nil
+}
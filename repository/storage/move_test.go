@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveObject(t *testing.T) {
+	root := t.TempDir()
+	mi := &MockIndexer{mapDiskFile: map[string]int{}}
+	m := multiDisk{
+		mapDisk: map[int]string{1: filepath.Join(root, "d1"), 2: filepath.Join(root, "d2")},
+		indexer: mi,
+	}
+
+	key := "0a1b2c3d4e"
+	data := []byte("hello rebalance")
+	dir, _ := m.PreDir(1, "bucketa", key, true)
+	if err := ioutil.WriteFile(filepath.Join(dir, key), data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	deriveDir, _ := m.PreDir(1, env.DERIVE_BUCKET, key, true)
+	os.MkdirAll(filepath.Join(deriveDir, key), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(deriveDir, key, thumbFileName), []byte("thumb"), os.ModePerm)
+	mi.Add(key, 1)
+
+	n, err := m.MoveObject("bucketa", key, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("moved size: got %v, want %v", n, len(data))
+	}
+	if diskId, _ := mi.Get(key); diskId != 2 {
+		t.Errorf("index not updated: %v", diskId)
+	}
+
+	rc, err := m.Get("bucketa", key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(got) != string(data) {
+		t.Errorf("data: got %q", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, key)); !os.IsNotExist(err) {
+		t.Errorf("source object should be removed: %v", err)
+	}
+	dstDerive, _ := m.PreDir(2, env.DERIVE_BUCKET, key, false)
+	if _, err := os.Stat(filepath.Join(dstDerive, key, thumbFileName)); err != nil {
+		t.Errorf("preview not moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(deriveDir, key)); !os.IsNotExist(err) {
+		t.Errorf("source preview should be removed: %v", err)
+	}
+
+	// 已在目标盘时不做任何事
+	if n, err := m.MoveObject("bucketa", key, 2, nil); err != nil || n != 0 {
+		t.Errorf("move to same disk: %v, %v", n, err)
+	}
+}
+
+func TestDelDerived(t *testing.T) {
+	root := t.TempDir()
+	mi := &MockIndexer{mapDiskFile: map[string]int{}}
+	m := multiDisk{
+		mapDisk: map[int]string{1: filepath.Join(root, "d1")},
+		indexer: mi,
+	}
+
+	oldBucket := env.NORMAL_BUCKET
+	env.NORMAL_BUCKET = "bucketa"
+	defer func() { env.NORMAL_BUCKET = oldBucket }()
+
+	key := "0a1b2c3d4e"
+	dir, _ := m.PreDir(1, env.NORMAL_BUCKET, key, true)
+	ioutil.WriteFile(filepath.Join(dir, key), []byte("hello"), os.ModePerm)
+	deriveDir, _ := m.PreDir(1, env.DERIVE_BUCKET, key, true)
+	os.MkdirAll(filepath.Join(deriveDir, key), os.ModePerm)
+	ioutil.WriteFile(filepath.Join(deriveDir, key, thumbFileName), []byte("thumb"), os.ModePerm)
+	mi.Add(key, 1)
+
+	if err := m.Del(env.NORMAL_BUCKET, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, key)); !os.IsNotExist(err) {
+		t.Errorf("object should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(deriveDir, key)); !os.IsNotExist(err) {
+		t.Errorf("derived files should be removed: %v", err)
+	}
+}
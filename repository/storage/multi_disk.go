@@ -15,18 +15,17 @@
 package storage
 
 import (
-	"archive/zip"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
 
 	"aofs/internal/env"
 	"aofs/internal/proto"
 	"aofs/internal/utils"
-	"aofs/repository/dbutils"
 )
 
 /*
@@ -40,39 +39,75 @@ var ErrEnoughSpace error = errors.New("not Enough Space")
 
 type MultiDiskStorager interface {
 	IsExist(bucket string, key string) (bool, error)
-	Put(bucket string, key string, r io.Reader, size int64) error
+	Put(bucket string, key string, r io.Reader, size int64, opts *PutOptions) error
 	Get(bucket string, key string, part *proto.Part) (io.ReadCloser, error)
 	Del(bucket string, key string) error
 	GenPath(bucket string, key string, size int64) (int, string, error) //分配文件实际存储盘
+	AllocTierDisk(primary bool, size int64) (int, error)                //在主存储或次存储中分配搬迁目标盘
+	IsPrimary(diskId int) bool                                          //是否主存储
 	GetDiskPath(diskId int) (string, error)
 	GetDiskPathByBEtag(key string) (string, error)
 	GetDiskMPPath(diskId int) (string, error)
-	MoveFile(path string, diskId int, bucket, key string) (string, error) //将文件移动到内部
+	MoveFile(path string, diskId int, bucket, key string, opts *PutOptions) (string, error) //将文件移动到内部
 	GetPath(bucket string, key string) (string, error)
 	GetRelativePath(bucket string, key string) (string, error)
 	GetMultipartPath() (int, string) //获取分片上传集中存储路径
 	GetFileAbsPath(bucket string, key string) (string, error)
+	IsRaw(bucket string, key string) (bool, error)                                                  //对象文件是否为原始内容，加密或压缩存储的对象不能直接读取文件
+	GetDiskIds() []int                                                                              //获取所有盘序号，升序
+	MoveObject(bucket string, key string, dstDiskId int, limiter *utils.RateLimiter) (int64, error) //搬迁对象到其他盘
+	SetDraining(diskId int, draining bool) error                                                    //标记盘为下线中，不再分配新文件
+	IsDraining(diskId int) bool
+	Reload() (*ReloadResult, error)       //重新加载磁盘信息
+	Reindex(key string, diskId int) error //修复索引，使其指向 diskId 盘上已有的对象文件
+	Locations(key string) ([]int, error)  //获取对象所有副本所在的盘，主副本在前
+
+	//只读取 diskId 盘上的那份，不回退到其他副本
+	GetAt(bucket string, key string, diskId int) (io.ReadCloser, error)
+	//保持对象在 copies 块不同的盘上各有一份，返回新增的副本数
+	Replicate(bucket string, key string, copies int, limiter *utils.RateLimiter) (int, error)
+	//把 diskId 盘上的副本搬到其他盘，返回复制的字节数
+	EvacuateReplica(bucket string, key string, diskId int, limiter *utils.RateLimiter) (int64, error)
+
+	DiskStats() ([]DiskStat, error) //获取所有盘的挂载信息、空间和状态
 }
 
 type multiDisk struct {
+	mu      sync.RWMutex //保护 mapDisk 和 primary，重新加载磁盘信息时整体替换
 	mapDisk map[int]string
+	primary map[int]bool //盘序号 -> 是否主存储
 	indexer Indexer
+	policy  AllocPolicy
+	keyLock keyLocker
+
+	stateMu  sync.Mutex
+	draining map[int]bool //下线中的盘
 }
 
 var md multiDisk
 
+// 获取磁盘剩余空间，测试时可替换
+var diskUsage = DiskUsage
+
 //初始化目录
 func (m *multiDisk) Init(idx Indexer) error {
 	m.indexer = idx
-	m.mapDisk = make(map[int]string, 5)
+
+	if policy, err := NewAllocPolicy(env.DISK_ALLOC_POLICY); err != nil {
+		logger.LogW().Err(err).Msg("use default disk alloc policy")
+		m.policy = primaryFirstPolicy{}
+	} else {
+		m.policy = policy
+	}
 
 	if sdi, err := getDiskInfo(); err != nil {
 		return err
 	} else {
-		for _, di := range sdi.DiskMountInfos {
+		m.mapDisk, m.primary = m.loadDisks(sdi)
 
-			m.mapDisk[int(di.DeviceSequenceNumber)] = di.getPath(sdi.FileStorageVolumePathPrefix)
-			m.initDiskDir(m.mapDisk[int(di.DeviceSequenceNumber)], *di)
+		if err := m.loadDiskState(); err != nil {
+			logger.LogE().Err(err).Msg("Failed to load disk state.")
+			return err
 		}
 
 		dir := filepath.Join(env.DATA_PATH, "multipart-meta")
@@ -87,14 +122,48 @@ func (m *multiDisk) Init(idx Indexer) error {
 	return nil
 }
 
+// loadDisks 根据磁盘信息生成盘序号到路径的映射，并初始化各盘目录
+func (m *multiDisk) loadDisks(sdi *SharedDiskInfo) (map[int]string, map[int]bool) {
+	mapDisk := make(map[int]string, len(sdi.DiskMountInfos))
+	primary := make(map[int]bool, len(sdi.DiskMountInfos))
+	for _, di := range sdi.DiskMountInfos {
+		mapDisk[int(di.DeviceSequenceNumber)] = di.getPath(sdi.FileStorageVolumePathPrefix)
+		primary[int(di.DeviceSequenceNumber)] = di.IsPrimaryStorage
+		m.initDiskDir(mapDisk[int(di.DeviceSequenceNumber)], *di)
+	}
+	return mapDisk, primary
+}
+
+// disks 获取当前磁盘映射。映射只会整体替换、不会原地修改，拿到后无需持锁。
+func (m *multiDisk) disks() (map[int]string, map[int]bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mapDisk, m.primary
+}
+
 func (m *multiDisk) GetDiskPath(diskId int) (string, error) {
-	if path, ok := m.mapDisk[diskId]; ok {
+	mapDisk, _ := m.disks()
+	if path, ok := mapDisk[diskId]; ok {
 		return path, nil
 	} else {
 		return "", fmt.Errorf("not found diskId:%v", diskId)
 	}
 }
 
+func (m *multiDisk) GetDiskIds() []int {
+	mapDisk, _ := m.disks()
+	return sortedDiskIds(mapDisk)
+}
+
+func sortedDiskIds(mapDisk map[int]string) []int {
+	ids := make([]int, 0, len(mapDisk))
+	for diskId := range mapDisk {
+		ids = append(ids, diskId)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
 func (m *multiDisk) GetDiskMPPath(diskId int) (string, error) {
 	if path, err := m.GetDiskPath(diskId); err != nil {
 		return "", err
@@ -103,19 +172,74 @@ func (m *multiDisk) GetDiskMPPath(diskId int) (string, error) {
 	}
 
 }
-func (m *multiDisk) MoveFile(path string, diskId int, bucket, key string) (string, error) {
+func (m *multiDisk) MoveFile(path string, diskId int, bucket, key string, opts *PutOptions) (string, error) {
+	//分片上传开始后盘被标记为下线中时改放到其他盘，避免搬空后又写入新对象
+	copyFile := false
+	if m.IsDraining(diskId) {
+		d, err := m.allocDisk(fileSize(path))
+		if err != nil {
+			return "", err
+		}
+		logger.LogI().Str("key", key).Int("draining", diskId).Int("diskId", d.Id).Msg("disk is draining, reallocate")
+		diskId, copyFile = d.Id, true
+	}
+
 	if dstDir, err := m.PreDir(diskId, bucket, key, true); err != nil {
 		return "", err
 	} else {
 		fpath := filepath.Join(dstDir, key)
-		if err := os.Rename(path, fpath); err != nil {
+		if encryptEnabled() || shouldCompress(opts, fileSize(path)) {
+			if err := encodeFile(path, fpath, key, opts); err != nil {
+				return "", err
+			}
+			os.Remove(path)
+		} else if copyFile {
+			//不同盘之间不能改名
+			if _, err := copyFileVerified(path, fpath, nil); err != nil {
+				return "", err
+			}
+			os.Remove(path)
+		} else if err := os.Rename(path, fpath); err != nil {
 			return "", err
-		} else {
-			return fpath, m.indexer.Add(key, diskId)
 		}
+		return fpath, m.indexer.Add(key, diskId)
 	}
 }
 
+func fileSize(path string) int64 {
+	if stat, err := os.Stat(path); err == nil {
+		return stat.Size()
+	}
+	return 0
+}
+
+// encodeFile 将原始文件 src 按配置加密或压缩后写入 dst
+func encodeFile(src, dst, key string, opts *PutOptions) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	stat, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := os.OpenFile(dst+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if err = writeObject(w, key, r, stat.Size(), shouldCompress(opts, stat.Size())); err == nil {
+		err = w.Sync()
+	}
+	w.Close()
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
 func (m *multiDisk) initDiskDir(path string, di DiskMountInfo) error {
 
 	if err := utils.WriteJsonToFile(filepath.Join(path, ".disk.info"), di); err != nil {
@@ -172,14 +296,40 @@ func (m *multiDisk) IsExist(bucket string, key string) (bool, error) {
 
 func (m *multiDisk) Get(bucket string, key string, part *proto.Part) (io.ReadCloser, error) {
 
+	var file *os.File
 	filepath, err := m.getFilePath(bucket, key)
+	if err == nil {
+		file, err = os.Open(filepath)
+		if err != nil && os.IsNotExist(err) {
+			// 对象可能刚被搬迁到其他盘，重新查询索引
+			if filepath, err = m.getFilePath(bucket, key); err == nil {
+				file, err = os.Open(filepath)
+			}
+		}
+	}
+
+	if err != nil {
+		// 主副本所在盘已移除或损坏时读取其他副本
+		if f, rerr := m.openReplica(bucket, key); rerr == nil {
+			file, err = f, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	if file, err := os.Open(filepath); err != nil {
-		return file, err
-	} else if part == nil {
+	if h, err := readEnvHeader(file, key); err != nil {
+		file.Close()
+		return nil, err
+	} else if h != nil {
+		rc, err := openEnvelope(file, h, part)
+		if err != nil {
+			file.Close()
+		}
+		return rc, err
+	}
+
+	if part == nil {
 		return file, err
 	} else {
 		if _, err := file.Seek(part.Start, os.SEEK_SET); err != nil {
@@ -196,7 +346,7 @@ func (m *multiDisk) Get(bucket string, key string, part *proto.Part) (io.ReadClo
 			return nil, err
 		} else if part.End >= stat.Size() {
 			file.Close()
-			return nil, fmt.Errorf("range not satisfiable")
+			return nil, ErrRangeNotSatisfiable
 		} else {
 			r := io.LimitReader(file, part.Len())
 			type RC struct {
@@ -228,30 +378,78 @@ func (m *multiDisk) PreDir(diskId int, bucket string, key string, mkdir bool) (s
 	}
 }
 
+// GenPath 按分配策略选择存储盘并创建目录
 func (m *multiDisk) GenPath(bucket string, key string, size int64) (int, string, error) {
-	err := fmt.Errorf("failed to gen path")
-	for diskId, path := range m.mapDisk {
-		h1 := key[:2]
-		h2 := key[2:4]
+	if len(key) < 4 {
+		return 0, "", fmt.Errorf("key(%v) is invalid", key)
+	}
 
-		dir := filepath.Join(path, bucket, h1, h2)
-		os.MkdirAll(dir, os.ModePerm)
+	disk, err := m.allocDisk(size)
+	if err != nil {
+		return 0, "", err
+	}
+
+	dir := filepath.Join(disk.Path, bucket, key[:2], key[2:4])
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, "", err
+	}
+	logger.LogD().Interface("dir", dir).Interface("freedisk", disk.Free).Msg("Gen path")
+	return disk.Id, dir, nil
+}
 
-		// 剩余空间小于500M时候限制上传和同步, *** 暂时去掉大小判断
-		freeDisk := DiskUsage(dir).Free
-		logger.LogD().Interface("dir", dir).Interface("freedisk", freeDisk).Msg("Get free disk")
+// candidates 筛选未下线、且剩余空间不小于 RESERVED_SPACE + size 的盘
+func (m *multiDisk) candidates(size int64) []AllocDisk {
+	mapDisk, primary := m.disks()
+
+	var disks []AllocDisk
+	for _, diskId := range sortedDiskIds(mapDisk) {
+		if m.IsDraining(diskId) {
+			continue
+		}
+		path := mapDisk[diskId]
+		freeDisk := diskUsage(path).Free
 		if freeDisk < uint64(env.RESERVED_SPACE+size) {
-			err = ErrEnoughSpace
 			continue
 		}
+		disks = append(disks, AllocDisk{Id: diskId, Path: path, IsPrimary: primary[diskId], Free: freeDisk})
+	}
+	return disks
+}
 
-		return diskId, dir, nil
+// allocDisk 从候选盘中按分配策略选择
+func (m *multiDisk) allocDisk(size int64) (AllocDisk, error) {
+	disks := m.candidates(size)
+	if len(disks) == 0 {
+		return AllocDisk{}, ErrEnoughSpace
 	}
 
-	return 0, "", err
+	policy := m.policy
+	if policy == nil {
+		policy = primaryFirstPolicy{}
+	}
+	return policy.Select(disks), nil
 }
 
-func (m *multiDisk) Put(bucket string, key string, r io.Reader, size int64) error {
+// AllocTierDisk 在主存储（primary 为 true）或次存储中选择剩余空间最多的盘，用于分层搬迁，不受分配策略影响
+func (m *multiDisk) AllocTierDisk(primary bool, size int64) (int, error) {
+	var disks []AllocDisk
+	for _, d := range m.candidates(size) {
+		if d.IsPrimary == primary {
+			disks = append(disks, d)
+		}
+	}
+	if len(disks) == 0 {
+		return 0, ErrEnoughSpace
+	}
+	return mostFreePolicy{}.Select(disks).Id, nil
+}
+
+func (m *multiDisk) IsPrimary(diskId int) bool {
+	_, primary := m.disks()
+	return primary[diskId]
+}
+
+func (m *multiDisk) Put(bucket string, key string, r io.Reader, size int64, opts *PutOptions) error {
 	if len(bucket) < 1 || len(key) < 4 {
 		logger.LogE().Msg("put file:param error")
 		return fmt.Errorf("key(%v) error", key)
@@ -278,7 +476,7 @@ func (m *multiDisk) Put(bucket string, key string, r io.Reader, size int64) erro
 			return fmt.Errorf("open:%v", err)
 		}
 	} else {
-		_, err = io.Copy(w, r)
+		err = writeObject(w, key, r, size, shouldCompress(opts, size))
 		if err == nil {
 			w.Sync() //刷盘，防止断电
 			w.Close()
@@ -305,16 +503,38 @@ func (m *multiDisk) Put(bucket string, key string, r io.Reader, size int64) erro
 }
 
 func (m *multiDisk) Del(bucket string, key string) error {
+	m.keyLock.Lock(key)
+	defer m.keyLock.Unlock(key)
 
 	fpath, err := m.getFilePath(bucket, key)
 	if err != nil {
 		return err
 	}
 
+	//预览图等派生文件随源对象一并删除
+	var derive string
+	if bucket == env.NORMAL_BUCKET {
+		if diskId, err := m.indexer.Get(key); err == nil {
+			derive, _ = m.objectPath(diskId, env.DERIVE_BUCKET, key)
+		}
+	}
+
+	replicas := m.replicas(key)
 	_, err = m.indexer.Delete(key)
 	if err != nil {
 		return err
 	}
+	for _, diskId := range replicas {
+		if err := m.deleteReplica(m.replicaIndexer(), bucket, key, diskId); err != nil {
+			logger.LogW().Err(err).Str("key", key).Int("diskId", diskId).Msg("failed to delete replica")
+		}
+	}
+
+	if len(derive) > 0 {
+		if err := os.RemoveAll(derive); err != nil {
+			logger.LogW().Err(err).Str("key", key).Msg("failed to remove derived files")
+		}
+	}
 
 	err = os.Remove(fpath)
 	logger.LogI().Msg(fmt.Sprintf("push deleteMsg to redis,key:%v", key))
@@ -326,6 +546,20 @@ func (m *multiDisk) Del(bucket string, key string) error {
 	return err
 }
 
+func (m *multiDisk) Reindex(key string, diskId int) error {
+	if _, err := m.GetDiskPath(diskId); err != nil {
+		return err
+	}
+
+	m.keyLock.Lock(key)
+	defer m.keyLock.Unlock(key)
+
+	if _, err := m.indexer.Get(key); err != nil {
+		return m.indexer.Add(key, diskId)
+	}
+	return m.indexer.Update(key, diskId)
+}
+
 func (m *multiDisk) GetFileAbsPath(bucket string, key string) (string, error) {
 
 	filepath, err := m.getFilePath(bucket, key)
@@ -338,8 +572,9 @@ func (m *multiDisk) GetFileAbsPath(bucket string, key string) (string, error) {
 func (m *multiDisk) GetMultipartPath() (int, string) {
 	var diskId int
 	var path string
-	for k, v := range m.mapDisk {
-		if diskId < k {
+	mapDisk, _ := m.disks()
+	for k, v := range mapDisk {
+		if diskId < k && !m.IsDraining(k) {
 			diskId = k
 			path = v
 		}
@@ -347,6 +582,20 @@ func (m *multiDisk) GetMultipartPath() (int, string) {
 	return diskId, filepath.Join(path, "multipart")
 }
 
+func (m *multiDisk) IsRaw(bucket string, key string) (bool, error) {
+	fpath, err := m.getFilePath(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(fpath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h, err := readEnvHeader(f, key)
+	return h == nil, err
+}
+
 func (m *multiDisk) GetPath(bucket string, key string) (string, error) {
 	fpath, err := m.getFilePath(bucket, key)
 	if err != nil {
@@ -376,126 +625,3 @@ func (m *multiDisk) GetRelativePath(bucket string, key string) (string, error) {
 
 	return fpath[len(env.DATA_PATH):], nil
 }
-
-func (m *multiDisk) zip(bucket string, uuid string, zw *zip.Writer) error {
-	fileInfo, err := dbutils.GetInfoByUuid(uuid)
-	if err != nil {
-		return err
-	}
-
-	if fileInfo.IsDir {
-		fileCount := dbutils.CountFileInFolder(fileInfo.Id)
-		logger.LogD().Msg(fmt.Sprintf("file in  %s dir count: %d", fileInfo.Name, fileCount))
-		if fileCount == 0 {
-			logger.LogD().Msg(fmt.Sprintf("blank dir: %s", fileInfo.Name))
-			//subDir := strings.TrimLeft(fileInfo.Path, fileInfo.Path+fileInfo.Name)
-			subDirPath := filepath.Join("/data/" + fileInfo.Name)
-			if _, err := os.Stat(subDirPath); err != nil {
-				err := os.Mkdir(subDirPath, os.ModePerm)
-				if err != nil {
-					logger.LogE().Err(err).Msg("mkdir error")
-				}
-			}
-			subFi, _ := os.Stat(subDirPath)
-			header, err := zip.FileInfoHeader(subFi)
-			header.Name = fileInfo.Name + "/"
-			if err != nil {
-				//fmt.Println("error is:"+err.Error())
-				return err
-			}
-			_, err = zw.CreateHeader(header)
-			if err != nil {
-				//fmt.Println("create error is:"+err.Error())
-				return err
-			}
-			os.Remove(subDirPath)
-
-		}
-		if subFileIds, _ := dbutils.GetAllFileInFolder(fileInfo.UserId, uuid); subFileIds != nil {
-			for _, subId := range subFileIds {
-				subInfo, _ := dbutils.GetInfoByUuid(subId)
-				// 空文件夹处理
-				if subInfo.IsDir {
-					fileCount := dbutils.CountFileInFolder(subInfo.Id)
-					logger.LogD().Msg(fmt.Sprintf("file in  %s dir count: %d", subInfo.Name, fileCount))
-					if fileCount == 0 {
-						logger.LogD().Msg(fmt.Sprintf("blank dir: %s", subInfo.Name))
-						subDir := strings.TrimLeft(subInfo.Path, fileInfo.Path+fileInfo.Name)
-						subDirPath := filepath.Join("/data/" + subInfo.Name)
-						if _, err := os.Stat(subDirPath); err != nil {
-							err := os.Mkdir(subDirPath, os.ModePerm)
-							if err != nil {
-								logger.LogE().Err(err).Msg("mkdir error")
-							}
-						}
-						subFi, _ := os.Stat(subDirPath)
-						header, err := zip.FileInfoHeader(subFi)
-						header.Name = fileInfo.Name + "/" + subDir + subFi.Name() + "/"
-						if err != nil {
-							//fmt.Println("error is:"+err.Error())
-							return err
-						}
-						_, err = zw.CreateHeader(header)
-						if err != nil {
-							//fmt.Println("create error is:"+err.Error())
-							return err
-						}
-						os.Remove(subDirPath)
-
-					}
-
-				}
-				subFilePath, err := m.getFilePath(bucket, subInfo.BETag)
-				if err != nil {
-					return err
-				}
-				//subDir := strings.Split(subInfo.Path,"/")
-
-				subDir := strings.TrimPrefix(subInfo.Path, fileInfo.Path+fileInfo.Name+"/")
-
-				//在压缩包中创建一个文件
-				if !subInfo.IsDir {
-					logger.LogD().Msg(fmt.Sprintf("开始压缩：%s", fileInfo.Path+fileInfo.Name+"/"+subDir+subInfo.Name))
-					subfile, _ := os.Open(subFilePath)
-					subFileOnZip, err := zw.Create(fileInfo.Name + "/" + subDir + subInfo.Name)
-					if err != nil {
-						logger.LogE().Err(err).Msg(fmt.Sprintf("在压缩包中创建文件失败:%s", fileInfo.Name+"/"+subDir+subInfo.Name))
-						subfile.Close()
-						return err
-					}
-					_, err = io.Copy(subFileOnZip, subfile)
-					if err != nil {
-						logger.LogE().Err(err).Msg(fmt.Sprintf("failed to io.copy %s", subInfo.Id))
-						return err
-					}
-				}
-
-			}
-
-		}
-
-	} else {
-		filePath, err := m.getFilePath(bucket, fileInfo.BETag)
-		if err != nil {
-			return err
-		}
-
-		//zipWriter.CreateHeader()
-		//在压缩包中创建一个文件
-		fileOnZip, err := zw.Create(fileInfo.Name)
-		if err != nil {
-			logger.LogE().Err(err).Msg(fmt.Sprintf("在压缩包中创建文件失败:%s", fileInfo.Name))
-			return err
-		}
-		//读取需要压缩的文件
-		subfile, _ := os.Open(filePath)
-		defer subfile.Close()
-		_, err = io.Copy(fileOnZip, subfile)
-		if err != nil {
-			return err
-		}
-
-	}
-	//defer zw.Close()
-	return nil
-}
\ No newline at end of file
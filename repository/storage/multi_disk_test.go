@@ -49,6 +49,24 @@ func (mi *MockIndexer) Add(key string, diskId int) error {
 	return nil
 }
 
+func (mi *MockIndexer) Update(key string, diskId int) error {
+	if _, ok := mi.mapDiskFile[key]; !ok {
+		return fmt.Errorf("not found")
+	}
+	mi.mapDiskFile[key] = diskId
+	return nil
+}
+
+func (mi *MockIndexer) Count(diskId int) (int64, error) {
+	var count int64
+	for _, id := range mi.mapDiskFile {
+		if id == diskId {
+			count++
+		}
+	}
+	return count, nil
+}
+
 func TestPutAndGet(t *testing.T) {
 	var mi MockIndexer
 	mi.mapDiskFile = map[string]int{}
@@ -62,7 +80,7 @@ func TestPutAndGet(t *testing.T) {
 	bucket := "bucketa"
 	key := text
 
-	if err := md.Put(bucket, key, strings.NewReader(text), int64(len(text))); err != nil {
+	if err := md.Put(bucket, key, strings.NewReader(text), int64(len(text)), nil); err != nil {
 		t.Error(err)
 	} else {
 		if rc, err := md.Get(bucket, key, &proto.Part{Start: 0, End: 2}); err != nil {
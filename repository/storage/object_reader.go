@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/proto"
+	"io"
+	"sync"
+)
+
+// ObjectReaderAt 基于 Get 的范围读取实现 io.ReaderAt，用于 zip 等需要随机访问的格式。
+// 连续读取时复用同一个 reader，不会每次都重新打开对象。
+type ObjectReaderAt struct {
+	stor   MultiDiskStorager
+	bucket string
+	key    string
+	size   int64
+
+	mu  sync.Mutex
+	rc  io.ReadCloser
+	pos int64 //rc 当前读到的位置
+}
+
+func NewObjectReaderAt(stor MultiDiskStorager, bucket string, key string, size int64) *ObjectReaderAt {
+	return &ObjectReaderAt{stor: stor, bucket: bucket, key: key, size: size}
+}
+
+func (r *ObjectReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *ObjectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if int64(want) > r.size-off {
+		want = int(r.size - off)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rc == nil || r.pos != off {
+		r.close()
+		rc, err := r.stor.Get(r.bucket, r.key, &proto.Part{Start: off, End: -1})
+		if err != nil {
+			return 0, err
+		}
+		r.rc, r.pos = rc, off
+	}
+
+	n, err := io.ReadFull(r.rc, p[:want])
+	r.pos += int64(n)
+	if err != nil {
+		r.close()
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *ObjectReaderAt) close() {
+	if r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+}
+
+func (r *ObjectReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.close()
+	return nil
+}
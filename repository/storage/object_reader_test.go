@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/proto"
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// memStor 只实现 Get，记录打开次数
+type memStor struct {
+	MultiDiskStorager
+	data  []byte
+	opens int
+}
+
+func (m *memStor) Get(bucket string, key string, part *proto.Part) (io.ReadCloser, error) {
+	m.opens++
+	start := int64(0)
+	if part != nil {
+		start = part.Start
+	}
+	return io.NopCloser(bytes.NewReader(m.data[start:])), nil
+}
+
+func TestObjectReaderAt(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{"a.txt": "hello", "dir/b.txt": "world"}
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	ms := &memStor{data: buf.Bytes()}
+	ra := NewObjectReaderAt(ms, "normal", "key", int64(buf.Len()))
+	defer ra.Close()
+
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != files[f.Name] {
+			t.Errorf("%v: got %q, %v", f.Name, got, err)
+		}
+	}
+
+	// 连续读取不重新打开对象
+	opens := ms.opens
+	p := make([]byte, 4)
+	ra.ReadAt(p, 0)
+	ra.ReadAt(p, 4)
+	if ms.opens != opens+1 {
+		t.Errorf("sequential reads opened object %v times", ms.opens-opens)
+	}
+
+	if n, err := ra.ReadAt(p, ra.Size()-2); n != 2 || err != io.EOF {
+		t.Errorf("read at tail: %v %v", n, err)
+	}
+	if _, err := ra.ReadAt(p, ra.Size()); err != io.EOF {
+		t.Errorf("read past end: %v", err)
+	}
+}
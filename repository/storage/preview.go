@@ -16,6 +16,7 @@ package storage
 
 import (
 	"aofs/internal/env"
+	"fmt"
 	"path/filepath"
 )
 
@@ -67,6 +68,16 @@ func (s *PreviewStore) GetThumbnailPath(key string) (string, error) {
 	return path, nil
 }
 
+// GetThumbnailSizePath 指定尺寸的缩略图，与源对象的其他派生文件放在同一目录
+func (s *PreviewStore) GetThumbnailSizePath(key string, size int) (string, error) {
+	baseDir, err := s.GetPreviewDir(key)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(baseDir, fmt.Sprintf("thumbnail-%d.jpg", size))
+	return path, nil
+}
+
 func (s *PreviewStore) GetCompressedImgPath(key string) (string, error) {
 	baseDir, err := s.GetPreviewDir(key)
 	if err != nil {
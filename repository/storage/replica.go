@@ -0,0 +1,329 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+//此文件完成对象的多副本：主副本记录在 Indexer 中，其他副本记录在 ReplicaIndexer 中，各副本位于不同的盘
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/utils"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrNoReplicaIndex = errors.New("indexer does not support replicas")
+
+// ReplicaIndexer 可选接口，记录对象在主副本之外的其他副本所在盘
+type ReplicaIndexer interface {
+	GetReplicas(key string) ([]int, error)
+	AddReplica(key string, diskId int) error
+	DeleteReplica(key string, diskId int) error
+}
+
+func (m *multiDisk) replicaIndexer() ReplicaIndexer {
+	ri, _ := m.indexer.(ReplicaIndexer)
+	return ri
+}
+
+// replicas 获取对象其他副本所在的盘，不支持副本或查询失败时返回空
+func (m *multiDisk) replicas(key string) []int {
+	ri := m.replicaIndexer()
+	if ri == nil {
+		return nil
+	}
+	ids, err := ri.GetReplicas(key)
+	if err != nil {
+		logger.LogW().Err(err).Str("key", key).Msg("failed to get replicas")
+		return nil
+	}
+	return ids
+}
+
+// Locations 获取对象所有副本所在的盘，主副本在前
+func (m *multiDisk) Locations(key string) ([]int, error) {
+	diskId, err := m.indexer.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return append([]int{diskId}, m.replicas(key)...), nil
+}
+
+func (m *multiDisk) objectPath(diskId int, bucket string, key string) (string, error) {
+	dir, err := m.PreDir(diskId, bucket, key, false)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, key), nil
+}
+
+func (m *multiDisk) objectExists(diskId int, bucket string, key string) bool {
+	path, err := m.objectPath(diskId, bucket, key)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// openReplica 主副本不可读时依次尝试其他副本
+func (m *multiDisk) openReplica(bucket string, key string) (*os.File, error) {
+	for _, diskId := range m.replicas(key) {
+		path, err := m.objectPath(diskId, bucket, key)
+		if err != nil {
+			continue
+		}
+		if f, err := os.Open(path); err == nil {
+			logger.LogW().Str("key", key).Int("diskId", diskId).Msg("read from replica")
+			return f, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// GetAt 读取 diskId 盘上的那份对象，不回退到其他副本，供校验逐份检查
+func (m *multiDisk) GetAt(bucket string, key string, diskId int) (io.ReadCloser, error) {
+	path, err := m.objectPath(diskId, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := readEnvHeader(file, key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	} else if h == nil {
+		return file, nil
+	}
+	rc, err := openEnvelope(file, h, nil)
+	if err != nil {
+		file.Close()
+	}
+	return rc, err
+}
+
+// deleteReplica 删除 diskId 上的副本文件和索引
+func (m *multiDisk) deleteReplica(ri ReplicaIndexer, bucket string, key string, diskId int) error {
+	if path, err := m.objectPath(diskId, bucket, key); err == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.LogW().Err(err).Str("key", key).Int("diskId", diskId).Msg("failed to remove replica")
+		}
+	}
+	return ri.DeleteReplica(key, diskId)
+}
+
+// promoteReplica 目标盘上已有副本时交换主副本和该副本的角色，原主副本的文件保留为副本，副本数不变。
+// 预览目录跟随主副本。调用方需持有 key 锁。
+func (m *multiDisk) promoteReplica(bucket string, key string, srcDiskId int, dstDiskId int, limiter *utils.RateLimiter) (int64, error) {
+	ri := m.replicaIndexer()
+	dstFile, err := m.objectPath(dstDiskId, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+
+	srcDerive, _ := m.objectPath(srcDiskId, env.DERIVE_BUCKET, key)
+	dstDerive, _ := m.objectPath(dstDiskId, env.DERIVE_BUCKET, key)
+	if err := copyDir(srcDerive, dstDerive, limiter); err != nil {
+		return 0, err
+	}
+	//先登记原主副本，失败时不改动主副本
+	if err := ri.AddReplica(key, srcDiskId); err != nil {
+		os.RemoveAll(dstDerive)
+		return 0, err
+	}
+	if err := m.indexer.Update(key, dstDiskId); err != nil {
+		ri.DeleteReplica(key, srcDiskId)
+		os.RemoveAll(dstDerive)
+		return 0, err
+	}
+	if err := ri.DeleteReplica(key, dstDiskId); err != nil {
+		logger.LogW().Err(err).Str("key", key).Int("diskId", dstDiskId).Msg("failed to delete promoted replica")
+	}
+
+	os.RemoveAll(srcDerive)
+	logger.LogI().Str("key", key).Int("src", srcDiskId).Int("dst", dstDiskId).Msg("replica promoted")
+	return fileSize(dstFile), nil
+}
+
+// EvacuateReplica 把 diskId 盘上的副本搬到对象尚无副本的其他盘，副本数不变。
+// diskId 上的副本文件不可读时从主副本复制。返回复制的字节数。
+func (m *multiDisk) EvacuateReplica(bucket string, key string, diskId int, limiter *utils.RateLimiter) (int64, error) {
+	ri := m.replicaIndexer()
+	if ri == nil {
+		return 0, ErrNoReplicaIndex
+	}
+
+	m.keyLock.Lock(key)
+	defer m.keyLock.Unlock(key)
+
+	locations, err := m.Locations(key)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[int]bool, len(locations))
+	for _, id := range locations {
+		used[id] = true
+	}
+	if !used[diskId] || locations[0] == diskId {
+		//副本已不在该盘上
+		return 0, nil
+	}
+
+	src, err := m.objectPath(diskId, bucket, key)
+	if err != nil || !m.objectExists(diskId, bucket, key) {
+		if src, err = m.objectPath(locations[0], bucket, key); err != nil {
+			return 0, err
+		}
+	}
+	size := fileSize(src)
+
+	var disks []AllocDisk
+	for _, d := range m.candidates(size) {
+		if !used[d.Id] {
+			disks = append(disks, d)
+		}
+	}
+	if len(disks) == 0 {
+		return 0, ErrEnoughSpace
+	}
+	dst := mostFreePolicy{}.Select(disks).Id
+
+	dstDir, err := m.PreDir(dst, bucket, key, true)
+	if err != nil {
+		return 0, err
+	}
+	dstFile := filepath.Join(dstDir, key)
+	n, err := copyFileVerified(src, dstFile, limiter)
+	if err != nil {
+		return 0, err
+	}
+	if err := ri.AddReplica(key, dst); err != nil {
+		os.Remove(dstFile)
+		return 0, err
+	}
+	if err := m.deleteReplica(ri, bucket, key, diskId); err != nil {
+		return n, err
+	}
+	logger.LogI().Str("key", key).Int("src", diskId).Int("dst", dst).Msg("replica evacuated")
+	return n, nil
+}
+
+// Replicate 使对象在 copies 块不同的盘上各有一份。
+// 丢失的副本会从索引中移除，主副本丢失时提升一个存活的副本；copies 小于现有副本数时删除多余的副本。
+// 返回新增的副本数。
+func (m *multiDisk) Replicate(bucket string, key string, copies int, limiter *utils.RateLimiter) (int, error) {
+	ri := m.replicaIndexer()
+	if ri == nil {
+		return 0, ErrNoReplicaIndex
+	}
+	if copies < 1 {
+		copies = 1
+	}
+
+	m.keyLock.Lock(key)
+	defer m.keyLock.Unlock(key)
+
+	primary, err := m.indexer.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	replicas, err := ri.GetReplicas(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var alive []int
+	for _, diskId := range replicas {
+		if m.objectExists(diskId, bucket, key) {
+			alive = append(alive, diskId)
+		} else if err := ri.DeleteReplica(key, diskId); err != nil {
+			return 0, err
+		} else {
+			logger.LogW().Str("key", key).Int("diskId", diskId).Msg("replica lost")
+		}
+	}
+
+	if !m.objectExists(primary, bucket, key) {
+		if len(alive) == 0 {
+			return 0, fmt.Errorf("%v: no surviving copy", key)
+		}
+		lost := primary
+		primary, alive = alive[0], alive[1:]
+		if err := m.indexer.Update(key, primary); err != nil {
+			return 0, err
+		}
+		if err := ri.DeleteReplica(key, primary); err != nil {
+			return 0, err
+		}
+		logger.LogW().Str("key", key).Int("lost", lost).Int("diskId", primary).Msg("primary copy lost, replica promoted")
+	}
+
+	//副本过多时从后往前删除
+	for len(alive) > copies-1 {
+		diskId := alive[len(alive)-1]
+		if err := m.deleteReplica(ri, bucket, key, diskId); err != nil {
+			return 0, err
+		}
+		alive = alive[:len(alive)-1]
+	}
+
+	used := map[int]bool{primary: true}
+	for _, diskId := range alive {
+		used[diskId] = true
+	}
+	src, err := m.objectPath(primary, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	size := fileSize(src)
+
+	added := 0
+	for len(used) < copies {
+		var disks []AllocDisk
+		for _, d := range m.candidates(size) {
+			if !used[d.Id] {
+				disks = append(disks, d)
+			}
+		}
+		if len(disks) == 0 {
+			return added, ErrEnoughSpace
+		}
+		dst := mostFreePolicy{}.Select(disks).Id
+
+		dstDir, err := m.PreDir(dst, bucket, key, true)
+		if err != nil {
+			return added, err
+		}
+		dstFile := filepath.Join(dstDir, key)
+		if _, err := copyFileVerified(src, dstFile, limiter); err != nil {
+			return added, err
+		}
+		if err := ri.AddReplica(key, dst); err != nil {
+			os.Remove(dstFile)
+			return added, err
+		}
+		used[dst] = true
+		added++
+	}
+	if added > 0 {
+		logger.LogI().Str("key", key).Int("copies", copies).Int("added", added).Msg("object replicated")
+	}
+	return added, nil
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"aofs/internal/env"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type mockReplicaIndexer struct {
+	MockIndexer
+	replicas map[string][]int
+}
+
+func (mi *mockReplicaIndexer) GetReplicas(key string) ([]int, error) {
+	return append([]int(nil), mi.replicas[key]...), nil
+}
+
+func (mi *mockReplicaIndexer) AddReplica(key string, diskId int) error {
+	mi.replicas[key] = append(mi.replicas[key], diskId)
+	return nil
+}
+
+func (mi *mockReplicaIndexer) DeleteReplica(key string, diskId int) error {
+	ids := mi.replicas[key][:0]
+	for _, id := range mi.replicas[key] {
+		if id != diskId {
+			ids = append(ids, id)
+		}
+	}
+	mi.replicas[key] = ids
+	return nil
+}
+
+func TestReplicate(t *testing.T) {
+	root := t.TempDir()
+	mi := &mockReplicaIndexer{MockIndexer: MockIndexer{mapDiskFile: map[string]int{}}, replicas: map[string][]int{}}
+	m := multiDisk{
+		mapDisk: map[int]string{1: filepath.Join(root, "d1"), 2: filepath.Join(root, "d2"), 3: filepath.Join(root, "d3")},
+		primary: map[int]bool{},
+		indexer: mi,
+	}
+	free := map[string]uint64{m.mapDisk[1]: 30 * GB, m.mapDisk[2]: 20 * GB, m.mapDisk[3]: 10 * GB}
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return DiskStatus{Free: free[path]} }
+	defer func() { diskUsage = old }()
+	oldReserved := env.RESERVED_SPACE
+	env.RESERVED_SPACE = 0
+	defer func() { env.RESERVED_SPACE = oldReserved }()
+
+	key := "0a1b2c3d4e"
+	data := []byte("hello replica")
+	dir, _ := m.PreDir(1, "bucketa", key, true)
+	if err := ioutil.WriteFile(filepath.Join(dir, key), data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	mi.Add(key, 1)
+
+	if n, err := m.Replicate("bucketa", key, 2, nil); err != nil || n != 1 {
+		t.Fatalf("replicate: %v, %v", n, err)
+	}
+	if locs, _ := m.Locations(key); len(locs) != 2 || locs[0] != 1 || locs[1] != 2 {
+		t.Fatalf("locations: %v", locs)
+	}
+
+	// 主副本丢失时从副本读取
+	os.Remove(filepath.Join(dir, key))
+	rc, err := m.Get("bucketa", key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(got) != string(data) {
+		t.Errorf("failover data: got %q", got)
+	}
+	// 按位置读取时不回退到其他副本
+	if _, err := m.GetAt("bucketa", key, 1); !os.IsNotExist(err) {
+		t.Errorf("GetAt missing primary: %v", err)
+	}
+	if rc, err := m.GetAt("bucketa", key, 2); err != nil {
+		t.Errorf("GetAt replica: %v", err)
+	} else {
+		got, _ = ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != string(data) {
+			t.Errorf("GetAt replica data: got %q", got)
+		}
+	}
+
+	// 修复：提升存活副本为主副本，并补齐副本数
+	if n, err := m.Replicate("bucketa", key, 2, nil); err != nil || n != 1 {
+		t.Fatalf("repair: %v, %v", n, err)
+	}
+	if diskId, _ := mi.Get(key); diskId != 2 {
+		t.Errorf("primary not promoted: %v", diskId)
+	}
+	locs, _ := m.Locations(key)
+	sort.Ints(locs)
+	if len(locs) != 2 || locs[0] != 1 || locs[1] != 2 {
+		t.Errorf("locations after repair: %v", locs)
+	}
+
+	// 副本数减少时删除多余副本
+	if _, err := m.Replicate("bucketa", key, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(mi.replicas[key]) != 0 || m.objectExists(1, "bucketa", key) {
+		t.Errorf("replica not trimmed: %v", mi.replicas[key])
+	}
+
+	// 删除对象时一并删除副本
+	m.Replicate("bucketa", key, 3, nil)
+	if err := m.Del("bucketa", key); err != nil {
+		t.Fatal(err)
+	}
+	for diskId := range m.mapDisk {
+		if m.objectExists(diskId, "bucketa", key) {
+			t.Errorf("copy left on disk %v", diskId)
+		}
+	}
+}
+
+func TestMoveObjectToReplica(t *testing.T) {
+	root := t.TempDir()
+	mi := &mockReplicaIndexer{MockIndexer: MockIndexer{mapDiskFile: map[string]int{}}, replicas: map[string][]int{}}
+	m := multiDisk{
+		mapDisk: map[int]string{1: filepath.Join(root, "d1"), 2: filepath.Join(root, "d2")},
+		primary: map[int]bool{},
+		indexer: mi,
+	}
+
+	key := "0a1b2c3d4e"
+	for _, diskId := range []int{1, 2} {
+		dir, _ := m.PreDir(diskId, "bucketa", key, true)
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte("hello replica"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mi.Add(key, 1)
+	mi.AddReplica(key, 2)
+
+	// 搬到已有副本的盘时交换角色，副本数不变
+	if _, err := m.MoveObject("bucketa", key, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if locs, _ := m.Locations(key); len(locs) != 2 || locs[0] != 2 || locs[1] != 1 {
+		t.Errorf("locations: %v", locs)
+	}
+	if !m.objectExists(1, "bucketa", key) || !m.objectExists(2, "bucketa", key) {
+		t.Errorf("copy removed")
+	}
+}
+
+func TestEvacuateReplica(t *testing.T) {
+	root := t.TempDir()
+	mi := &mockReplicaIndexer{MockIndexer: MockIndexer{mapDiskFile: map[string]int{}}, replicas: map[string][]int{}}
+	m := multiDisk{
+		mapDisk:  map[int]string{1: filepath.Join(root, "d1"), 2: filepath.Join(root, "d2"), 3: filepath.Join(root, "d3")},
+		primary:  map[int]bool{},
+		indexer:  mi,
+		draining: map[int]bool{2: true},
+	}
+	old := diskUsage
+	diskUsage = func(path string) DiskStatus { return DiskStatus{Free: 10 * GB} }
+	defer func() { diskUsage = old }()
+	oldReserved := env.RESERVED_SPACE
+	env.RESERVED_SPACE = 0
+	defer func() { env.RESERVED_SPACE = oldReserved }()
+
+	key := "0a1b2c3d4e"
+	for _, diskId := range []int{1, 2} {
+		dir, _ := m.PreDir(diskId, "bucketa", key, true)
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte("hello replica"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mi.Add(key, 1)
+	mi.AddReplica(key, 2)
+
+	// 下线盘上的副本搬到第三块盘，副本数不变
+	if _, err := m.EvacuateReplica("bucketa", key, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if locs, _ := m.Locations(key); len(locs) != 2 || locs[0] != 1 || locs[1] != 3 {
+		t.Errorf("locations: %v", locs)
+	}
+	if m.objectExists(2, "bucketa", key) || !m.objectExists(3, "bucketa", key) {
+		t.Errorf("replica not moved")
+	}
+}
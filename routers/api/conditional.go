@@ -0,0 +1,128 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+//此文件完成 RFC 7232 条件请求和缓存相关响应头的处理
+
+import (
+	"aofs/internal/env"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	//文件内容可能被覆盖，每次使用前需向服务端确认
+	cacheControlDownload = "private, no-cache"
+)
+
+func cacheControlPreview() string {
+	return fmt.Sprintf("private, max-age=%d", env.PREVIEW_CACHE_MAX_AGE)
+}
+
+// setCacheHeaders 设置 ETag、Last-Modified 和 Cache-Control，modifyTime 为毫秒
+func setCacheHeaders(c *gin.Context, etag string, modifyTime int64, cacheControl string) {
+	c.Header("ETag", `"`+etag+`"`)
+	if modifyTime > 0 {
+		c.Header("Last-Modified", time.UnixMilli(modifyTime).UTC().Format(http.TimeFormat))
+	}
+	c.Header("Cache-Control", cacheControl)
+}
+
+// notModified 根据 If-None-Match 和 If-Modified-Since 判断客户端缓存是否仍有效，有效时返回 304。
+// 需先调用 setCacheHeaders。
+func notModified(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+	header := c.Writer.Header()
+
+	//同时存在时 If-None-Match 优先，忽略 If-Modified-Since
+	if inm := c.GetHeader("If-None-Match"); len(inm) > 0 {
+		if !etagListMatch(inm, header.Get("ETag"), false) {
+			return false
+		}
+	} else if ims := c.GetHeader("If-Modified-Since"); len(ims) > 0 {
+		t, err := http.ParseTime(ims)
+		lm, err2 := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil || err2 != nil || lm.After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	//304 不带实体相关的头
+	header.Del("Content-Type")
+	header.Del("Content-Length")
+	c.AbortWithStatus(http.StatusNotModified)
+	return true
+}
+
+// ifRangeMatch If-Range 与当前内容一致（或未设置）时返回 true；不一致时应忽略 Range 返回完整内容
+func ifRangeMatch(c *gin.Context) bool {
+	ir := strings.TrimSpace(c.GetHeader("If-Range"))
+	if len(ir) == 0 {
+		return true
+	}
+	header := c.Writer.Header()
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		//If-Range 要求强比较
+		return etagListMatch(ir, header.Get("ETag"), true)
+	}
+	t, err := http.ParseTime(ir)
+	lm, err2 := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && err2 == nil && t.Equal(lm)
+}
+
+// etagListMatch 判断逗号分隔的 etag 列表中是否有与 etag 匹配的项，strong 为 true 时弱 etag 不匹配
+func etagListMatch(list string, etag string, strong bool) bool {
+	if len(etag) == 0 {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if strings.HasPrefix(item, "W/") {
+			if strong {
+				continue
+			}
+			item = item[2:]
+		}
+		if item == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCachedFile 返回本地文件，Last-Modified 使用已设置的值而不是文件自身的修改时间
+func serveCachedFile(c *gin.Context, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	modTime, _ := http.ParseTime(c.Writer.Header().Get("Last-Modified"))
+	http.ServeContent(c.Writer, c.Request, path, modTime, f)
+}
@@ -2382,6 +2382,12 @@ const docTemplate = `{
                 "createdAt": {
                     "type": "integer"
                 },
+                "ext": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
                 "fileCount": {
                     "type": "integer"
                 },
@@ -2430,6 +2436,12 @@ const docTemplate = `{
                 "createdAt": {
                     "type": "integer"
                 },
+                "ext": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
                 "fileCount": {
                     "type": "integer"
                 },
@@ -18,12 +18,18 @@ import (
 	"aofs/internal/bpctx"
 	"aofs/internal/env"
 	"aofs/internal/proto"
+	"aofs/internal/utils"
 	"aofs/repository/dbutils"
-	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/file"
+	"aofs/services/thumb"
+	"aofs/services/tiering"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 
@@ -31,49 +37,153 @@ import (
 	"gorm.io/gorm"
 )
 
-func DecodeRange(rangestr string) (*proto.Part, error) {
-	if len(rangestr) == 0 {
-		return &proto.Part{}, nil
-	}
-	rangestr = strings.TrimSpace(rangestr)
-	if idx := strings.Index(rangestr, "bytes="); idx != 0 {
-		return nil, fmt.Errorf("range err.%s", rangestr)
-	} else {
-		rangestr = rangestr[len("bytes="):]
-		ranges := strings.Split(rangestr, "-")
-		if len(ranges) != 2 {
-			return nil, fmt.Errorf("range err.%s", rangestr)
-		}
-		part := &proto.Part{}
-		if len(ranges[0]) > 0 {
-			if i, err := strconv.Atoi(ranges[0]); err != nil || i < 0 {
-				return nil, fmt.Errorf("range start err.%s", rangestr)
-			} else {
-				part.Start = int64(i)
-			}
+// @Summary File download
+// @Description File download
+// @Tags File
+// @Param   uuid     query    string     true        "uuid"
+// @Param	userId	query	string	true	"user id"
+// @Param	Range header string false  "range, such as：bytes=200-1000, bytes=-500 or bytes=0-99,200-299"
+// @Produce application/octet-stream
+// @Failure 404 {object} proto.ErrMess
+// @Failure 416 {object} proto.ErrMess "Range Not Satisfiable"
+// @Failure 500 {object} proto.ErrMess
+// @Success 200 {file}  formData "file content"
+// @Success 206 {file}  formData "Partial Content, multipart/byteranges for multiple ranges"
+// @Param	If-None-Match header string false "ETag of cached content"
+// @Param	If-Modified-Since header string false "Last-Modified of cached content"
+// @Success 304 "Not Modified"
+// @Router /space/v1/api/file/download [GET]
+func DownloadFile(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	uuid := c.Query("uuid")
+	range_ := c.GetHeader("Range")
+	ctx.LogD().Str("uuid", uuid).Str("range", range_).Msg("param")
+	fileInfo, err := dbutils.GetFileInfoWithUid(ctx.GetUserId(), uuid)
+	if err != nil {
+		ctx.LogE().Msg(err.Error())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: "File not found"})
 		}
+		return
+	}
+	setCacheHeaders(c, fileInfo.BETag, fileInfo.ModifyTime, cacheControlDownload)
+	if notModified(c) {
+		return
+	}
 
-		if len(ranges[1]) > 0 {
-			if i, err := strconv.Atoi(ranges[1]); err != nil || i < 0 {
-				return nil, fmt.Errorf("range end err.%s", rangestr)
-			} else {
-				part.End = int64(i)
-			}
+	extraHeaders := map[string]string{
+		"Content-Disposition": contentDisposition("attachment", fileInfo.Name),
+	}
+	//在次存储上的对象会被搬回主存储
+	tiering.Touch(fileInfo.BETag)
+	sendRanges(ctx, fileInfo.Size, fileInfo.Mime, extraHeaders, func(part *proto.Part) (io.ReadCloser, error) {
+		return stor.Get(env.NORMAL_BUCKET, fileInfo.BETag, part)
+	})
+}
+
+// @Summary Download files and folders as a zip archive
+// @Description Stream the selected files and folders as a zip archive. Folders are packed with everything under them. When the selection has more entries than the async task threshold, progress can be polled with the task id in the X-Task-Id header.
+// @Tags File
+// @Accept application/json
+// @Param	userId	query	string	true	"user id"
+// @Param	archiveReq	body	proto.ArchiveReq	true	"params, uuids can also be passed in query for GET"
+// @Produce application/zip
+// @Failure 400 {object} proto.ErrMess "param error"
+// @Failure 404 {object} proto.ErrMess
+// @Failure 500 {object} proto.ErrMess
+// @Success 200 {file}  formData "zip archive"
+// @Router /space/v1/api/file/archive [GET]
+// @Router /space/v1/api/file/archive [POST]
+func DownloadArchive(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.ArchiveReq
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, proto.ErrMess{Code: proto.CodeReqParamErr, Message: err.Error()})
+		return
+	}
+	ctx.LogI("downloadArchive", req)
+	//binding:"required" 不会拦截空数组
+	if len(req.Uuids) == 0 {
+		c.JSON(http.StatusBadRequest, proto.ErrMess{Code: proto.CodeReqParamErr, Message: "uuids is empty"})
+		return
+	}
+
+	entries, err := file.CollectArchiveEntries(ctx.GetUserId(), req.Uuids)
+	if err != nil {
+		ctx.LogE().Err(err).Msg("failed to collect archive entries")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: "File not found"})
 		} else {
-			part.End = -1
-		}
-		if part.End > 0 && part.Start > part.End {
-			return nil, fmt.Errorf("range end err.%s", rangestr)
+			c.JSON(http.StatusInternalServerError, proto.ErrMess{Code: proto.CodeFailedToOperateDB, Message: err.Error()})
 		}
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusBadRequest, proto.ErrMess{Code: proto.CodeReqParamErr, Message: "nothing to archive"})
+		return
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(len(entries))
+	if len(entries) > env.ASYNC_TASK_THRESHOLD {
+		taskList.Add(task)
+		c.Header("X-Task-Id", task.TaskId)
+	}
+
+	name := req.Name
+	if len(name) == 0 {
+		name = strings.TrimSuffix(entries[0].Name, "/")
+	}
+	c.Header("Content-Disposition", contentDisposition("attachment", name+".zip"))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
 
-		return part, nil
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+	if err := file.WriteArchive(c.Request.Context(), c.Writer, entries, task); err != nil {
+		//响应头已发出，只能中断连接
+		ctx.LogE().Err(err).Msg("failed to write archive")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return
 	}
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
 }
 
-// @Summary File download
-// @Description File download
+// @Summary List entries of a zip file
+// @Description List entries of a stored zip file, only the central directory is read
 // @Tags File
-// @Param   uuid     query    string     true        "uuid"
+// @Param   uuid     query    string     true        "uuid of zip file"
+// @Param	userId	query	string	true	"user id"
+// @Produce application/json
+// @Success 200 {object} proto.Rsp{results=proto.ZipEntriesRsp} ""
+// @Router /space/v1/api/file/zip/entries [GET]
+func ListZipEntries(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	uuid := c.Query("uuid")
+	fileInfo, err := dbutils.GetFileInfoWithUid(ctx.GetUserId(), uuid)
+	if err != nil {
+		ctx.SendErr(proto.CodeFileNotExist, err)
+		return
+	}
+	if file.ArchiveFormat(fileInfo.Name) != file.ArchiveFormatZip {
+		ctx.SendErr(proto.CodeUnsupportedArchive, fmt.Errorf("%v is not a zip file", fileInfo.Name))
+		return
+	}
+
+	za, err := file.OpenZip(fileInfo)
+	if err != nil {
+		ctx.SendErr(proto.CodeUnsupportedArchive, err)
+		return
+	}
+	defer za.Close()
+
+	ctx.SendOk(&proto.ZipEntriesRsp{Entries: za.Entries()})
+}
+
+// @Summary Download an entry of a zip file
+// @Description Download a single entry of a stored zip file without unpacking the whole archive
+// @Tags File
+// @Param   uuid     query    string     true        "uuid of zip file"
+// @Param   name     query    string     true        "entry name returned by /file/zip/entries"
 // @Param	userId	query	string	true	"user id"
 // @Param	Range header string false  "range, such as：bytes=200-1000"
 // @Produce application/octet-stream
@@ -82,12 +192,16 @@ func DecodeRange(rangestr string) (*proto.Part, error) {
 // @Failure 500 {object} proto.ErrMess
 // @Success 200 {file}  formData "file content"
 // @Success 206 {file}  formData "Partial Content"
-// @Router /space/v1/api/file/download [GET]
-func DownloadFile(c *gin.Context) {
+// @Param	If-None-Match header string false "ETag of cached content"
+// @Param	If-Modified-Since header string false "Last-Modified of cached content"
+// @Success 304 "Not Modified"
+// @Router /space/v1/api/file/zip/entry [GET]
+func DownloadZipEntry(c *gin.Context) {
 	ctx := bpctx.NewCtx(c)
 	uuid := c.Query("uuid")
+	name := c.Query("name")
 	range_ := c.GetHeader("Range")
-	ctx.LogD().Str("uuid", uuid).Str("range", range_).Msg("param")
+	ctx.LogD().Str("uuid", uuid).Str("name", name).Str("range", range_).Msg("param")
 	fileInfo, err := dbutils.GetFileInfoWithUid(ctx.GetUserId(), uuid)
 	if err != nil {
 		ctx.LogE().Msg(err.Error())
@@ -96,53 +210,49 @@ func DownloadFile(c *gin.Context) {
 		}
 		return
 	}
-	objectKey := fileInfo.BETag
-	var part *proto.Part
-	if len(range_) > 0 {
-		part, err = DecodeRange(range_)
-		ctx.LogD().Str("range", range_).Interface("part", part).Msg("range")
-		if err != nil {
-			c.JSON(http.StatusRequestedRangeNotSatisfiable, proto.ErrMess{Code: proto.CodeParamErr, Message: err.Error()})
-			return
-		} else if part.End >= fileInfo.Size {
-			c.JSON(http.StatusRequestedRangeNotSatisfiable, proto.ErrMess{Code: proto.CodeParamErr, Message: "RangeNotSatisfiable"})
-			return
-		}
-		if part.End == -1 {
-			part.End = fileInfo.Size - 1
-		}
-	}
 
-	r, err := stor.Get(env.NORMAL_BUCKET, objectKey, part)
+	za, err := file.OpenZip(fileInfo)
 	if err != nil {
-		c.String(http.StatusNotFound, "%s-%v", objectKey, err)
+		c.JSON(http.StatusBadRequest, proto.ErrMess{Code: proto.CodeUnsupportedArchive, Message: err.Error()})
 		return
 	}
-	defer r.Close()
-
-	extraHeaders := map[string]string{
-		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`,
-			url.QueryEscape(fileInfo.Name),
-			url.QueryEscape(fileInfo.Name)),
+	defer za.Close()
+	f, err := za.Lookup(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: err.Error()})
+		return
 	}
 
-	if part == nil {
-		c.DataFromReader(200, fileInfo.Size, fileInfo.Mime, r, extraHeaders)
+	setCacheHeaders(c, fmt.Sprintf("%s-%08x", fileInfo.BETag, f.CRC32), fileInfo.ModifyTime, cacheControlDownload)
+	if notModified(c) {
+		return
+	}
 
-	} else {
-		c.DataFromReader(206, part.Len(), fileInfo.Mime, r, extraHeaders)
+	base := path.Base(name)
+	extraHeaders := map[string]string{
+		"Content-Disposition": contentDisposition("attachment", base),
 	}
+	tiering.Touch(fileInfo.BETag)
+	sendRanges(ctx, int64(f.UncompressedSize64), utils.GetMimeTypeByFilename(base), extraHeaders, func(part *proto.Part) (io.ReadCloser, error) {
+		return za.OpenEntry(f, part)
+	})
 }
 
 // @Summary Get thumbnail
-// @Description Get thumbnail
+// @Description Get thumbnail. Without size or width the default thumbnail is returned. Each size is generated on first request and kept with the file's other previews.
 // @Tags File
 // @Param   uuid     query    string     true        "uuid"
 // @Param	userId	query	string	true	"user id"
+// @Param	size	query	string	false	"thumbnail size: small(256), medium(512), large(1024) or xlarge(2048)"
+// @Param	width	query	int	false	"display width in pixels, the smallest size not less than it is used"
 // @Failure 404 {object} proto.ErrMess ""
 // @Failure 400 {object} proto.ErrMess "param error"
 // @Failure 500 {object} proto.ErrMess
 // @Success 200 {file}  formData "file content"
+// @Param	If-None-Match header string false "ETag of cached content"
+// @Param	If-Modified-Since header string false "Last-Modified of cached content"
+// @Success 304 "Not Modified"
+// @Failure 503 {object} proto.ErrMess "too many thumbnail requests, retry later"
 // @Router /space/v1/api/file/thumb [GET]
 func GetThumb(c *gin.Context) {
 	ctx := bpctx.NewCtx(c)
@@ -156,19 +266,25 @@ func GetThumb(c *gin.Context) {
 		return
 	}
 
-	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`,
-		url.QueryEscape(fileInfo.Name+"-thumbnail.jpg"), url.QueryEscape(fileInfo.Name+"-thumbnail.jpg"),
-	))
-	c.Header("ETag", fileInfo.BETag)
-	previewStore := storage.NewPreview()
-	path, err := previewStore.GetThumbnailPath(fileInfo.BETag)
+	c.Header("Content-Disposition", contentDisposition("inline", fileInfo.Name+"-thumbnail.jpg"))
+	width, err := strconv.Atoi(c.DefaultQuery("width", "0"))
 	if err != nil {
-		ctx.LogE().Err(err).Msg("get thumb error")
-		c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: "File not found"})
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	variant, ok := thumb.ParseThumbnailSize(c.Query("size"), width)
+	if !ok {
+		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("invalid size %v", c.Query("size")))
 		return
 	}
 
-	c.File(path)
+	setCacheHeaders(c, derivedETag(fileInfo, variant), fileInfo.ModifyTime, cacheControlPreview())
+	if notModified(c) {
+		return
+	}
+	//派生图片随对象所在的盘存放，访问同样会把对象搬回主存储
+	tiering.Touch(fileInfo.BETag)
+	serveDerived(ctx, fileInfo, variant)
 }
 
 // @Summary  Get compressed graph
@@ -179,6 +295,10 @@ func GetThumb(c *gin.Context) {
 // @Failure 404 {object} proto.ErrMess ""
 // @Failure 500 {object} proto.ErrMess
 // @Success 200 {file}  formData "file content"
+// @Param	If-None-Match header string false "ETag of cached content"
+// @Param	If-Modified-Since header string false "Last-Modified of cached content"
+// @Success 304 "Not Modified"
+// @Failure 503 {object} proto.ErrMess "too many thumbnail requests, retry later"
 // @Router /space/v1/api/file/compressed [GET]
 func GetCompressed(c *gin.Context) {
 	ctx := bpctx.NewCtx(c)
@@ -191,18 +311,42 @@ func GetCompressed(c *gin.Context) {
 		}
 		return
 	}
-	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`,
-		url.QueryEscape(fileInfo.Name+"-preview.jpg"), url.QueryEscape(fileInfo.Name+"-preview.jpg"),
-	))
-	c.Header("ETag", fileInfo.BETag)
-	previewStore := storage.NewPreview()
-	path, err := previewStore.GetCompressedImgPath(fileInfo.BETag)
-	if err != nil {
-		c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: "File not found"})
+	c.Header("Content-Disposition", contentDisposition("inline", fileInfo.Name+"-preview.jpg"))
+	setCacheHeaders(c, derivedETag(fileInfo, thumb.Preview), fileInfo.ModifyTime, cacheControlPreview())
+	if notModified(c) {
 		return
 	}
-	c.File(path)
+	//派生图片随对象所在的盘存放，访问同样会把对象搬回主存储
+	tiering.Touch(fileInfo.BETag)
+	serveDerived(ctx, fileInfo, thumb.Preview)
+}
 
+// contentDisposition filename 兼容旧客户端，filename* 按 RFC 5987 百分号编码，空格编码为 %20
+func contentDisposition(typ string, name string) string {
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, typ, url.QueryEscape(name), url.PathEscape(name))
+}
+
+// derivedETag 派生图片的 ETag 带上图片类型，同一对象的不同尺寸互不匹配
+func derivedETag(fileInfo *proto.FileInfo, v thumb.Variant) string {
+	return fileInfo.BETag + "-" + v.Name
+}
+
+// serveDerived 返回缩略图或预览图，预览服务尚未生成时在本地生成
+func serveDerived(ctx *bpctx.Context, fileInfo *proto.FileInfo, v thumb.Variant) {
+	c := ctx.GetContext()
+	path, err := thumb.Ensure(c.Request.Context(), fileInfo, v)
+	if errors.Is(err, thumb.ErrBusy) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusServiceUnavailable, proto.ErrMess{Code: proto.CodeThumbBusy, Message: err.Error()})
+		return
+	} else if err != nil {
+		if !errors.Is(err, thumb.ErrUnsupported) {
+			ctx.LogW().Err(err).Msg("get thumb error")
+		}
+		c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: "File not found"})
+		return
+	}
+	serveCachedFile(c, path)
 }
 
 
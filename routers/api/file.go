@@ -18,10 +18,13 @@ import (
 	"aofs/internal/bpctx"
 	"aofs/internal/env"
 	"aofs/repository/dbutils"
+	"aofs/services/async"
 	"aofs/services/file"
+	"aofs/services/quota"
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"aofs/internal/proto"
@@ -124,6 +127,10 @@ func MoveFile(c *gin.Context) {
 // @Param pageSize query int false "page size，default:10"
 // @Param orderBy query string false "Sort. The default is reverse order"
 // @Param category query string false  "file classification, field value: document，video，picture or other; If there is no field, all are included"
+// @Param takenAfter query int false "only photos taken at or after this time, in milliseconds"
+// @Param takenBefore query int false "only photos taken before this time, in milliseconds"
+// @Param camera query string false "only photos whose camera make or model contains this"
+// @Param hasLocation query bool false "only photos with GPS location"
 // @Success 200 {object} proto.Rsp{results=proto.GetListRspData}
 // @Router /space/v1/api/file/list [get]
 func ListFiles(c *gin.Context) {
@@ -156,6 +163,23 @@ func ListFiles(c *gin.Context) {
 		req.OrderBy = "is_dir desc,operation_time DESC"
 	}
 
+	// 带照片元数据条件时单独查询
+	if req.PhotoFilter.IsSet() {
+		filter := dbutils.FileFilter{IsDir: req.IsDir, Photo: req.PhotoFilter}
+		if req.Category != "" {
+			filter.Categories = []string{req.Category}
+		} else if req.Uuid == "" {
+			filter.Path = "/"
+		} else if path, err := folderPath(userId, req.Uuid); err != nil {
+			ctx.SendErr(proto.CodeFolderNotExist, err)
+			return
+		} else {
+			filter.Path = path
+		}
+		sendFilteredFiles(ctx, &filter, req.OrderBy, req.PageInfo)
+		return
+	}
+
 	// category 参数为空则返回全部文件列表
 	// category 参数不为空则返回分类文件列表（视频，文档，图片）
 	if req.Category == "" {
@@ -342,6 +366,16 @@ func CopyFiles(c *gin.Context) {
 		}
 	}
 
+	//副本与原文件共用对象，但仍按复制的内容大小计入配额检查
+	size, err := dbutils.GetSelectionSize(userId, copyFileReq.Ids)
+	if err != nil {
+		ctx.SendErr(proto.CodeFileNotExist, err)
+		return
+	}
+	if !checkQuota(ctx, size) {
+		return
+	}
+
 	if affect, err := dbutils.FileIsExist(userId, copyFileReq.DestId, "", ""); affect == 1 {
 		if affectRow, newAndOldId, err := dbutils.CopyFile(userId, copyFileReq); err != nil {
 			if errors.Is(err, errors.New("beyond 20 layers")) {
@@ -390,6 +424,10 @@ func CopyFiles(c *gin.Context) {
 // @Param page query int false "page, default:1"
 // @Param pageSize query int false "page size，default:10"
 // @Param orderBy query string false "sort type, default value is in reverse order of change time"
+// @Param takenAfter query int false "only photos taken at or after this time, in milliseconds"
+// @Param takenBefore query int false "only photos taken before this time, in milliseconds"
+// @Param camera query string false "only photos whose camera make or model contains this"
+// @Param hasLocation query bool false "only photos with GPS location"
 // @Success 200 {object} proto.Rsp{results=proto.GetListRspData} ""
 // @Router /space/v1/api/file/search [GET]
 func SearchFiles(c *gin.Context) {
@@ -422,6 +460,22 @@ func SearchFiles(c *gin.Context) {
 			searchReq.OrderBy = "is_dir DESC"
 		}
 
+		if searchReq.PhotoFilter.IsSet() {
+			filter := dbutils.FileFilter{Name: searchReq.ObjectName, Photo: searchReq.PhotoFilter}
+			if searchReq.Category != "" {
+				filter.Categories = strings.Split(searchReq.Category, ",")
+			} else if searchReq.Uuid != "" {
+				path, err := folderPath(userId, searchReq.Uuid)
+				if err != nil {
+					ctx.SendErr(proto.CodeFolderNotExist, err)
+					return
+				}
+				filter.Path = path
+			}
+			sendFilteredFiles(ctx, &filter, searchReq.OrderBy, searchReq.PageInfo)
+			return
+		}
+
 		allMatchingFiles, err := dbutils.SearchFileByName(userId, searchReq.Uuid, searchReq.ObjectName, searchReq.Category, searchReq.OrderBy, searchReq.PageInfo.Page, searchReq.PageInfo.PageSize)
 		if err != nil {
 			ctx.SendErr(proto.CodeFileNotExist, err)
@@ -443,6 +497,34 @@ func SearchFiles(c *gin.Context) {
 
 }
 
+// folderPath 获取用户文件夹的绝对路径，不存在、不属于该用户或不是文件夹时返回错误
+func folderPath(userId proto.UserIdType, uuid string) (string, error) {
+	fi, err := dbutils.GetInfoByUuid(uuid)
+	if err != nil {
+		return "", err
+	}
+	if fi.UserId != userId || !fi.IsDir {
+		return "", fmt.Errorf("%v is not a folder of user %v", uuid, userId)
+	}
+	return fi.AbsPath(), nil
+}
+
+// sendFilteredFiles 返回按元数据筛选的列表或搜索结果
+func sendFilteredFiles(ctx *bpctx.Context, filter *dbutils.FileFilter, order string, page proto.PageInfo) {
+	fileList, total, err := dbutils.FilterFiles(ctx.GetUserId(), filter, order, page.Page, page.PageSize)
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+
+	var rspData proto.GetListRspData
+	rspData.List = fileList.ToPubLst()
+	rspData.PageInfo.PageInfo = page
+	rspData.PageInfo.FileCount = total
+	rspData.PageInfo.TotalPage = uint32((total + int64(page.PageSize) - 1) / int64(page.PageSize))
+	ctx.SendOk(&rspData)
+}
+
 // GetFileInfo
 // @Summary Query file info
 // @Description Query file info
@@ -520,7 +602,8 @@ func GetFileInfoForInner(c *gin.Context) {
 		return
 	} else {
 		rsp.FileInfoForTrends = *info
-		if !info.IsDir {
+		//加密或压缩存储的对象文件不能直接读取，不返回相对路径
+		if raw, _ := stor.IsRaw(env.NORMAL_BUCKET, rsp.BETag); !info.IsDir && raw {
 			if path, err := stor.GetRelativePath(env.NORMAL_BUCKET, rsp.BETag); err != nil {
 				ctx.SendErr(proto.CodeFailedToOperateDB, err)
 				return
@@ -577,6 +660,14 @@ func CreateVodSymlink(c *gin.Context) {
 		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("%v is folder", req.Id))
 		return
 	}
+	if raw, err := stor.IsRaw(env.NORMAL_BUCKET, info.BETag); err != nil {
+		ctx.SendErr(proto.CodeFileNotExist, err)
+		return
+	} else if !raw {
+		//加密或压缩存储的对象不能通过符号链接直接读取
+		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("%v is not stored as plain file", req.Id))
+		return
+	}
 	rpath, _ := stor.GetRelativePath(env.NORMAL_BUCKET, info.BETag)
 	oldName := filepath.Join("..", rpath)
 
@@ -628,3 +719,73 @@ func GetFileInfosForTrends(c *gin.Context) {
 		return
 	}
 }
+
+// ExtractArchive Extract archive into a folder
+// @Summary Extract zip/tar/tar.gz archive into a folder
+// @Description Extract archive into a folder asynchronously, entries with the same name are renamed
+// @Tags File
+// @Accept application/json
+// @Produce application/json
+// @Param userId query string true "user id"
+// @Param spaceLimit query int false "space limit of trial user"
+// @Param extractReq body proto.ExtractReq true "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} ""
+// @Router /space/v1/api/file/extract [POST]
+func ExtractArchive(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+
+	var req proto.ExtractReq
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("extractArchive", req)
+
+	userId := ctx.GetUserId()
+	archive, err := dbutils.GetFileInfoWithUid(userId, req.Uuid)
+	if err != nil || archive.Trashed != proto.TrashStatusNormal {
+		ctx.SendErr(proto.CodeFileNotExist, err)
+		return
+	}
+	if archive.IsDir || len(file.ArchiveFormat(archive.Name)) == 0 {
+		ctx.SendErr(proto.CodeUnsupportedArchive, fmt.Errorf("%v is not a supported archive", archive.Name))
+		return
+	}
+
+	limit, err := quota.Remaining(userId, quota.ParseSpaceLimit(c.Query("spaceLimit")))
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	} else if limit == 0 {
+		ctx.SendErr(proto.CodeNotEnoughSpace, quota.ErrNotEnoughSpace)
+		return
+	}
+
+	var target *proto.FileInfo
+	if len(req.FolderId) > 0 {
+		target, err = dbutils.GetFileInfoWithUid(userId, req.FolderId)
+		if err != nil || !target.IsDir || target.Trashed != proto.TrashStatusNormal {
+			ctx.SendErr(proto.CodeFolderNotExist, err)
+			return
+		}
+	} else {
+		//默认在压缩包所在目录下新建同名文件夹
+		name, err := dbutils.GenIncNameByPath(userId, archive.Path, file.ExtractName(archive.Name), proto.TrashStatusNormal)
+		if err != nil {
+			ctx.SendErr(proto.CodeFailedToOperateDB, err)
+			return
+		}
+		target, err = dbutils.RecursiveCreateFolder(userId, archive.Path+name+"/")
+		if err != nil {
+			ctx.SendErr(proto.CodeFailedToCreateFolder, err)
+			return
+		}
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	taskList.Add(task)
+	go file.Extract(userId, archive, target, limit, task)
+
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
@@ -94,7 +94,7 @@ func InitReadme(userId proto.UserIdType) {
 		}
 
 
-		if err := stor.Put(env.NORMAL_BUCKET, readme.BETag, bytes.NewReader(data), int64(len(data))); err == nil {
+		if err := stor.Put(env.NORMAL_BUCKET, readme.BETag, bytes.NewReader(data), int64(len(data)), &storage.PutOptions{Mime: readme.Mime}); err == nil {
 			if err := dbutils.AddFile(readme); err == nil {
 				logger.LogD().Msg("init 说明.pdf succ")
 
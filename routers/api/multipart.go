@@ -18,12 +18,10 @@ import (
 	"aofs/internal/bpctx"
 	"aofs/internal/env"
 	"aofs/internal/proto"
-	"aofs/repository/bpredis"
 	"aofs/repository/dbutils"
 	"aofs/repository/storage"
 	"encoding/hex"
 	"errors"
-	"strconv"
 
 	"aofs/services/multipart"
 	"fmt"
@@ -68,23 +66,8 @@ func CreateMultipartTask(c *gin.Context) {
 	}
 
 
-	spaceLimit := c.Query("spaceLimit")
-
-	spaceLimitInt, _ := strconv.Atoi(spaceLimit)
-	redis := bpredis.GetRedis()
-	if spaceLimitInt != 0 {
-		// 在线试用用户容量判断
-		usedStorage, err := redis.GetInt64(bpredis.UsedSpace + strconv.Itoa(int(ctx.GetUserId())))
-		if err != nil {
-			usedStorage, err = dbutils.GetUsedSpaceByUser(ctx.GetUserId())
-			if err != nil {
-				logger.LogE().Err(err).Msg("GetUsedSpaceByUser error")
-			}
-		}
-		if usedStorage >= int64(spaceLimitInt) {
-			ctx.SendErr(proto.CodeNotEnoughSpace, errors.New("not Enough Space"))
-			return
-		}
+	if !checkQuota(ctx, req.Size) {
+		return
 	}
 
 	param := req
@@ -100,24 +83,9 @@ func CreateMultipartTask(c *gin.Context) {
 		return
 	}
 
-	if len(param.FolderId) == 0 && len(param.FolderPath) == 0 {
-		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("folder param err"))
+	if err := resolveUploadFolder(ctx, &param); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
 		return
-	} else if len(param.FolderId) > 0 {
-		if fi, err := dbutils.GetFileInfoWithUid(ctx.GetUserId(), param.FolderId); err != nil || !fi.IsDir {
-			ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("folder param err"))
-			return
-		} else {
-			param.FolderPath = fi.AbsPath()
-		}
-	} else {
-		//循环创建目录
-		if fi, err := dbutils.RecursiveCreateFolder(ctx.GetUserId(), param.FolderPath); err != nil {
-			ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("failed to create folder(%s):%v", param.FolderPath, err))
-			return
-		} else {
-			param.FolderId = fi.Id
-		}
 	}
 
 	//处理秒传
@@ -321,16 +289,14 @@ func CompleteMultipartTask(c *gin.Context) {
 		ctx.SendErr(proto.CodeMultipartTaskNotFound, err)
 		return
 	}
-	redis := bpredis.GetRedis()
+	//上传期间可能有其他写入，合并前再检查一次配额
+	if !checkQuota(ctx, task.Param.Size) {
+		return
+	}
 	if err := task.Complete(); err != nil {
 		ctx.SendErr(proto.CodeMultipartTaskCompleteErr, err)
 		return
 	} else {
-		if used, err := redis.GetInt64(bpredis.UsedSpace + strconv.Itoa(int(ctx.GetUserId()))); err != nil {
-			redis.Set(bpredis.UsedSpace+strconv.Itoa(int(ctx.GetUserId())), task.Param.Size, 0)
-		} else {
-			redis.Set(bpredis.UsedSpace+strconv.Itoa(int(ctx.GetUserId())), used+task.Param.Size, 0)
-		}
 
 		rsp, err = multipart.InsertIndex(ctx, task.Param, true, task)
 		if err != nil {
@@ -342,3 +308,110 @@ func CompleteMultipartTask(c *gin.Context) {
 	multipart.Taskmgr.RemoveTask(req.UploadId)
 	ctx.SendOk(&rsp)
 }
+
+// resolveUploadFolder 根据 FolderId 或 FolderPath 补全上传的目标文件夹，FolderPath 不存在时逐级创建
+func resolveUploadFolder(ctx *bpctx.Context, param *proto.CreateMultipartTaskReq) error {
+	if len(param.FolderId) == 0 && len(param.FolderPath) == 0 {
+		return fmt.Errorf("folder param err")
+	} else if len(param.FolderId) > 0 {
+		if fi, err := dbutils.GetFileInfoWithUid(ctx.GetUserId(), param.FolderId); err != nil || !fi.IsDir {
+			return fmt.Errorf("folder param err")
+		} else {
+			param.FolderPath = fi.AbsPath()
+		}
+	} else {
+		//循环创建目录
+		if fi, err := dbutils.RecursiveCreateFolder(ctx.GetUserId(), param.FolderPath); err != nil {
+			return fmt.Errorf("failed to create folder(%s):%v", param.FolderPath, err)
+		} else {
+			param.FolderId = fi.Id
+		}
+	}
+	return nil
+}
+
+// UploadFile Upload a small file in a single request
+// @Summary Upload a small file in a single request
+// @Description The request body is the file content. BETag is computed on the server, and an existing object with the same BETag is reused.
+// @Tags File
+// @Accept application/octet-stream
+// @Produce application/json
+// @Param userId query string true "user id"
+// @Param fileName query string true "file name"
+// @Param folderId query string false "folder uuid, one of folderId and folderPath is required"
+// @Param folderPath query string false "folder path, created if not exist"
+// @Param betag query string false "betag of the file, verified if present"
+// @Param createTime query int false "create time in milliseconds"
+// @Param modifyTime query int false "modify time in milliseconds"
+// @Param spaceLimit query int false "space limit of trial user"
+// @Param file body string true "file content"
+// @Success 200 {object} proto.Rsp{results=proto.FileInfo} ""
+// @Router /space/v1/api/file/upload [POST]
+func UploadFile(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+
+	var req proto.UploadFileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("uploadFile", &req)
+
+	size := c.Request.ContentLength
+	if size <= 0 {
+		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("content length is required"))
+		return
+	} else if size > env.UPLOAD_MAX_SIZE {
+		ctx.SendErr(proto.CodeReqParamErr, fmt.Errorf("file is larger than %v, use multipart upload", env.UPLOAD_MAX_SIZE))
+		return
+	}
+
+	param := proto.CreateMultipartTaskReq{
+		FileName:   req.FileName,
+		Size:       size,
+		FolderId:   req.FolderId,
+		FolderPath: req.FolderPath,
+		BETag:      req.BETag,
+		CreateTime: req.CreateTime,
+		ModifyTime: req.ModifyTime,
+	}
+	if len(param.BETag) == 32 {
+		param.BETag = hex.EncodeToString([]byte{multipart.GetSizeFlag(param.Size)}) + param.BETag
+	}
+
+	if !checkQuota(ctx, size) {
+		return
+	}
+	if err := resolveUploadFolder(ctx, &param); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+
+	//客户端提供了 betag 且对象已存在时不需要接收内容
+	exists := false
+	if len(param.BETag) > 0 {
+		exists, _ = stor.IsExist(env.NORMAL_BUCKET, param.BETag)
+	}
+	var task *multipart.MultipartTask
+	if !exists {
+		var err error
+		if task, err = multipart.Upload(&param, c.Request.Body); err != nil {
+			if errors.Is(err, multipart.ErrBETagMismatch) {
+				ctx.SendErr(proto.CodeMultipartTaskHashErr, err)
+			} else {
+				ctx.SendErr(proto.CodeFailedToSaveFile, err)
+			}
+			return
+		}
+	}
+
+	rwmutex.Lock()
+	rsp, err := multipart.InsertIndex(ctx, param, task != nil, task)
+	rwmutex.Unlock()
+	if err != nil {
+		ctx.SendErr(proto.CodeMultipartTaskCompleteErr, err)
+		return
+	}
+
+	ctx.SendOk(&rsp)
+}
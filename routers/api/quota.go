@@ -0,0 +1,114 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"aofs/internal/bpctx"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/services/quota"
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkQuota 检查再写入 size 字节后是否超出配额，超出或查询失败时直接返回错误响应
+func checkQuota(ctx *bpctx.Context, size int64) bool {
+	spaceLimit := quota.ParseSpaceLimit(ctx.GetContext().Query("spaceLimit"))
+	if err := quota.Check(ctx.GetUserId(), size, spaceLimit); err != nil {
+		if errors.Is(err, quota.ErrNotEnoughSpace) {
+			ctx.SendErr(proto.CodeNotEnoughSpace, err)
+		} else {
+			ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		}
+		return false
+	}
+	return true
+}
+
+// GetUserQuota Get the storage quota of a user
+// @Summary Get the storage quota of a user
+// @Description Get the storage quota and used space of a user, limit 0 means unlimited
+// @Tags User
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param targetUserId query int true "target user id"
+// @Success 200 {object} proto.Rsp{results=proto.QuotaRsp} ""
+// @Router /space/v1/api/user/quota [GET]
+func GetUserQuota(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	defer ctx.LogI("getUserQuota", c.Query("targetUserId"))
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	target, err := strconv.ParseUint(c.Query("targetUserId"), 10, 64)
+	if err != nil || target == 0 {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	userId := proto.UserIdType(target)
+	limit, err := dbutils.GetUserQuota(userId)
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	sendQuota(ctx, userId, limit)
+}
+
+// SetUserQuota Set the storage quota of a user
+// @Summary Set the storage quota of a user
+// @Description Set the storage quota of a user, limit 0 means unlimited. Existing data over the new quota is kept, but no more data can be added.
+// @Tags User
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param setQuotaReq body proto.SetQuotaReq true "params"
+// @Success 200 {object} proto.Rsp{results=proto.QuotaRsp} ""
+// @Router /space/v1/api/user/quota [POST]
+func SetUserQuota(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+
+	var req proto.SetQuotaReq
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("setUserQuota", req)
+	if err := validate.Struct(req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	if err := dbutils.SetUserQuota(req.TargetUserId, req.Limit); err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	sendQuota(ctx, req.TargetUserId, req.Limit)
+}
+
+func sendQuota(ctx *bpctx.Context, userId proto.UserIdType, limit int64) {
+	used, err := quota.GetUsed(userId)
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	ctx.SendOk(&proto.QuotaRsp{UserId: userId, Limit: limit, Used: used})
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+//此文件完成 RFC 7233 范围请求的解析和响应
+
+import (
+	"aofs/internal/bpctx"
+	"aofs/internal/proto"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidRange        = errors.New("invalid range")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// 单个请求允许的最大范围数，防止大量小范围拖慢服务
+const maxRanges = 64
+
+// DecodeRanges 按 RFC 7233 解析 Range 头，返回按 size 截断后的范围（End 为闭区间）。
+// 支持 bytes=start-end、bytes=start-、bytes=-suffix 以及逗号分隔的多个范围。
+// 未设置 Range 时返回 nil；格式错误返回 ErrInvalidRange；没有可满足的范围时返回 ErrRangeNotSatisfiable。
+func DecodeRanges(rangestr string, size int64) ([]proto.Part, error) {
+	rangestr = strings.TrimSpace(rangestr)
+	if len(rangestr) == 0 {
+		return nil, nil
+	}
+	if !strings.HasPrefix(rangestr, "bytes=") {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRange, rangestr)
+	}
+
+	var parts []proto.Part
+	specs := 0
+	for _, spec := range strings.Split(rangestr[len("bytes="):], ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			//列表中的空元素应忽略
+			continue
+		}
+		if specs++; specs > maxRanges {
+			return nil, fmt.Errorf("%w: too many ranges", ErrInvalidRange)
+		}
+
+		i := strings.Index(spec, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+		}
+		first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+		var part proto.Part
+		if len(first) == 0 {
+			//bytes=-500 表示最后 500 字节
+			n, ok := parseDigits(last)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			part = proto.Part{Start: size - n, End: size - 1}
+		} else {
+			start, ok := parseDigits(first)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+			}
+			end := size - 1
+			if len(last) > 0 {
+				if end, ok = parseDigits(last); !ok || end < start {
+					return nil, fmt.Errorf("%w: %s", ErrInvalidRange, spec)
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			part = proto.Part{Start: start, End: end}
+		}
+		parts = append(parts, part)
+	}
+
+	if specs == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRange, rangestr)
+	}
+	if len(parts) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return parts, nil
+}
+
+func parseDigits(s string) (int64, bool) {
+	if len(s) == 0 {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	return i, err == nil
+}
+
+// sendRanges 根据请求的 Range 头返回整个内容（200）、单个范围（206）或 multipart/byteranges（206）。
+// open 打开 part 对应的内容，part 为 nil 时打开全部。If-Range 依赖已设置的 ETag 和 Last-Modified。
+func sendRanges(ctx *bpctx.Context, size int64, contentType string, extraHeaders map[string]string,
+	open func(part *proto.Part) (io.ReadCloser, error)) {
+	c := ctx.GetContext()
+	c.Header("Accept-Ranges", "bytes")
+
+	rangestr := c.GetHeader("Range")
+	if !ifRangeMatch(c) {
+		//内容已变化，返回完整内容
+		rangestr = ""
+	}
+	parts, err := DecodeRanges(rangestr, size)
+	if errors.Is(err, ErrInvalidRange) {
+		//RFC 7233：不支持的单位或格式错误的范围视为没有 Range 头，返回完整内容
+		ctx.LogD().Str("range", rangestr).Err(err).Msg("ignore range")
+		parts = nil
+	} else if err != nil {
+		ctx.LogD().Str("range", rangestr).Err(err).Msg("range")
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, proto.ErrMess{Code: proto.CodeParamErr, Message: err.Error()})
+		return
+	}
+
+	if len(parts) <= 1 {
+		var part *proto.Part
+		status, length := http.StatusOK, size
+		if len(parts) == 1 {
+			part = &parts[0]
+			status, length = http.StatusPartialContent, part.Len()
+			extraHeaders["Content-Range"] = contentRange(part, size)
+		}
+		r, err := open(part)
+		if err != nil {
+			ctx.LogE().Err(err).Msg("open failed")
+			c.JSON(http.StatusNotFound, proto.ErrMess{Code: proto.CodeFileNotExist, Message: err.Error()})
+			return
+		}
+		defer r.Close()
+		c.DataFromReader(status, length, contentType, r, extraHeaders)
+		return
+	}
+
+	//多个范围，先算出总长度再逐段写出
+	mw := multipart.NewWriter(c.Writer)
+	for k, v := range extraHeaders {
+		c.Header(k, v)
+	}
+	c.Header("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	c.Header("Content-Length", strconv.FormatInt(byterangesSize(parts, mw.Boundary(), contentType, size), 10))
+	c.Status(http.StatusPartialContent)
+
+	for i := range parts {
+		part := &parts[i]
+		pw, err := mw.CreatePart(partHeader(part, contentType, size))
+		if err != nil {
+			return
+		}
+		r, err := open(part)
+		if err != nil {
+			//响应头已发出，只能中断连接
+			ctx.LogE().Err(err).Msg("open failed")
+			return
+		}
+		_, err = io.Copy(pw, r)
+		r.Close()
+		if err != nil {
+			ctx.LogW().Err(err).Msg("write range failed")
+			return
+		}
+	}
+	mw.Close()
+}
+
+func contentRange(part *proto.Part, size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", part.Start, part.End, size)
+}
+
+func partHeader(part *proto.Part, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {contentRange(part, size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+// byterangesSize 计算 multipart/byteranges 响应体的长度
+func byterangesSize(parts []proto.Part, boundary string, contentType string, size int64) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	var total int64
+	for i := range parts {
+		mw.CreatePart(partHeader(&parts[i], contentType, size))
+		total += parts[i].Len()
+	}
+	mw.Close()
+	return total + int64(cw)
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -103,6 +103,11 @@ func RestoreRecycled(c *gin.Context) {
 		return
 	}
 
+	//回收站中的文件已计入已用空间，超出配额时不允许还原
+	if !checkQuota(ctx, 0) {
+		return
+	}
+
 	taskInfo, bpErr := file.RestoreFilesFromRecycledBin(ctx.GetUserId(), restoreReq.RecycledUuids, taskList)
 	if taskInfo == nil && bpErr.Code == proto.CodeOk {
 		ctx.SendOk(nil)
@@ -0,0 +1,174 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"aofs/internal/bpctx"
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/replica"
+	"errors"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListReplicaRules List replica rules
+// @Summary List replica rules
+// @Description List the replica rules of the user
+// @Tags Replica
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id"
+// @Success 200 {object} proto.Rsp{results=[]proto.ReplicaRule} ""
+// @Router /space/v1/api/replica/rules [GET]
+func ListReplicaRules(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	defer ctx.LogI("listReplicaRules", nil)
+
+	rules, err := dbutils.GetReplicaRules(ctx.GetUserId())
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	ctx.SendOk(rules)
+}
+
+// AddReplicaRule Add a replica rule
+// @Summary Add a replica rule
+// @Description Keep copies of the files in a folder (including subfolders) or of a category on different disks. Set either folderId or category. Existing files are replicated in the background.
+// @Tags Replica
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id"
+// @Param addReplicaRuleReq body proto.AddReplicaRuleReq true "params"
+// @Success 200 {object} proto.Rsp{results=proto.ReplicaRule} ""
+// @Router /space/v1/api/replica/rules [POST]
+func AddReplicaRule(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.AddReplicaRuleReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("addReplicaRule", req)
+
+	if err := validate.Struct(req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	if (len(req.FolderId) == 0) == (len(req.Category) == 0) {
+		ctx.SendErr(proto.CodeReqParamErr, errors.New("set either folderId or category"))
+		return
+	}
+	if disks := len(storage.GetStor().GetDiskIds()); req.Copies > disks {
+		ctx.SendErr(proto.CodeParamErr, fmt.Errorf("only %v disks for %v copies", disks, req.Copies))
+		return
+	}
+
+	userId := ctx.GetUserId()
+	if len(req.FolderId) > 0 {
+		folder, err := dbutils.GetFileInfoWithUid(userId, req.FolderId)
+		if err != nil || !folder.IsDir || folder.Trashed != proto.TrashStatusNormal {
+			ctx.SendErr(proto.CodeFolderNotExist, err)
+			return
+		}
+	}
+
+	rule := proto.ReplicaRule{UserId: userId, FolderId: req.FolderId, Category: req.Category, Copies: req.Copies}
+	if err := dbutils.AddReplicaRule(&rule); err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+
+	//已有文件由修复任务复制，任务在运行时留给下次定期修复
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := replica.Start(task, env.REPLICA_RATE_LIMIT); err != nil {
+		ctx.LogW().Err(err).Msg("replica repair not started")
+	} else {
+		taskList.Add(task)
+	}
+	ctx.SendOk(&rule)
+}
+
+// DeleteReplicaRule Delete a replica rule
+// @Summary Delete a replica rule
+// @Description Delete a replica rule, extra copies are removed by the next repair
+// @Tags Replica
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id"
+// @Param deleteReplicaRuleReq body proto.DeleteReplicaRuleReq true "params"
+// @Success 200 {object} proto.Rsp{results=proto.DbAffect} ""
+// @Router /space/v1/api/replica/rules/delete [POST]
+func DeleteReplicaRule(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.DeleteReplicaRuleReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("deleteReplicaRule", req)
+
+	affect, err := dbutils.DeleteReplicaRule(ctx.GetUserId(), req.Id)
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	ctx.SendOk(&proto.DbAffect{AffectRows: uint32(affect)})
+}
+
+// RepairReplicas Restore replicas
+// @Summary Restore replicas
+// @Description Add or remove copies of objects according to the replica rules in the background, e.g. after a disk is replaced
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param replicaRepairReq body proto.ReplicaRepairReq false "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/replica/repair [POST]
+func RepairReplicas(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.ReplicaRepairReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("repairReplicas", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = env.REPLICA_RATE_LIMIT
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := replica.Start(task, req.RateLimit); err != nil {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"aofs/internal/bpctx"
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/fsck"
+	"aofs/services/media"
+	"aofs/services/rebalance"
+	"aofs/services/scrub"
+	"aofs/services/tiering"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 存储管理类接口只允许管理员调用
+func checkAdmin(ctx *bpctx.Context) bool {
+	if ctx.GetUserId() != proto.AdminUserId {
+		ctx.SendErr(proto.CodeNoPermission, fmt.Errorf("user %v is not admin", ctx.GetUserId()))
+		return false
+	}
+	return true
+}
+
+// RebalanceStorage Rebalance objects between disks
+// @Summary Rebalance objects between disks
+// @Description Move objects and their previews from fuller disks to emptier disks in the background
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param rebalanceReq body proto.RebalanceReq false "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/rebalance [POST]
+func RebalanceStorage(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.RebalanceReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("rebalanceStorage", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = env.REBALANCE_RATE_LIMIT
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := rebalance.Start(task, req.RateLimit); err != nil {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
+
+// StartTiering Move cold objects to secondary storage
+// @Summary Move cold objects to secondary storage
+// @Description Move objects matching TIERING_RULES from primary disks to secondary disks in the background. Objects on secondary storage are moved back when accessed.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param tieringReq body proto.TieringReq false "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/tiering [POST]
+func StartTiering(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.TieringReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("startTiering", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = env.TIERING_RATE_LIMIT
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := tiering.Start(task, req.RateLimit); err == tiering.ErrRunning {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	} else if err != nil {
+		ctx.SendErr(proto.CodeParamErr, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
+
+// DrainDisk Decommission a disk
+// @Summary Decommission a disk
+// @Description Mark a disk as draining so it gets no new files, and move all its objects to the other disks. Set cancel to stop draining.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param drainDiskReq body proto.DrainDiskReq true "params"
+// @Success 200 {object} proto.Rsp "canceled"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/disk/drain [POST]
+func DrainDisk(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.DrainDiskReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("drainDisk", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	if req.Cancel {
+		if err := stor.SetDraining(req.DiskId, false); err != nil {
+			ctx.SendErr(proto.CodeParamErr, err)
+			return
+		}
+		ctx.SendOk(nil)
+		return
+	}
+
+	if req.RateLimit == 0 {
+		req.RateLimit = env.REBALANCE_RATE_LIMIT
+	}
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := rebalance.StartEvacuate(req.DiskId, task, req.RateLimit); err == rebalance.ErrRunning {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	} else if err != nil {
+		ctx.SendErr(proto.CodeParamErr, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
+
+// ReloadDisks Reload disk_info.json
+// @Summary Reload disk_info.json
+// @Description Reload disk mount info without restart. Disks that are gone but still hold objects are kept and marked as draining.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Success 200 {object} proto.Rsp{results=storage.ReloadResult} ""
+// @Router /space/v1/api/storage/reload [POST]
+func ReloadDisks(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	defer ctx.LogI("reloadDisks", nil)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	result, err := stor.Reload()
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOpenFile, err)
+		return
+	}
+	ctx.SendOk(result)
+}
+
+// StartScrub Verify stored objects against their betags
+// @Summary Verify stored objects against their betags
+// @Description Re-read every object in the background and recompute its betag. Missing or corrupted objects are recorded and pushed to the affected users.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param scrubReq body proto.ScrubReq false "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/scrub [POST]
+func StartScrub(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.ScrubReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("startScrub", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = env.SCRUB_RATE_LIMIT
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := scrub.Start(task, req.RateLimit); err != nil {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
+
+// ListFaults List objects that failed verification
+// @Summary List objects that failed verification
+// @Description List missing or corrupted objects found by the scrubber
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param page query int false "page index，default: 1"
+// @Param pageSize query int false "page size，default: 10"
+// @Success 200 {object} proto.Rsp{results=proto.ListFaultsRsp} ""
+// @Router /space/v1/api/storage/scrub/faults [GET]
+func ListFaults(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var pageInfo proto.PageInfo
+	var rsp proto.ListFaultsRsp
+
+	if err := c.ShouldBind(&pageInfo); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	if pageInfo.Page == 0 {
+		pageInfo.Page = 1
+	}
+	if pageInfo.PageSize == 0 {
+		pageInfo.PageSize = 10
+	}
+
+	faults, count, err := dbutils.ListBETagFaults(pageInfo.Page, pageInfo.PageSize)
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	rsp.List = faults
+	rsp.PageInfo.PageInfo = pageInfo
+	rsp.PageInfo.FileCount = count
+	rsp.PageInfo.TotalPage = uint32((count + int64(pageInfo.PageSize) - 1) / int64(pageInfo.PageSize))
+	ctx.SendOk(&rsp)
+}
+
+// StartFsck Check consistency between objects, betag index and file records
+// @Summary Check consistency between objects, betag index and file records
+// @Description Find unindexed objects, duplicate copies, missing objects, unreferenced objects, stale temp files and orphaned preview directories. Set repair to fix them, otherwise only report.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Param fsckReq body proto.FsckReq false "params"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/fsck [POST]
+func StartFsck(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	var req proto.FsckReq
+
+	if err := c.ShouldBind(&req); err != nil {
+		ctx.SendErr(proto.CodeReqParamErr, err)
+		return
+	}
+	defer ctx.LogI("startFsck", req)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := fsck.Start(task, req.Repair); err != nil {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
+
+// GetFsckReport Get the report of the last fsck
+// @Summary Get the report of the last fsck
+// @Description Get the issue counts and details found by the last finished fsck
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Success 200 {object} proto.Rsp{results=proto.FsckReport} ""
+// @Router /space/v1/api/storage/fsck/report [GET]
+func GetFsckReport(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	report := fsck.GetLastReport()
+	if report == nil {
+		ctx.SendErr(proto.CodeFsckReportNotFound, fmt.Errorf("no fsck has finished since startup"))
+		return
+	}
+	ctx.SendOk(report)
+}
+
+// GetIndexCacheStats Get hit and miss counts of the betag index cache
+// @Summary Get hit and miss counts of the betag index cache
+// @Description Get size, capacity, hits and misses of the in-memory betag index cache. Results are null when the cache is disabled.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Success 200 {object} proto.Rsp{results=storage.IndexCacheStats} ""
+// @Router /space/v1/api/storage/index/cache [GET]
+func GetIndexCacheStats(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	if !checkAdmin(ctx) {
+		return
+	}
+	ctx.SendOk(storage.GetIndexCacheStats())
+}
+
+// ListDisks Get the capacity and state of each disk
+// @Summary Get the capacity and state of each disk
+// @Description Get mount info, total/used/free space, reserved space, object count and bytes, and read-only or draining state of each disk
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Success 200 {object} proto.Rsp{results=[]storage.DiskStat} ""
+// @Router /space/v1/api/storage/disks [GET]
+func ListDisks(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	if !checkAdmin(ctx) {
+		return
+	}
+	stats, err := storage.GetStor().DiskStats()
+	if err != nil {
+		ctx.SendErr(proto.CodeFailedToOperateDB, err)
+		return
+	}
+	ctx.SendOk(stats)
+}
+
+// BackfillMedia Extract metadata of existing photos, videos and audio
+// @Summary Extract metadata of existing photos, videos and audio
+// @Description Parse photo capture time, camera, dimensions, orientation and GPS, and video/audio duration, resolution and codecs of files uploaded before metadata extraction was added. The results are stored in the file ext in the background.
+// @Tags Storage
+// @Accept application/json
+// @Produce application/json
+// @Param userId query int true "user id, must be admin"
+// @Success 201 {object} proto.Rsp{results=async.AsyncTask} "asynchronous task"
+// @Router /space/v1/api/storage/media/backfill [POST]
+func BackfillMedia(c *gin.Context) {
+	ctx := bpctx.NewCtx(c)
+	defer ctx.LogI("backfillMedia", nil)
+
+	if !checkAdmin(ctx) {
+		return
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if err := media.StartBackfill(task); err != nil {
+		ctx.SendErr(proto.CodeStorageTaskRunning, err)
+		return
+	}
+	taskList.Add(task)
+	ctx.SendRsp(task, &proto.BpErr{Code: proto.CodeCreateAsyncTaskSuccess})
+}
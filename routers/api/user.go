@@ -19,6 +19,7 @@ import (
 	"aofs/internal/proto"
 	"aofs/repository/dbutils"
 	"aofs/services/recycled"
+	"aofs/services/usage"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -49,7 +50,7 @@ func UserInit(c *gin.Context) {
 
 // UserUsedSpace Query the user space capacity
 // @Summary Query the user space capacity
-// @Description Query the user space capacity
+// @Description Query the user space capacity and quota, limit 0 means unlimited
 // @Tags User
 // @Accept application/json
 // @Produce application/json
@@ -74,13 +75,18 @@ func UserUsedSpace(c *gin.Context) {
 	}
 	if userId >= 1 && u64 >= 1 && userId <= proto.UserIdType(u64) {
 		if userId == 1 || userId == proto.UserIdType(u64) {
-			if res, err := dbutils.GetUsedSpaceByUser(proto.UserIdType(u64)); err != nil {
+			if res, err := usage.Get(proto.UserIdType(u64)); err != nil {
 				storage.UserStorage = 0
 				ctx.SendOk(&storage)
 				//ctx.SendErr(proto.CodeFailedToGetUsedStorage, err)
 				return
 			} else {
-				storage.UserStorage = res
+				storage.UserStorage = res.Physical
+				storage.LogicalStorage = res.Logical
+				if storage.Limit, err = dbutils.GetUserQuota(proto.UserIdType(u64)); err != nil {
+					ctx.SendErr(proto.CodeFailedToOperateDB, err)
+					return
+				}
 				ctx.SendOk(&storage)
 			}
 		}
@@ -117,6 +123,12 @@ func UserDelete(c *gin.Context) {
 			if err := dbutils.DeleteUser(user.User); err != nil {
 				ctx.SendErr(proto.CodeFailedToDeleteUser, err)
 			} else {
+				if err := dbutils.DeleteUserQuota(user.User); err != nil {
+					ctx.LogE().Err(err).Msg("delete user quota failed")
+				}
+				if err := dbutils.DeleteUserReplicaRules(user.User); err != nil {
+					ctx.LogE().Err(err).Msg("delete user replica rules failed")
+				}
 				go recycled.DoClearRecycledTask()
 				ctx.SendOk(nil)
 			}
@@ -44,6 +44,12 @@ func InitRoute() *gin.Engine {
 		file.POST("/move", api.MoveFile)
 		file.POST("/delete", api.TrashFiles)
 		file.GET("/download", api.DownloadFile)
+		file.POST("/upload", api.UploadFile)
+		file.GET("/archive", api.DownloadArchive)
+		file.POST("/archive", api.DownloadArchive)
+		file.POST("/extract", api.ExtractArchive)
+		file.GET("/zip/entries", api.ListZipEntries)
+		file.GET("/zip/entry", api.DownloadZipEntry)
 		file.GET("/search", api.SearchFiles)
 		file.GET("/thumb", api.GetThumb)
 		file.GET("/compressed", api.GetCompressed)
@@ -61,6 +67,8 @@ func InitRoute() *gin.Engine {
 		user.POST("/init", api.UserInit)
 		user.POST("/delete", api.UserDelete)
 		user.GET("/storage", api.UserUsedSpace)
+		user.GET("/quota", api.GetUserQuota)
+		user.POST("/quota", api.SetUserQuota)
 	}
 	//同步接口
 	sync := route.Group("/space/v1/api/sync")
@@ -88,6 +96,14 @@ func InitRoute() *gin.Engine {
 		multipart.POST("/complete", api.CompleteMultipartTask)
 	}
 
+	// 副本规则接口
+	replica := route.Group("/space/v1/api/replica")
+	{
+		replica.GET("/rules", api.ListReplicaRules)
+		replica.POST("/rules", api.AddReplicaRule)
+		replica.POST("/rules/delete", api.DeleteReplicaRule)
+	}
+
 	route.GET("/space/v1/api/status", api.Status)
 
 	async := route.Group("/space/v1/api/async")
@@ -95,6 +111,23 @@ func InitRoute() *gin.Engine {
 		async.GET("/task", api.GetAsyncTaskInfo)
 	}
 
+	// 存储管理接口
+	stor := route.Group("/space/v1/api/storage")
+	{
+		stor.GET("/disks", api.ListDisks)
+		stor.POST("/rebalance", api.RebalanceStorage)
+		stor.POST("/disk/drain", api.DrainDisk)
+		stor.POST("/tiering", api.StartTiering)
+		stor.POST("/replica/repair", api.RepairReplicas)
+		stor.POST("/reload", api.ReloadDisks)
+		stor.POST("/scrub", api.StartScrub)
+		stor.GET("/scrub/faults", api.ListFaults)
+		stor.POST("/fsck", api.StartFsck)
+		stor.GET("/fsck/report", api.GetFsckReport)
+		stor.GET("/index/cache", api.GetIndexCacheStats)
+		stor.POST("/media/backfill", api.BackfillMedia)
+	}
+
 	if gin.Mode() == gin.DebugMode {
 		route.GET("swagger/*any", gs.WrapHandler(swaggerFiles.Handler))
 	}
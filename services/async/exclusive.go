@@ -0,0 +1,38 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package async
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var ErrJobRunning = errors.New("storage job is running")
+
+// Exclusive 互斥的后台任务，同一时间只允许运行一个
+type Exclusive struct {
+	running int32
+}
+
+func (e *Exclusive) TryLock() bool {
+	return atomic.CompareAndSwapInt32(&e.running, 0, 1)
+}
+
+func (e *Exclusive) Unlock() {
+	atomic.StoreInt32(&e.running, 0)
+}
+
+// StorageJobs 会搬迁或删除对象的存储任务（均衡、下线、fsck）共用，避免互相干扰
+var StorageJobs Exclusive
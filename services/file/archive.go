@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+//此文件完成多个文件和文件夹的打包下载，边读边写，不落临时文件
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/tiering"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveEntry 压缩包中的一项，文件夹的 Name 以 / 结尾
+type ArchiveEntry struct {
+	Name string
+	Info *proto.FileInfo
+}
+
+// CollectArchiveEntries 将选中的文件和文件夹展开为压缩包条目，文件夹包含其下所有内容
+func CollectArchiveEntries(userId proto.UserIdType, uuids []string) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	names := map[string]bool{}
+
+	for _, uuid := range uuids {
+		fi, err := dbutils.GetFileInfoWithUid(userId, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Trashed != proto.TrashStatusNormal {
+			return nil, fmt.Errorf("%v is in recycle bin", uuid)
+		}
+
+		//不同文件夹下可能选中同名文件，在压缩包顶层重命名
+		name := uniqueName(names, fi.Name)
+		if !fi.IsDir {
+			entries = append(entries, ArchiveEntry{Name: name, Info: fi})
+			continue
+		}
+
+		entries = append(entries, ArchiveEntry{Name: name + "/", Info: fi})
+		subs, err := dbutils.GetFileInfosInFolder(userId, fi.AbsPath())
+		if err != nil {
+			return nil, err
+		}
+		for i := range subs {
+			sub := &subs[i]
+			entry := ArchiveEntry{Name: name + "/" + strings.TrimPrefix(sub.Path, fi.AbsPath()) + sub.Name, Info: sub}
+			if sub.IsDir {
+				entry.Name += "/"
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func uniqueName(names map[string]bool, name string) string {
+	unique := name
+	ext := path.Ext(name)
+	for i := 1; names[unique]; i++ {
+		unique = fmt.Sprintf("%v(%v)%v", strings.TrimSuffix(name, ext), i, ext)
+	}
+	names[unique] = true
+	return unique
+}
+
+// WriteArchive 以 zip 格式依次写出各条目，ctx 取消时中止。
+// 超过 4GB 的文件或超过 65535 个条目时自动使用 ZIP64。
+func WriteArchive(ctx context.Context, w io.Writer, entries []ArchiveEntry, task *async.AsyncTask) error {
+	stor := storage.GetStor()
+	zw := zip.NewWriter(w)
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{
+			Name:     e.Name,
+			Modified: time.Unix(0, e.Info.ModifyTime*int64(time.Millisecond)),
+			Method:   zip.Store,
+		}
+		if e.Info.IsDir {
+			header.SetMode(os.ModeDir | 0755)
+		} else {
+			header.SetMode(0644)
+			//图片、视频等已压缩过的内容直接存储，节省 CPU
+			if storage.IsCompressibleMime(e.Info.Mime) {
+				header.Method = zip.Deflate
+			}
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if !e.Info.IsDir {
+			if err := copyObject(ctx, stor, fw, e.Info); err != nil {
+				return fmt.Errorf("%v: %v", e.Name, err)
+			}
+		}
+		task.Processed++
+	}
+	return zw.Close()
+}
+
+func copyObject(ctx context.Context, stor storage.MultiDiskStorager, w io.Writer, fi *proto.FileInfo) error {
+	if fi.Size == 0 && len(fi.BETag) == 0 {
+		return nil
+	}
+	rc, err := stor.Get(env.NORMAL_BUCKET, fi.BETag, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tiering.Touch(fi.BETag)
+
+	_, err = io.Copy(w, &ctxReader{ctx: ctx, r: rc})
+	return err
+}
+
+// ctxReader 每次读取前检查 ctx，客户端断开后尽快停止读盘
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
@@ -0,0 +1,363 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+//此文件完成压缩包在服务端解压到指定文件夹
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/media"
+	"aofs/services/multipart"
+	"aofs/services/quota"
+	"aofs/services/replica"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var logger = log4bp.New("", gin.Mode())
+
+const (
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTar   = "tar"
+	ArchiveFormatTarGz = "tar.gz"
+)
+
+// ArchiveFormat 根据文件名判断压缩包格式，不支持时返回空
+func ArchiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveFormatZip
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return ArchiveFormatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return ArchiveFormatTar
+	}
+	return ""
+}
+
+// ExtractName 默认解压目录名，即去掉压缩包扩展名
+func ExtractName(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// CleanEntryName 规范化压缩包内的条目名，拒绝绝对路径和包含 .. 的路径，防止 zip-slip
+func CleanEntryName(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", false
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(name) == 0 {
+		return "", false
+	}
+	return name, true
+}
+
+type extractor struct {
+	userId   proto.UserIdType
+	stor     storage.MultiDiskStorager
+	rootPath string //目标文件夹的绝对路径，以 / 结尾
+	limit    int64  //可写入的字节数，小于 0 表示不限制
+	written  int64
+	tmpDir   string
+	folders  map[string]*proto.FileInfo
+	task     *async.AsyncTask
+	skipped  int
+}
+
+// Extract 将压缩包解压到 target 文件夹下，limit 为剩余可用空间（小于 0 表示不限制）。
+// 同名文件按 GenIncNameByPath 规则改名，符号链接等特殊条目和越界路径会被跳过。
+func Extract(userId proto.UserIdType, archive *proto.FileInfo, target *proto.FileInfo, limit int64, task *async.AsyncTask) error {
+	e := &extractor{
+		userId:   userId,
+		stor:     storage.GetStor(),
+		rootPath: target.AbsPath(),
+		limit:    limit,
+		tmpDir:   filepath.Join(env.DATA_PATH, "extract-tmp"),
+		folders:  map[string]*proto.FileInfo{"": target},
+		task:     task,
+	}
+	if !strings.HasSuffix(e.rootPath, "/") {
+		e.rootPath += "/"
+	}
+	if err := os.MkdirAll(e.tmpDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+	var err error
+	switch ArchiveFormat(archive.Name) {
+	case ArchiveFormatZip:
+		err = e.extractZip(archive)
+	case ArchiveFormatTar, ArchiveFormatTarGz:
+		err = e.extractTar(archive)
+	default:
+		err = fmt.Errorf("unsupported archive %v", archive.Name)
+	}
+
+	if err != nil {
+		logger.LogE().Err(err).Str("archive", archive.Id).Msg("extract failed")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return err
+	}
+	if e.skipped > 0 {
+		logger.LogW().Str("archive", archive.Id).Int("skipped", e.skipped).Msg("extract skipped entries")
+	}
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+	go PushChanges("file_extract", userId, []string{target.Id})
+	return nil
+}
+
+func (e *extractor) extractZip(archive *proto.FileInfo) error {
+	ra := storage.NewObjectReaderAt(e.stor, env.NORMAL_BUCKET, archive.BETag, archive.Size)
+	defer ra.Close()
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+	}
+	if e.limit >= 0 && total > uint64(e.limit) {
+		return quota.ErrNotEnoughSpace
+	}
+	e.task.Total = len(zr.File)
+
+	for _, f := range zr.File {
+		name := zipEntryName(f)
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.addFolder(name)
+		case mode.IsRegular():
+			err = e.addZipFile(name, f)
+		default:
+			e.skipped++
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		e.task.Processed++
+	}
+	return nil
+}
+
+func (e *extractor) addZipFile(name string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return e.addFile(name, rc, f.Modified)
+}
+
+func (e *extractor) extractTar(archive *proto.FileInfo) error {
+	rc, err := e.stor.Get(env.NORMAL_BUCKET, archive.BETag, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if ArchiveFormat(archive.Name) == ArchiveFormatTarGz {
+		gr, err := gzip.NewReader(rc)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	//tar 无法预知条目数，边解压边累加
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		e.task.Total++
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.addFolder(hdr.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.addFile(hdr.Name, tr, hdr.ModTime)
+		default:
+			e.skipped++
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", hdr.Name, err)
+		}
+		e.task.Processed++
+	}
+}
+
+// folder 返回条目所在的文件夹，不存在时逐级创建
+func (e *extractor) folder(dir string) (*proto.FileInfo, error) {
+	dir = strings.TrimSuffix(dir, "/")
+	if fi, ok := e.folders[dir]; ok {
+		return fi, nil
+	}
+	fi, err := dbutils.RecursiveCreateFolder(e.userId, e.rootPath+dir+"/")
+	if err != nil {
+		return nil, err
+	}
+	e.folders[dir] = fi
+	return fi, nil
+}
+
+func (e *extractor) addFolder(name string) error {
+	name, ok := CleanEntryName(name)
+	if !ok {
+		e.skipped++
+		return nil
+	}
+	_, err := e.folder(name)
+	return err
+}
+
+func (e *extractor) addFile(name string, r io.Reader, modTime time.Time) error {
+	name, ok := CleanEntryName(name)
+	if !ok {
+		e.skipped++
+		return nil
+	}
+	dir, base := path.Split(name)
+	folder, err := e.folder(dir)
+	if err != nil {
+		return err
+	}
+
+	//先落临时文件计算 BETag，再写入存储
+	tmp, err := os.CreateTemp(e.tmpDir, "extract-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := multipart.NewBETagHasher()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), &limitReader{e: e, r: r})
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	betag := hasher.Sum()
+	mime := utils.GetMimeTypeByFilename(base)
+	if err := e.stor.Put(env.NORMAL_BUCKET, betag, tmp, size, &storage.PutOptions{Mime: mime}); err != nil {
+		return err
+	}
+
+	newName, err := dbutils.GenIncNameByPath(e.userId, folder.AbsPath(), base, proto.TrashStatusNormal)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano() / 1e6
+	info := proto.FileInfo{
+		FileInfoPub: proto.FileInfoPub{
+			Id:            uuid.New().String(),
+			ParentUuid:    folder.Id,
+			Name:          newName,
+			BETag:         betag,
+			CreateTime:    now,
+			ModifyTime:    now,
+			OperationTime: now,
+			Size:          size,
+			Category:      utils.ParseCategoryByFilename(base),
+			Mime:          mime,
+		},
+		UserId:     e.userId,
+		BucketName: env.NORMAL_BUCKET,
+	}
+	if !modTime.IsZero() {
+		info.ModifyTime = modTime.UnixNano() / 1e6
+	}
+	//解析失败的留给元数据补充任务重试
+	if info.Category == "picture" {
+		if photo, err := media.ProbePhoto(betag); err == nil {
+			info.FileInfoExt, _ = json.Marshal(proto.FileInfoExt{Photo: photo})
+			if photo.TakenTime > 0 {
+				info.CreateTime = photo.TakenTime
+			}
+		}
+	} else if media.IsMedia(mime) {
+		if m, err := media.ProbeMedia(betag, size); err == nil {
+			info.FileInfoExt, _ = json.Marshal(proto.FileInfoExt{Media: m})
+		}
+	}
+	if err := dbutils.AddFileV2(info, folder.Id); err != nil {
+		return err
+	}
+	replica.FileAdded(info)
+
+	storage.PushMsg(map[string]interface{}{"key": betag,
+		"size":        size,
+		"name":        newName,
+		"bucket":      env.NORMAL_BUCKET,
+		"contentType": mime}, "put")
+	return nil
+}
+
+// limitReader 统计解压出的字节数，超过剩余空间时中止，防止压缩炸弹
+type limitReader struct {
+	e *extractor
+	r io.Reader
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.e.written += int64(n)
+	if lr.e.limit >= 0 && lr.e.written > lr.e.limit {
+		return n, quota.ErrNotEnoughSpace
+	}
+	return n, err
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+//此文件完成 zip 压缩包的浏览和单个条目的读取，只读取中央目录和所需条目，不解压整个包
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"archive/zip"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+var ErrZipEntryNotFound = errors.New("zip entry not found")
+
+// ZipArchive 已打开的 zip 压缩包，使用完后需 Close
+type ZipArchive struct {
+	fi *proto.FileInfo
+	ra *storage.ObjectReaderAt
+	zr *zip.Reader
+}
+
+// OpenZip 打开存储中的 zip 文件，只读取文件尾部的中央目录
+func OpenZip(fi *proto.FileInfo) (*ZipArchive, error) {
+	ra := storage.NewObjectReaderAt(storage.GetStor(), env.NORMAL_BUCKET, fi.BETag, fi.Size)
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		ra.Close()
+		return nil, err
+	}
+	return &ZipArchive{fi: fi, ra: ra, zr: zr}, nil
+}
+
+func (za *ZipArchive) Close() error {
+	return za.ra.Close()
+}
+
+// Entries 列出所有条目
+func (za *ZipArchive) Entries() []proto.ZipEntry {
+	entries := make([]proto.ZipEntry, 0, len(za.zr.File))
+	for _, f := range za.zr.File {
+		entries = append(entries, proto.ZipEntry{
+			Name:           zipEntryName(f),
+			IsDir:          f.Mode().IsDir(),
+			Size:           int64(f.UncompressedSize64),
+			CompressedSize: int64(f.CompressedSize64),
+			ModifyTime:     f.Modified.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	return entries
+}
+
+// Lookup 按 Entries 返回的名称查找文件条目
+func (za *ZipArchive) Lookup(name string) (*zip.File, error) {
+	for _, f := range za.zr.File {
+		if zipEntryName(f) == name && f.Mode().IsRegular() {
+			return f, nil
+		}
+	}
+	return nil, ErrZipEntryNotFound
+}
+
+// OpenEntry 读取条目 [part.Start, part.End] 范围的内容，part 为 nil 时读取全部。
+// 未压缩的条目直接按偏移读取对象；压缩的条目需从头解压并跳过 part.Start 之前的内容。
+func (za *ZipArchive) OpenEntry(f *zip.File, part *proto.Part) (io.ReadCloser, error) {
+	if f.Method == zip.Store {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		p := &proto.Part{Start: offset, End: offset + int64(f.UncompressedSize64) - 1}
+		if part != nil {
+			p = &proto.Part{Start: offset + part.Start, End: offset + part.End}
+		}
+		if p.End < p.Start {
+			//空文件
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		return storage.GetStor().Get(env.NORMAL_BUCKET, za.fi.BETag, p)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	if part == nil {
+		return rc, nil
+	}
+	if _, err := io.CopyN(io.Discard, rc, part.Start); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &limitReadCloser{Reader: io.LimitReader(rc, part.Len()), Closer: rc}, nil
+}
+
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func zipEntryName(f *zip.File) string {
+	if f.NonUTF8 {
+		//Windows 下创建的 zip 文件名通常为 GBK 编码
+		if s, err := simplifiedchinese.GB18030.NewDecoder().String(f.Name); err == nil {
+			return s
+		}
+	}
+	return f.Name
+}
@@ -0,0 +1,377 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsck
+
+//此文件完成盘上对象文件、betag 索引和文件记录三者的一致性检查与修复
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var stor storage.MultiDiskStorager
+var logger = log4bp.New("", gin.Mode())
+
+// 超过该时间的 .tmp 和分片上传数据才认为是遗留文件
+const staleAge = 24 * time.Hour
+
+// 刚写入的对象可能还没有建立索引或文件记录，跳过这段时间内的对象
+const newObjectGrace = time.Hour
+
+const batchSize = 500
+
+const quarantineDir = "quarantine"
+
+var reportMu sync.Mutex
+var lastReport *proto.FsckReport
+
+func Init() {
+	stor = storage.GetStor()
+
+	switch env.FSCK_ON_STARTUP {
+	case "check", "repair":
+		go func() {
+			task := new(async.AsyncTask)
+			task.Init(0)
+			if err := Start(task, env.FSCK_ON_STARTUP == "repair"); err != nil {
+				logger.LogW().Err(err).Msg("skip fsck on startup")
+			}
+		}()
+	}
+}
+
+// Start 后台运行 fsck，repair 为 false 时只检查不修改
+func Start(task *async.AsyncTask, repair bool) error {
+	if !async.StorageJobs.TryLock() {
+		return async.ErrJobRunning
+	}
+
+	go func() {
+		defer async.StorageJobs.Unlock()
+		Run(task, repair)
+	}()
+	return nil
+}
+
+// GetLastReport 获取最近一次 fsck 的报告
+func GetLastReport() *proto.FsckReport {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	return lastReport
+}
+
+type checker struct {
+	repair bool
+	report *proto.FsckReport
+}
+
+func (c *checker) add(issue proto.FsckIssue) {
+	c.report.Counts[issue.Kind]++
+	if len(c.report.Issues) < proto.FsckMaxIssues {
+		c.report.Issues = append(c.report.Issues, issue)
+	}
+	logger.LogW().Interface("issue", issue).Msg("fsck")
+}
+
+func (c *checker) act(issue *proto.FsckIssue, action string, fn func() error) {
+	if !c.repair {
+		return
+	}
+	issue.Action = action
+	if err := fn(); err != nil {
+		issue.Err = err.Error()
+	}
+}
+
+func Run(task *async.AsyncTask, repair bool) *proto.FsckReport {
+	c := &checker{repair: repair, report: &proto.FsckReport{
+		Repair:    repair,
+		StartTime: time.Now().UnixNano() / 1e6,
+		Counts:    map[string]int{},
+	}}
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+
+	diskIds := stor.GetDiskIds()
+	count, err := dbutils.CountBETagInfos()
+	if err != nil {
+		logger.LogE().Err(err).Msg("failed to count betag infos")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return nil
+	}
+	task.Total = int(count) + len(diskIds)
+	logger.LogI().Bool("repair", repair).Msg("start fsck")
+
+	for _, diskId := range diskIds {
+		c.checkDisk(diskId)
+		c.checkMultipart(diskId)
+		c.checkDerive(diskId)
+		task.Processed++
+	}
+
+	if err := c.checkIndex(task); err != nil {
+		logger.LogE().Err(err).Msg("failed to check betag infos")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return nil
+	}
+
+	c.report.EndTime = time.Now().UnixNano() / 1e6
+	reportMu.Lock()
+	lastReport = c.report
+	reportMu.Unlock()
+
+	logger.LogI().Interface("counts", c.report.Counts).Msg("finish fsck")
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+	return c.report
+}
+
+func objectPath(diskId int, key string) (string, error) {
+	diskPath, err := stor.GetDiskPath(diskId)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(diskPath, env.NORMAL_BUCKET, key[:2], key[2:4], key), nil
+}
+
+func quarantine(diskId int, path string) error {
+	diskPath, err := stor.GetDiskPath(diskId)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(diskPath, quarantineDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+}
+
+// checkDisk 遍历盘上的对象文件，检查是否有对应的索引
+func (c *checker) checkDisk(diskId int) {
+	diskPath, err := stor.GetDiskPath(diskId)
+	if err != nil {
+		return
+	}
+
+	filepath.WalkDir(filepath.Join(diskPath, env.NORMAL_BUCKET), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		age := time.Since(info.ModTime())
+
+		name := d.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			if age > staleAge {
+				issue := proto.FsckIssue{Kind: proto.FsckStaleTmp, VolId: diskId, Path: path}
+				c.act(&issue, proto.FsckActionDelete, func() error { return os.Remove(path) })
+				c.add(issue)
+			}
+			return nil
+		}
+		if len(name) < 4 || age < newObjectGrace {
+			return nil
+		}
+
+		bi, err := dbutils.GetBETagInfo(name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			issue := proto.FsckIssue{Kind: proto.FsckUnindexed, BETag: name, VolId: diskId, Path: path}
+			if refs, err := dbutils.CountFilesByBETag(name); err == nil && refs > 0 {
+				//仍被文件记录引用，补回索引
+				c.act(&issue, proto.FsckActionReindex, func() error { return stor.Reindex(name, diskId) })
+			} else if err == nil {
+				c.act(&issue, proto.FsckActionQuarantine, func() error { return quarantine(diskId, path) })
+			}
+			c.add(issue)
+		} else if err == nil && int(bi.VolId) != diskId && !isReplica(name, diskId) {
+			issue := proto.FsckIssue{Kind: proto.FsckDuplicate, BETag: name, VolId: diskId, Path: path}
+			indexed, err := objectPath(int(bi.VolId), name)
+			if _, e := os.Stat(indexed); err == nil && e == nil {
+				c.act(&issue, proto.FsckActionQuarantine, func() error { return quarantine(diskId, path) })
+			} else {
+				//索引指向的副本已不存在，改为使用本盘的副本
+				c.act(&issue, proto.FsckActionReindex, func() error { return stor.Reindex(name, diskId) })
+			}
+			c.add(issue)
+		}
+		return nil
+	})
+}
+
+// checkMultipart 检查没有任务信息的分片上传数据
+func (c *checker) checkMultipart(diskId int) {
+	mpPath, err := stor.GetDiskMPPath(diskId)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(mpPath)
+	if err != nil {
+		return
+	}
+
+	metaPath := filepath.Join(env.DATA_PATH, "multipart-meta")
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".data" && ext != ".hash") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < staleAge {
+			continue
+		}
+		uploadId := strings.TrimSuffix(e.Name(), ext)
+		if _, err := os.Stat(filepath.Join(metaPath, uploadId+".mp")); err == nil {
+			continue
+		}
+
+		path := filepath.Join(mpPath, e.Name())
+		issue := proto.FsckIssue{Kind: proto.FsckStaleMP, BETag: uploadId, VolId: diskId, Path: path}
+		c.act(&issue, proto.FsckActionDelete, func() error { return os.Remove(path) })
+		c.add(issue)
+	}
+}
+
+// checkDerive 检查 DERIVE_BUCKET 下的派生文件目录，源对象已删除或已搬到其他盘时为遗留目录
+func (c *checker) checkDerive(diskId int) {
+	diskPath, err := stor.GetDiskPath(diskId)
+	if err != nil {
+		return
+	}
+
+	root := filepath.Join(diskPath, env.DERIVE_BUCKET)
+	h1s, _ := os.ReadDir(root)
+	for _, h1 := range h1s {
+		h2s, _ := os.ReadDir(filepath.Join(root, h1.Name()))
+		for _, h2 := range h2s {
+			dirs, _ := os.ReadDir(filepath.Join(root, h1.Name(), h2.Name()))
+			for _, d := range dirs {
+				info, err := d.Info()
+				if err != nil || !d.IsDir() || time.Since(info.ModTime()) < newObjectGrace {
+					continue
+				}
+
+				name := d.Name()
+				bi, err := dbutils.GetBETagInfo(name)
+				if err == nil && int(bi.VolId) == diskId {
+					continue
+				} else if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					continue
+				}
+
+				path := filepath.Join(root, h1.Name(), h2.Name(), name)
+				issue := proto.FsckIssue{Kind: proto.FsckOrphanDerive, BETag: name, VolId: diskId, Path: path}
+				c.act(&issue, proto.FsckActionDelete, func() error { return os.RemoveAll(path) })
+				c.add(issue)
+			}
+		}
+	}
+}
+
+// checkIndex 遍历 betag 索引，检查文件是否存在、是否仍被引用
+func (c *checker) checkIndex(task *async.AsyncTask) error {
+	after := ""
+	for {
+		infos, err := dbutils.GetBETagInfos(after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(infos) == 0 {
+			return nil
+		}
+
+		for _, bi := range infos {
+			after = bi.BETag
+			task.Processed++
+			if len(bi.BETag) < 4 {
+				continue
+			}
+			c.checkObject(bi)
+		}
+	}
+}
+
+func (c *checker) checkObject(bi proto.BETagInfo) {
+	refs, err := dbutils.CountFilesByBETag(bi.BETag)
+	if err != nil {
+		return
+	}
+
+	path, err := objectPath(int(bi.VolId), bi.BETag)
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if err != nil {
+		issue := proto.FsckIssue{Kind: proto.FsckMissing, BETag: bi.BETag, VolId: int(bi.VolId), Path: path}
+		if diskId, ok := findOnOtherDisk(bi); ok {
+			c.act(&issue, proto.FsckActionReindex, func() error { return stor.Reindex(bi.BETag, diskId) })
+		} else if refs == 0 {
+			c.act(&issue, proto.FsckActionDelete, func() error { return stor.Del(env.NORMAL_BUCKET, bi.BETag) })
+		} else {
+			//仍被引用且没有其他副本，修复模式下也无法处理，只标记为丢失
+			c.act(&issue, proto.FsckActionLost, func() error { return nil })
+		}
+		c.add(issue)
+		return
+	}
+
+	if refs == 0 && time.Since(time.Unix(bi.CreateTime, 0)) > newObjectGrace {
+		issue := proto.FsckIssue{Kind: proto.FsckUnreferenced, BETag: bi.BETag, VolId: int(bi.VolId), Path: path}
+		c.act(&issue, proto.FsckActionDelete, func() error { return stor.Del(env.NORMAL_BUCKET, bi.BETag) })
+		c.add(issue)
+	}
+}
+
+// isReplica 本盘上的文件是否为登记过的副本
+func isReplica(key string, diskId int) bool {
+	locations, err := stor.Locations(key)
+	if err != nil {
+		return false
+	}
+	for _, id := range locations[1:] {
+		if id == diskId {
+			return true
+		}
+	}
+	return false
+}
+
+func findOnOtherDisk(bi proto.BETagInfo) (int, bool) {
+	for _, diskId := range stor.GetDiskIds() {
+		if diskId == int(bi.VolId) {
+			continue
+		}
+		if path, err := objectPath(diskId, bi.BETag); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				return diskId, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"aofs/internal/avprobe"
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"errors"
+	"strings"
+)
+
+// IsMedia 是否按音视频解析元数据
+func IsMedia(mime string) bool {
+	return strings.HasPrefix(mime, "video/") || strings.HasPrefix(mime, "audio/")
+}
+
+// ProbeMedia 从容器头部解析音视频的时长、分辨率和编码，不能识别的格式返回空的 MediaExt
+func ProbeMedia(betag string, size int64) (*proto.MediaExt, error) {
+	ra := storage.NewObjectReaderAt(storage.GetStor(), env.NORMAL_BUCKET, betag, size)
+	defer ra.Close()
+
+	info, err := avprobe.Probe(ra, size)
+	if errors.Is(err, avprobe.ErrUnsupported) || errors.Is(err, avprobe.ErrInvalid) {
+		return &proto.MediaExt{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &proto.MediaExt{
+		Duration:   info.Duration.Milliseconds(),
+		Width:      info.Width,
+		Height:     info.Height,
+		VideoCodec: info.VideoCodec,
+		AudioCodec: info.AudioCodec,
+	}, nil
+}
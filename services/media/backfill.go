@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"aofs/repository/dbutils"
+	"aofs/services/async"
+	"errors"
+)
+
+var ErrRunning = errors.New("media backfill is running")
+
+const batchSize = 100
+
+var backfillJob async.Exclusive
+
+// StartBackfill 后台为还没有元数据的已有照片和音视频补充解析，进度通过 task 查询
+func StartBackfill(task *async.AsyncTask) error {
+	if !backfillJob.TryLock() {
+		return ErrRunning
+	}
+
+	go func() {
+		defer backfillJob.Unlock()
+		backfill(task)
+	}()
+	return nil
+}
+
+// backfillKind 一类需要补充解析的元数据
+type backfillKind struct {
+	name  string
+	list  func(after string, limit int) ([]dbutils.MediaObject, error)
+	probe func(obj dbutils.MediaObject) error
+}
+
+var backfillKinds = []backfillKind{
+	{"photo", dbutils.GetPhotosWithoutExt, func(obj dbutils.MediaObject) error {
+		photo, err := ProbePhoto(obj.BETag)
+		if err != nil {
+			return err
+		}
+		return dbutils.SetPhotoExt(obj.BETag, photo)
+	}},
+	{"media", dbutils.GetMediaWithoutExt, func(obj dbutils.MediaObject) error {
+		m, err := ProbeMedia(obj.BETag, obj.Size)
+		if err != nil {
+			return err
+		}
+		return dbutils.SetMediaExt(obj.BETag, m)
+	}},
+}
+
+func backfill(task *async.AsyncTask) {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+	logger.LogI().Msg("start media backfill")
+
+	for _, kind := range backfillKinds {
+		if err := backfillOne(task, kind); err != nil {
+			logger.LogE().Err(err).Str("kind", kind.name).Msg("failed to list files")
+			task.UpdateStatus(async.AsyncTaskStatusFailed)
+			return
+		}
+	}
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+}
+
+func backfillOne(task *async.AsyncTask, kind backfillKind) error {
+	var done, failed int
+	after := ""
+	for {
+		objs, err := kind.list(after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(objs) == 0 {
+			break
+		}
+		task.Total += len(objs)
+
+		for _, obj := range objs {
+			after = obj.BETag
+			task.Processed++
+
+			//读取失败的不写入，下次补充时重试
+			if err := kind.probe(obj); err != nil {
+				failed++
+				logger.LogW().Err(err).Str("betag", obj.BETag).Str("kind", kind.name).Msg("failed to backfill metadata")
+				continue
+			}
+			done++
+		}
+	}
+
+	logger.LogI().Str("kind", kind.name).Int("done", done).Int("failed", failed).Msg("finish media backfill")
+	return nil
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/exif"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var logger = log4bp.New("", gin.Mode())
+
+// headSize 解析元数据读取的对象头部大小，EXIF 所在的 APP1 段不超过 64KB
+const headSize = 256 << 10
+
+// ProbePhoto 从对象头部解析照片元数据，不能识别的格式返回空的 PhotoExt
+func ProbePhoto(betag string) (*proto.PhotoExt, error) {
+	rc, err := storage.GetStor().Get(env.NORMAL_BUCKET, betag, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	head, err := io.ReadAll(io.LimitReader(rc, headSize))
+	if err != nil {
+		return nil, err
+	}
+	return parsePhoto(head), nil
+}
+
+func parsePhoto(head []byte) *proto.PhotoExt {
+	photo := &proto.PhotoExt{}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		photo.Width, photo.Height = cfg.Width, cfg.Height
+	}
+
+	x, err := exif.DecodeJPEG(head)
+	if err != nil {
+		return photo
+	}
+	photo.Make, photo.Model = x.Make, x.Model
+	photo.Orientation = x.Orientation
+	if photo.Width == 0 {
+		photo.Width, photo.Height = x.Width, x.Height
+	}
+	if t, ok := x.TakenTime(time.Local); ok {
+		photo.TakenTime = t.UnixNano() / 1e6
+	}
+	if x.GPS != nil {
+		lat, lon := x.GPS.Latitude, x.GPS.Longitude
+		photo.Latitude, photo.Longitude = &lat, &lon
+		if x.GPS.HasAltitude {
+			alt := x.GPS.Altitude
+			photo.Altitude = &alt
+		}
+	}
+	//5-8 需要旋转 90 度显示
+	if photo.Orientation >= 5 {
+		photo.Width, photo.Height = photo.Height, photo.Width
+	}
+	return photo
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package media
+
+import (
+	"aofs/internal/proto"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// withExif 在 JPEG 的 SOI 之后插入只包含 Orientation 和 Make 的 APP1 段
+func withExif(data []byte, orientation uint16, maker string) []byte {
+	order := binary.BigEndian
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	binary.Write(&tiff, order, uint16(2))
+	//Make 放在 IFD 之后
+	binary.Write(&tiff, order, []uint16{0x010F, 2})
+	binary.Write(&tiff, order, uint32(len(maker)+1))
+	binary.Write(&tiff, order, uint32(8+2+12*2+4))
+	binary.Write(&tiff, order, []uint16{0x0112, 3})
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, []uint16{orientation, 0})
+	binary.Write(&tiff, order, uint32(0))
+	tiff.WriteString(maker + "\x00")
+
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(&buf, binary.BigEndian, uint16(2+6+tiff.Len()))
+	buf.WriteString("Exif\x00\x00")
+	buf.Write(tiff.Bytes())
+	buf.Write(data[2:])
+	return buf.Bytes()
+}
+
+func TestParsePhoto(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 40, 30))
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	if p := parsePhoto(pngData.Bytes()); p.Width != 40 || p.Height != 30 || p.Orientation != 0 {
+		t.Errorf("png: %+v", p)
+	}
+
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	p := parsePhoto(withExif(jpegData.Bytes(), 6, "Canon"))
+	//旋转 90 度后宽高互换
+	if p.Width != 30 || p.Height != 40 || p.Orientation != 6 || p.Make != "Canon" {
+		t.Errorf("jpeg: %+v", p)
+	}
+	if p.TakenTime != 0 || p.Latitude != nil {
+		t.Errorf("unexpected metadata: %+v", p)
+	}
+
+	if p := parsePhoto([]byte("not an image")); *p != (proto.PhotoExt{}) {
+		t.Errorf("unknown format: %+v", p)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multipart
+
+import (
+	"encoding/hex"
+	"hash"
+)
+
+// BETagHasher 流式计算 BETag，算法与 MultipartTask.Complete 一致：
+// 大小标志 + 内容 md5（不超过 HASH_PART_SIZE），或每 HASH_PART_SIZE 分片 md5 拼接后的 md5。
+type BETagHasher struct {
+	part    hash.Hash //当前分片
+	partLen int64
+	sums    []byte //已完成分片的 md5，每个 HASH_SUM_SIZE 字节
+	size    int64
+}
+
+func NewBETagHasher() *BETagHasher {
+	return &BETagHasher{part: NewHash()}
+}
+
+func (h *BETagHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		l := HASH_PART_SIZE - h.partLen
+		if int64(len(p)) < l {
+			l = int64(len(p))
+		}
+		h.part.Write(p[:l])
+		h.partLen += l
+		h.size += l
+		p = p[l:]
+
+		if h.partLen == HASH_PART_SIZE {
+			h.sums = h.part.Sum(h.sums)
+			h.part.Reset()
+			h.partLen = 0
+		}
+	}
+	return n, nil
+}
+
+func (h *BETagHasher) Size() int64 {
+	return h.size
+}
+
+// Sum 返回十六进制的 BETag
+func (h *BETagHasher) Sum() string {
+	sums := h.sums
+	if h.partLen > 0 || h.size == 0 {
+		sums = h.part.Sum(append([]byte{}, sums...))
+	}
+
+	var sum []byte
+	if h.size <= HASH_PART_SIZE {
+		sum = sums
+	} else {
+		hs := NewHash()
+		hs.Write(sums)
+		sum = hs.Sum(nil)
+	}
+	return hex.EncodeToString(append([]byte{GetSizeFlag(h.size)}, sum...))
+}
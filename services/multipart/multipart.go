@@ -21,6 +21,8 @@ import (
 	"aofs/internal/proto"
 	"aofs/repository/dbutils"
 	"aofs/repository/storage"
+	"aofs/services/media"
+	"aofs/services/replica"
 	"crypto/md5"
 
 	"encoding/hex"
@@ -145,10 +147,13 @@ func (task *MultipartTask) Complete() error {
 	}
 
 	//校验通过
-	if fpath, err := stor.MoveFile(filepath.Join(task.MPDataPath, task.UploadId+".data"), task.DiskId, env.NORMAL_BUCKET, task.UploadId); err != nil {
+	if fpath, err := stor.MoveFile(filepath.Join(task.MPDataPath, task.UploadId+".data"), task.DiskId, env.NORMAL_BUCKET, task.UploadId,
+		&storage.PutOptions{Mime: utils.GetMimeTypeByFilename(task.Param.FileName)}); err != nil {
 		return err
 	} else {
 		task.betagPath = fpath
+		//盘下线中时对象会改放到其他盘
+		task.diskPath, _ = stor.GetDiskPathByBEtag(task.UploadId)
 		if err := os.Remove(filepath.Join(task.MPDataPath, task.UploadId+".hash")); err != nil {
 			log.Println("failed to remove hash:", err)
 		}
@@ -581,12 +586,28 @@ func Init() {
 func InsertIndex(ctx *bpctx.Context, param proto.CreateMultipartTaskReq, isUploadData bool, task *MultipartTask) (proto.FileInfo, error) {
 
 	var extJson []byte
+	var ext proto.FileInfoExt
 	if utils.GetMimeTypeByFilename(param.FileName) == "text/plain" || utils.GetMimeTypeByFilename(param.FileName) == "text/html" {
-		ext := proto.FileInfoExt{
-			Charset: storage.GetCharset(param.BETag),
-		}
+		ext.Charset = storage.GetCharset(param.BETag)
 		extJson, _ = json.Marshal(ext)
 	}
+	if utils.ParseCategoryByFilename(param.FileName) == "picture" {
+		//解析失败的留给元数据补充任务重试
+		if photo, err := media.ProbePhoto(param.BETag); err == nil {
+			ext.Photo = photo
+			extJson, _ = json.Marshal(ext)
+		} else {
+			logger.LogW().Err(err).Str("betag", param.BETag).Msg("failed to probe photo")
+		}
+	}
+	if media.IsMedia(utils.GetMimeTypeByFilename(param.FileName)) {
+		if m, err := media.ProbeMedia(param.BETag, param.Size); err == nil {
+			ext.Media = m
+			extJson, _ = json.Marshal(ext)
+		} else {
+			logger.LogW().Err(err).Str("betag", param.BETag).Msg("failed to probe media")
+		}
+	}
 	//任务完成上传，创建索引
 	fileinfo := proto.FileInfo{
 		FileInfoPub: proto.FileInfoPub{
@@ -603,14 +624,17 @@ func InsertIndex(ctx *bpctx.Context, param proto.CreateMultipartTaskReq, isUploa
 			FileCount:     0,
 			Category:      utils.ParseCategoryByFilename(param.FileName),
 			Mime:          utils.GetMimeTypeByFilename(param.FileName),
+			FileInfoExt:   extJson,
 		},
 
-		UserId:      ctx.GetUserId(),
-		BucketName:  env.NORMAL_BUCKET,
-		FileInfoExt: extJson,
+		UserId:     ctx.GetUserId(),
+		BucketName: env.NORMAL_BUCKET,
 	}
 	if param.CreateTime > 0 {
 		fileinfo.CreateTime = param.CreateTime
+	} else if ext.Photo != nil && ext.Photo.TakenTime > 0 {
+		//客户端没有指定时用拍摄时间
+		fileinfo.CreateTime = ext.Photo.TakenTime
 	}
 	if param.ModifyTime > 0 {
 		fileinfo.ModifyTime = param.ModifyTime
@@ -638,14 +662,20 @@ func InsertIndex(ctx *bpctx.Context, param proto.CreateMultipartTaskReq, isUploa
 	}
 
 	err = dbutils.AddFileV2(fileinfo, fileinfo.ParentUuid)
+	if err == nil {
+		replica.FileAdded(fileinfo)
+	}
 	if err == nil && isUploadData {
 		attrs := map[string]interface{}{"key": fileinfo.BETag,
-			"betagPath":   task.betagPath,
-			"diskPath":    task.diskPath,
 			"size":        fileinfo.Size,
 			"name":        fileinfo.Name,
 			"bucket":      fileinfo.BucketName,
 			"contentType": fileinfo.Mime}
+		//加密或压缩存储的对象文件不是原始内容，不提供文件路径，订阅方需通过接口读取
+		if raw, _ := stor.IsRaw(env.NORMAL_BUCKET, fileinfo.BETag); raw {
+			attrs["betagPath"] = task.betagPath
+			attrs["diskPath"] = task.diskPath
+		}
 		storage.PushMsg(attrs, "put")
 	}
 
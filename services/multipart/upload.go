@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multipart
+
+//此文件完成小文件的单次请求上传，不需要创建分片任务
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/storage"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var ErrBETagMismatch = errors.New("betag mismatch")
+
+// Upload 接收 r 中的全部内容，边写临时文件边计算 BETag，再存入 NORMAL_BUCKET。
+// param.BETag 非空时需与实际内容一致，完成后 param.BETag 为计算出的值。
+// 对象已存在时（秒传）返回 nil 任务；否则返回的任务只用于 InsertIndex 推送 put 事件。
+func Upload(param *proto.CreateMultipartTaskReq, r io.Reader) (*MultipartTask, error) {
+	_, dir := stor.GetMultipartPath()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(dir, "upload-*.data")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := NewBETagHasher()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, param.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if size != param.Size {
+		return nil, fmt.Errorf("size mismatch, expect:%v, actual:%v", param.Size, size)
+	}
+
+	betag := hasher.Sum()
+	if len(param.BETag) > 0 && param.BETag != betag {
+		return nil, fmt.Errorf("%w, actual:%v, expect:%v", ErrBETagMismatch, betag, param.BETag)
+	}
+	param.BETag = betag
+
+	if ok, _ := stor.IsExist(env.NORMAL_BUCKET, betag); ok {
+		return nil, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := stor.Put(env.NORMAL_BUCKET, betag, tmp, size, &storage.PutOptions{Mime: utils.GetMimeTypeByFilename(param.FileName)}); err != nil {
+		return nil, err
+	}
+
+	task := &MultipartTask{UploadId: betag, Param: *param}
+	task.betagPath, _ = stor.GetFileAbsPath(env.NORMAL_BUCKET, betag)
+	task.diskPath, _ = stor.GetDiskPathByBEtag(betag)
+	return task, nil
+}
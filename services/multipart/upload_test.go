@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multipart
+
+import (
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// memStor 只实现 Upload 用到的方法
+type memStor struct {
+	storage.MultiDiskStorager
+	dir     string
+	objects map[string][]byte
+}
+
+func (m *memStor) GetMultipartPath() (int, string) {
+	return 1, m.dir
+}
+
+func (m *memStor) IsExist(bucket string, key string) (bool, error) {
+	_, ok := m.objects[key]
+	return ok, nil
+}
+
+func (m *memStor) Put(bucket string, key string, r io.Reader, size int64, opts *storage.PutOptions) error {
+	data, err := io.ReadAll(r)
+	m.objects[key] = data
+	return err
+}
+
+func (m *memStor) GetFileAbsPath(bucket string, key string) (string, error) {
+	return m.dir + "/" + key, nil
+}
+
+func (m *memStor) GetDiskPathByBEtag(key string) (string, error) {
+	return m.dir, nil
+}
+
+func TestUpload(t *testing.T) {
+	saved := stor
+	defer func() { stor = saved }()
+	ms := &memStor{dir: t.TempDir(), objects: map[string][]byte{}}
+	stor = ms
+
+	data := []byte("hello aofs")
+	param := proto.CreateMultipartTaskReq{FileName: "a.txt", Size: int64(len(data))}
+	task, err := Upload(&param, bytes.NewReader(data))
+	if err != nil || task == nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if param.BETag != expectBETag(data) || !bytes.Equal(ms.objects[param.BETag], data) {
+		t.Errorf("betag %v, stored %q", param.BETag, ms.objects[param.BETag])
+	}
+
+	// 已存在的对象不再写入
+	param2 := proto.CreateMultipartTaskReq{FileName: "b.txt", Size: int64(len(data)), BETag: param.BETag}
+	if task, err := Upload(&param2, bytes.NewReader(data)); err != nil || task != nil {
+		t.Errorf("existing object: %v %v", task, err)
+	}
+
+	param3 := proto.CreateMultipartTaskReq{FileName: "c.txt", Size: int64(len(data)), BETag: expectBETag([]byte("hello aofS"))}
+	if _, err := Upload(&param3, bytes.NewReader(data)); !errors.Is(err, ErrBETagMismatch) {
+		t.Errorf("expect betag mismatch, got %v", err)
+	}
+
+	for _, size := range []int64{int64(len(data)) - 1, int64(len(data)) + 1} {
+		param := proto.CreateMultipartTaskReq{FileName: "d.txt", Size: size}
+		if _, err := Upload(&param, bytes.NewReader(data)); err == nil {
+			t.Errorf("size %v: expect size mismatch", size)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+//此文件完成用户空间配额的检查，所有新增数据的操作写入前都应调用 Check
+
+import (
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"aofs/services/usage"
+	"errors"
+	"strconv"
+)
+
+var ErrNotEnoughSpace = errors.New("not enough space")
+
+// GetUsed 获取用户已用空间，按 betag 去重计算
+func GetUsed(userId proto.UserIdType) (int64, error) {
+	u, err := usage.Get(userId)
+	if err != nil {
+		return 0, err
+	}
+	return u.Physical, nil
+}
+
+// GetLimit 获取用户的有效配额：配额表与在线试用的 spaceLimit 取较小的非零值，0 表示不限制
+func GetLimit(userId proto.UserIdType, spaceLimit int64) (int64, error) {
+	limit, err := dbutils.GetUserQuota(userId)
+	if err != nil {
+		return 0, err
+	}
+	if spaceLimit > 0 && (limit == 0 || spaceLimit < limit) {
+		limit = spaceLimit
+	}
+	return limit, nil
+}
+
+// Remaining 返回剩余可写入的字节数，-1 表示不限制
+func Remaining(userId proto.UserIdType, spaceLimit int64) (int64, error) {
+	limit, err := GetLimit(userId, spaceLimit)
+	if err != nil || limit == 0 {
+		return -1, err
+	}
+	used, err := GetUsed(userId)
+	if err != nil {
+		return 0, err
+	} else if used < limit {
+		return limit - used, nil
+	}
+	return 0, nil
+}
+
+// Check 判断再写入 size 字节后是否超出配额，超出时返回 ErrNotEnoughSpace。
+// size 为 0 时只检查当前是否已超出配额。
+func Check(userId proto.UserIdType, size int64, spaceLimit int64) error {
+	remaining, err := Remaining(userId, spaceLimit)
+	if err != nil {
+		return err
+	}
+	if remaining < 0 || (size > 0 && size <= remaining) || (size == 0 && remaining > 0) {
+		return nil
+	}
+	return ErrNotEnoughSpace
+}
+
+// ParseSpaceLimit 解析在线试用用户请求带的 spaceLimit 参数
+func ParseSpaceLimit(spaceLimit string) int64 {
+	limit, _ := strconv.ParseInt(spaceLimit, 10, 64)
+	return limit
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalance
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/dbutils"
+	"aofs/services/async"
+)
+
+// StartEvacuate 将 diskId 标记为下线中，后台把盘上的对象和副本全部搬到其他盘。
+// 搬迁过程中取消下线会中止任务，已搬走的对象不会搬回。
+func StartEvacuate(diskId int, task *async.AsyncTask, rateLimit int64) error {
+	if !async.StorageJobs.TryLock() {
+		return ErrRunning
+	}
+
+	if err := stor.SetDraining(diskId, true); err != nil {
+		async.StorageJobs.Unlock()
+		return err
+	}
+
+	go func() {
+		defer async.StorageJobs.Unlock()
+		evacuate(diskId, task, rateLimit)
+	}()
+	return nil
+}
+
+func evacuate(diskId int, task *async.AsyncTask, rateLimit int64) {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+
+	count, err := dbutils.CountBETagByVol(diskId)
+	if err != nil {
+		logger.LogE().Err(err).Int("diskId", diskId).Msg("failed to count objects")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return
+	}
+	task.Total = int(count)
+	logger.LogI().Int("diskId", diskId).Int64("objects", count).Msg("start evacuate")
+
+	limiter := utils.NewRateLimiter(rateLimit)
+	var failed int
+	after := ""
+	for {
+		objs, err := dbutils.GetBETagObjectsByVol(diskId, after, batchSize)
+		if err != nil {
+			logger.LogE().Err(err).Int("diskId", diskId).Msg("failed to list objects")
+			task.UpdateStatus(async.AsyncTaskStatusFailed)
+			return
+		}
+		if len(objs) == 0 {
+			break
+		}
+
+		for _, obj := range objs {
+			after = obj.BETag
+			if !stor.IsDraining(diskId) {
+				logger.LogI().Int("diskId", diskId).Msg("evacuate canceled")
+				task.UpdateStatus(async.AsyncTaskStatusFailed)
+				return
+			}
+
+			//优先搬到同一存储层，该层放不下时按分配策略选择，下线中的盘不会被选中
+			dst, err := stor.AllocTierDisk(obj.Tier == proto.TierPrimary, obj.Size)
+			if err != nil {
+				dst, _, err = stor.GenPath(env.NORMAL_BUCKET, obj.BETag, obj.Size)
+			}
+			if err == nil {
+				_, err = stor.MoveObject(env.NORMAL_BUCKET, obj.BETag, dst, limiter)
+			}
+			if err == nil && stor.IsPrimary(dst) != (obj.Tier == proto.TierPrimary) {
+				err = dbutils.SetBETagTier(obj.BETag, tierOf(dst))
+			}
+			if err != nil {
+				failed++
+				logger.LogW().Err(err).Str("betag", obj.BETag).Int("diskId", diskId).Msg("failed to evacuate object")
+			}
+			task.Processed++
+		}
+	}
+
+	//主副本搬完后再搬副本，搬主副本时目标盘上的副本会与其交换角色，原主副本留在本盘成为副本
+	replicas, err := dbutils.CountReplicasByVol(diskId)
+	if err != nil {
+		logger.LogE().Err(err).Int("diskId", diskId).Msg("failed to count replicas")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return
+	}
+	task.Total = task.Processed + int(replicas)
+	after = ""
+	for {
+		betags, err := dbutils.GetReplicaBETagsByVol(diskId, after, batchSize)
+		if err != nil {
+			logger.LogE().Err(err).Int("diskId", diskId).Msg("failed to list replicas")
+			task.UpdateStatus(async.AsyncTaskStatusFailed)
+			return
+		}
+		if len(betags) == 0 {
+			break
+		}
+
+		for _, betag := range betags {
+			after = betag
+			if !stor.IsDraining(diskId) {
+				logger.LogI().Int("diskId", diskId).Msg("evacuate canceled")
+				task.UpdateStatus(async.AsyncTaskStatusFailed)
+				return
+			}
+			if _, err := stor.EvacuateReplica(env.NORMAL_BUCKET, betag, diskId, limiter); err != nil {
+				failed++
+				logger.LogW().Err(err).Str("betag", betag).Int("diskId", diskId).Msg("failed to evacuate replica")
+			}
+			task.Processed++
+		}
+	}
+
+	logger.LogI().Int("diskId", diskId).Int("failed", failed).Msg("finish evacuate")
+	if failed > 0 {
+		//仍有对象留在盘上，不能直接拔盘
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+	} else {
+		task.UpdateStatus(async.AsyncTaskStatusSuccess)
+	}
+}
+
+func tierOf(diskId int) uint8 {
+	if stor.IsPrimary(diskId) {
+		return proto.TierPrimary
+	}
+	return proto.TierSecondary
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalance
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/utils"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+var stor storage.MultiDiskStorager
+var logger = log4bp.New("", gin.Mode())
+
+var ErrRunning = async.ErrJobRunning
+
+const batchSize = 1000
+
+// 各盘使用率与目标的差距小于该比例时认为已均衡
+const tolerance = 0.02
+
+type diskLoad struct {
+	id     int
+	all    int64
+	used   int64
+	target int64
+}
+
+type move struct {
+	betag string
+	src   int
+	dst   int
+	size  int64
+}
+
+func Init() {
+	stor = storage.GetStor()
+	if env.REBALANCE_AFTER_EXPAND {
+		go rebalanceAfterExpand()
+	}
+}
+
+// rebalanceAfterExpand 扩容完成后自动均衡一次，完成后记录到 setting，避免重复执行
+func rebalanceAfterExpand() {
+	sdi, err := storage.GetSharedDiskInfo()
+	if err != nil || sdi.DiskExpandCode != 1 {
+		return
+	}
+
+	key := fmt.Sprintf("Rebalance-%v", sdi.UpdatedTime)
+	trans, err := dbutils.NewTransProducter().New()
+	if err != nil {
+		logger.LogE().Err(err).Msg("failed to get trans")
+		return
+	}
+	v, _ := trans.GetSetting(key)
+	trans.Commit()
+	if v == "ok" {
+		return
+	}
+
+	task := new(async.AsyncTask)
+	task.Init(0)
+	if !async.StorageJobs.TryLock() {
+		return
+	}
+	err = run(task, env.REBALANCE_RATE_LIMIT)
+	async.StorageJobs.Unlock()
+	if err != nil {
+		return
+	}
+
+	if trans, err := dbutils.NewTransProducter().New(); err == nil {
+		trans.SetSetting(key, "ok")
+		trans.Commit()
+	}
+}
+
+// Start 后台启动均衡任务，进度通过 task 查询
+func Start(task *async.AsyncTask, rateLimit int64) error {
+	if !async.StorageJobs.TryLock() {
+		return ErrRunning
+	}
+
+	go func() {
+		defer async.StorageJobs.Unlock()
+		run(task, rateLimit)
+	}()
+	return nil
+}
+
+func run(task *async.AsyncTask, rateLimit int64) error {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+
+	moves, err := plan()
+	if err != nil {
+		logger.LogE().Err(err).Msg("failed to plan rebalance")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return err
+	}
+	task.Total = len(moves)
+	logger.LogI().Int("objects", len(moves)).Msg("start rebalance")
+
+	limiter := utils.NewRateLimiter(rateLimit)
+	var failed int
+	for i, mv := range moves {
+		if _, err := stor.MoveObject(env.NORMAL_BUCKET, mv.betag, mv.dst, limiter); err != nil {
+			//搬迁失败的对象留在原盘，不影响其他对象
+			failed++
+			logger.LogW().Err(err).Str("betag", mv.betag).Int("src", mv.src).Int("dst", mv.dst).Msg("failed to move object")
+		}
+		task.Processed = i + 1
+	}
+
+	logger.LogI().Int("objects", len(moves)).Int("failed", failed).Msg("finish rebalance")
+	if failed > 0 {
+		//部分对象未能搬走，下次启动时重新均衡
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return fmt.Errorf("failed to move %v of %v objects", failed, len(moves))
+	}
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+	return nil
+}
+
+// plan 按容量比例计算各盘目标使用量，从超出目标的盘挑选对象搬到低于目标的盘
+func plan() ([]move, error) {
+	var loads []*diskLoad
+	var totalAll, totalUsed int64
+	for _, id := range stor.GetDiskIds() {
+		if stor.IsDraining(id) {
+			//下线中的盘由 evacuate 负责清空
+			continue
+		}
+		path, err := stor.GetDiskPath(id)
+		if err != nil {
+			return nil, err
+		}
+		du := storage.DiskUsage(path)
+		loads = append(loads, &diskLoad{id: id, all: int64(du.All), used: int64(du.Used)})
+		totalAll += int64(du.All)
+		totalUsed += int64(du.Used)
+	}
+	if len(loads) < 2 || totalAll == 0 {
+		return nil, nil
+	}
+	for _, l := range loads {
+		l.target = int64(float64(totalUsed) * float64(l.all) / float64(totalAll))
+	}
+
+	var moves []move
+	for _, src := range loads {
+		slack := int64(tolerance * float64(src.all))
+		after := ""
+		for src.used-src.target > slack {
+			objs, err := dbutils.GetBETagObjectsByVol(src.id, after, batchSize)
+			if err != nil {
+				return nil, err
+			}
+			if len(objs) == 0 {
+				break
+			}
+
+			for _, obj := range objs {
+				after = obj.BETag
+				if src.used-src.target <= slack {
+					break
+				}
+				if obj.Size <= 0 {
+					continue
+				}
+				dst := pickDst(loads, src.id, obj.Size)
+				if dst == nil {
+					continue
+				}
+				moves = append(moves, move{betag: obj.BETag, src: src.id, dst: dst.id, size: obj.Size})
+				src.used -= obj.Size
+				dst.used += obj.Size
+			}
+		}
+	}
+	return moves, nil
+}
+
+// pickDst 选择与源盘同一存储层、离目标使用量差距最大、且放得下 size 的盘。
+// 均衡不改变对象所在层，主存储和次存储之间的搬迁由 tiering 负责。
+func pickDst(loads []*diskLoad, srcId int, size int64) *diskLoad {
+	var dst *diskLoad
+	for _, l := range loads {
+		if l.id == srcId || l.target-l.used < size || l.all-l.used-size < env.RESERVED_SPACE {
+			continue
+		}
+		if stor.IsPrimary(l.id) != stor.IsPrimary(srcId) {
+			continue
+		}
+		if dst == nil || l.target-l.used > dst.target-dst.used {
+			dst = l
+		}
+	}
+	return dst
+}
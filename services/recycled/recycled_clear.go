@@ -18,11 +18,9 @@ import (
 	"aofs/internal/env"
 	"aofs/internal/log4bp"
 	"aofs/internal/proto"
-	"aofs/repository/bpredis"
 	"aofs/repository/dbutils"
 	"aofs/repository/storage"
 	"fmt"
-	"strconv"
 
 	"time"
 
@@ -82,18 +80,11 @@ func DoClearRecycledFile(file proto.FileInfo) error {
 		return err
 	} else if sharCnt <= 1 {
 		stor.Del(env.NORMAL_BUCKET, file.BETag)
-		redis := bpredis.GetRedis()
-		if used, err := redis.GetInt64(bpredis.UsedSpace + strconv.Itoa(int(file.UserId))); err != nil {
-			usedSpace, _ := dbutils.GetUsedSpaceByUser(file.UserId)
-			redis.Set(bpredis.UsedSpace+strconv.Itoa(int(file.UserId)), usedSpace, 0)
-		} else {
-			redis.Set(bpredis.UsedSpace+strconv.Itoa(int(file.UserId)), used-file.Size, 0)
-		}
 	} else {
 		logger.LogW().Msg("there is a same betag file,cancel clear real file")
 	}
 
-	//从数据库中删除记录
+	//从数据库中删除记录，已用空间随之扣减
 	if affect, err := dbutils.DeleteByUuid(file.Id); err != nil {
 		logger.LogE().Err(err).Msg(fmt.Sprintf("failed to remove file:%v,%v", file.Id, file.Name))
 		dbutils.RecycledFromPhyToException(file.Id) //放到异常队列，后续重试处理
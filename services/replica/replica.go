@@ -0,0 +1,222 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replica
+
+//此文件完成按副本规则维护对象的副本数：新文件即时复制，定期检查并修复丢失的副本
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var stor storage.MultiDiskStorager
+var logger = log4bp.New("", gin.Mode())
+
+var ErrRunning = async.ErrJobRunning
+
+var chAdded chan proto.FileInfo
+
+func Init() {
+	stor = storage.GetStor()
+
+	chAdded = make(chan proto.FileInfo, 1024)
+	go doAdded()
+
+	if env.REPLICA_INTERVAL_HOURS > 0 {
+		go timerRepair()
+	}
+}
+
+func timerRepair() {
+	for {
+		time.Sleep(time.Duration(env.REPLICA_INTERVAL_HOURS) * time.Hour)
+		task := new(async.AsyncTask)
+		task.Init(0)
+		if err := Start(task, env.REPLICA_RATE_LIMIT); err != nil {
+			logger.LogW().Err(err).Msg("skip scheduled replica repair")
+		}
+	}
+}
+
+// Start 后台按副本规则补齐或删减各对象的副本，换盘后用于恢复副本数，进度通过 task 查询
+func Start(task *async.AsyncTask, rateLimit int64) error {
+	if !async.StorageJobs.TryLock() {
+		return ErrRunning
+	}
+
+	go func() {
+		defer async.StorageJobs.Unlock()
+		run(task, utils.NewRateLimiter(rateLimit))
+	}()
+	return nil
+}
+
+func run(task *async.AsyncTask, limiter *utils.RateLimiter) {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+
+	desired, err := plan()
+	if err != nil {
+		logger.LogE().Err(err).Msg("failed to plan replicas")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return
+	}
+	task.Total = len(desired)
+	logger.LogI().Int("objects", len(desired)).Msg("start replica repair")
+
+	var added, failed int
+	for betag, copies := range desired {
+		n, err := stor.Replicate(env.NORMAL_BUCKET, betag, copies, limiter)
+		added += n
+		if err != nil {
+			failed++
+			logger.LogW().Err(err).Str("betag", betag).Int("copies", copies).Msg("failed to replicate object")
+			if errors.Is(err, storage.ErrNoReplicaIndex) {
+				task.UpdateStatus(async.AsyncTaskStatusFailed)
+				return
+			}
+		}
+		task.Processed++
+	}
+
+	logger.LogI().Int("added", added).Int("failed", failed).Msg("finish replica repair")
+	if failed > 0 {
+		//仍有对象副本数不足，由下次检查重试
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+	} else {
+		task.UpdateStatus(async.AsyncTaskStatusSuccess)
+	}
+}
+
+// plan 按规则计算每个对象应有的份数，多条规则取最大值；已有副本但不再匹配任何规则的对象降为 1 份
+func plan() (map[string]int, error) {
+	rules, err := dbutils.GetAllReplicaRules()
+	if err != nil {
+		return nil, err
+	}
+	desired := map[string]int{}
+	for i := range rules {
+		betags, err := ruleBETags(&rules[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, betag := range betags {
+			if len(betag) > 0 && desired[betag] < rules[i].Copies {
+				desired[betag] = rules[i].Copies
+			}
+		}
+	}
+
+	replicated, err := dbutils.GetReplicatedBETags()
+	if err != nil {
+		return nil, err
+	}
+	for _, betag := range replicated {
+		if _, ok := desired[betag]; !ok {
+			desired[betag] = 1
+		}
+	}
+	return desired, nil
+}
+
+// ruleFolder 获取规则对应的文件夹，文件夹已删除时返回 nil，规则不再生效
+func ruleFolder(rule *proto.ReplicaRule) *proto.FileInfo {
+	fi, err := dbutils.GetFileInfoWithUid(rule.UserId, rule.FolderId)
+	if err != nil || !fi.IsDir || fi.Trashed != proto.TrashStatusNormal {
+		return nil
+	}
+	return fi
+}
+
+func ruleBETags(rule *proto.ReplicaRule) ([]string, error) {
+	if len(rule.FolderId) == 0 {
+		return dbutils.GetBETagsByCategory(rule.UserId, rule.Category)
+	}
+	folder := ruleFolder(rule)
+	if folder == nil {
+		return nil, nil
+	}
+	return dbutils.GetBETagsInFolder(rule.UserId, folder.AbsPath())
+}
+
+// copiesOf 计算新文件按规则应有的份数
+func copiesOf(fi *proto.FileInfo) int {
+	rules, err := dbutils.GetReplicaRules(fi.UserId)
+	if err != nil {
+		logger.LogW().Err(err).Msg("failed to get replica rules")
+		return 1
+	}
+	if len(rules) == 0 {
+		return 1
+	}
+	//AddFileV2 不回填 Path，按父文件夹计算
+	path := fi.Path
+	if len(path) == 0 {
+		parent, err := dbutils.GetInfoByUuid(fi.ParentUuid)
+		if err != nil {
+			return 1
+		}
+		path = parent.AbsPath()
+	}
+
+	copies := 1
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Copies <= copies {
+			continue
+		}
+		if len(rule.FolderId) == 0 {
+			if rule.Category == fi.Category {
+				copies = rule.Copies
+			}
+		} else if folder := ruleFolder(rule); folder != nil && strings.HasPrefix(path, folder.AbsPath()) {
+			copies = rule.Copies
+		}
+	}
+	return copies
+}
+
+// FileAdded 新文件入库后调用，匹配副本规则时由后台复制，不阻塞调用方
+func FileAdded(fi proto.FileInfo) {
+	if chAdded == nil || fi.IsDir || len(fi.BETag) == 0 {
+		return
+	}
+	select {
+	case chAdded <- fi:
+	default:
+		//队列已满时丢弃，由定期修复补齐
+	}
+}
+
+func doAdded() {
+	for fi := range chAdded {
+		copies := copiesOf(&fi)
+		if copies <= 1 {
+			continue
+		}
+		if _, err := stor.Replicate(env.NORMAL_BUCKET, fi.BETag, copies, nil); err != nil {
+			logger.LogW().Err(err).Str("betag", fi.BETag).Int("copies", copies).Msg("failed to replicate new file")
+		}
+	}
+}
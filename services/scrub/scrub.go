@@ -0,0 +1,247 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scrub
+
+//此文件完成对象完整性校验：按 betag 索引逐个重新计算 betag，记录损坏或丢失的对象
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/bpredis"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"aofs/services/multipart"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var stor storage.MultiDiskStorager
+var logger = log4bp.New("", gin.Mode())
+
+var running int32
+
+var ErrRunning = errors.New("scrub is running")
+
+const batchSize = 500
+
+func Init() {
+	stor = storage.GetStor()
+	if env.SCRUB_INTERVAL_HOURS > 0 {
+		go timerScrub()
+	}
+}
+
+func timerScrub() {
+	for {
+		time.Sleep(time.Duration(env.SCRUB_INTERVAL_HOURS) * time.Hour)
+		task := new(async.AsyncTask)
+		task.Init(0)
+		if err := Start(task, env.SCRUB_RATE_LIMIT); err != nil {
+			logger.LogW().Err(err).Msg("skip scheduled scrub")
+		}
+	}
+}
+
+// Start 后台启动一次全量校验，进度通过 task 查询
+func Start(task *async.AsyncTask, rateLimit int64) error {
+	if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+		return ErrRunning
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&running, 0)
+		run(task, utils.NewRateLimiter(rateLimit))
+	}()
+	return nil
+}
+
+func run(task *async.AsyncTask, limiter *utils.RateLimiter) {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+
+	count, err := dbutils.CountBETagInfos()
+	if err != nil {
+		logger.LogE().Err(err).Msg("failed to count betag infos")
+		task.UpdateStatus(async.AsyncTaskStatusFailed)
+		return
+	}
+	task.Total = int(count)
+	logger.LogI().Int64("objects", count).Msg("start scrub")
+
+	var faults int
+	after := ""
+	for {
+		infos, err := dbutils.GetBETagInfos(after, batchSize)
+		if err != nil {
+			logger.LogE().Err(err).Msg("failed to list betag infos")
+			task.UpdateStatus(async.AsyncTaskStatusFailed)
+			return
+		}
+		if len(infos) == 0 {
+			break
+		}
+
+		for _, bi := range infos {
+			after = bi.BETag
+			if fault := check(bi, limiter); fault != nil {
+				faults++
+				record(*fault)
+			} else if n, _ := dbutils.DeleteBETagFault(bi.BETag); n > 0 {
+				logger.LogI().Str("betag", bi.BETag).Msg("object recovered")
+			}
+			task.Processed++
+		}
+	}
+
+	if n, err := dbutils.DeleteOrphanFaults(); err != nil {
+		logger.LogW().Err(err).Msg("failed to delete orphan faults")
+	} else if n > 0 {
+		logger.LogI().Int64("count", n).Msg("delete faults of removed objects")
+	}
+
+	logger.LogI().Int64("objects", count).Int("faults", faults).Msg("finish scrub")
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+}
+
+// check 逐份校验对象的主副本和其他副本，返回第一份失败的记录。校验不与搬迁、分层等存储任务互斥，
+// 某份失败时按最新的索引确认它未被搬走或删除，搬走时按新位置校验剩下的副本
+func check(bi proto.BETagInfo, limiter *utils.RateLimiter) *proto.BETagFault {
+	checked := make(map[int]bool)
+	for retry := 0; retry < 2; retry++ {
+		locations, err := stor.Locations(bi.BETag)
+		if err != nil {
+			return nil //校验期间对象已被删除
+		}
+		moved := false
+		for _, diskId := range locations {
+			if checked[diskId] {
+				continue
+			}
+			checked[diskId] = true
+			fault := verify(bi, diskId, limiter)
+			if fault == nil {
+				continue
+			}
+			current, err := stor.Locations(bi.BETag)
+			if err != nil {
+				return nil
+			}
+			for _, id := range current {
+				if id == diskId {
+					return fault
+				}
+			}
+			logger.LogI().Str("betag", bi.BETag).Int("diskId", diskId).Msg("copy moved during scrub, verify again")
+			moved = true
+			break
+		}
+		if !moved {
+			return nil
+		}
+	}
+	return nil
+}
+
+// verify 读取 diskId 盘上的那份对象重新计算 betag，一致时返回 nil
+func verify(bi proto.BETagInfo, diskId int, limiter *utils.RateLimiter) *proto.BETagFault {
+	fault := &proto.BETagFault{BETag: bi.BETag, VolId: uint16(diskId), DetectTime: time.Now().UnixNano() / 1e6}
+
+	rc, err := stor.GetAt(env.NORMAL_BUCKET, bi.BETag, diskId)
+	if err != nil {
+		fault.Message = err.Error()
+		if os.IsNotExist(err) {
+			fault.Reason = proto.FaultMissing
+		} else {
+			fault.Reason = proto.FaultUnreadable
+		}
+		return fault
+	}
+	defer rc.Close()
+
+	var actual string
+	if len(bi.BETag) == 32 {
+		//早期的 betag 只有内容 md5，没有大小标志
+		h := md5.New()
+		_, err = io.Copy(h, utils.NewLimitedReader(rc, limiter))
+		actual = hex.EncodeToString(h.Sum(nil))
+	} else {
+		h := multipart.NewBETagHasher()
+		_, err = io.Copy(h, utils.NewLimitedReader(rc, limiter))
+		actual = h.Sum()
+	}
+	if err != nil {
+		fault.Reason = proto.FaultUnreadable
+		fault.Message = err.Error()
+		return fault
+	}
+
+	if actual != bi.BETag {
+		fault.Reason = proto.FaultMismatch
+		fault.Actual = actual
+		return fault
+	}
+	return nil
+}
+
+// record 保存校验失败记录，首次发现时通知受影响的用户
+func record(fault proto.BETagFault) {
+	logger.LogW().Interface("fault", fault).Msg("object fault detected")
+
+	old, _ := dbutils.GetBETagFault(fault.BETag)
+	if err := dbutils.SaveBETagFault(fault); err != nil {
+		logger.LogE().Err(err).Str("betag", fault.BETag).Msg("failed to save fault")
+		return
+	}
+	if old == nil || old.Reason != fault.Reason {
+		notify(fault)
+	}
+}
+
+func notify(fault proto.BETagFault) {
+	files, err := dbutils.GetFilesByBETag(fault.BETag)
+	if err != nil {
+		logger.LogE().Err(err).Str("betag", fault.BETag).Msg("failed to get files by betag")
+		return
+	}
+
+	userFiles := make(map[proto.UserIdType][]string)
+	for _, f := range files {
+		userFiles[f.UserId] = append(userFiles[f.UserId], f.Id)
+	}
+
+	for userId, uuids := range userFiles {
+		dataBytes, _ := json.Marshal(proto.StorageFaultPushMsg{BETag: fault.BETag, Reason: fault.Reason, Uuids: uuids})
+		bpredis.GetRedis().PushNotificationMsg(map[string]interface{}{
+			"userId":    int(userId),
+			"optType":   "STORAGE_FAULT",
+			"requestId": uuid.New().String(),
+			"data":      base64.StdEncoding.EncodeToString(dataBytes),
+		})
+		logger.LogI().Msg(fmt.Sprintf("push fault of %v to user %v", fault.BETag, userId))
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thumb
+
+//此文件完成预览服务尚未生成缩略图时的本地生成，支持 JPEG、PNG、GIF、WebP，按 EXIF 方向旋转。
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/exif"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	_ "golang.org/x/image/webp"
+)
+
+var logger = log4bp.New("", gin.Mode())
+
+var (
+	ErrUnsupported = errors.New("unsupported image")
+	ErrTooLarge    = errors.New("image too large")
+	ErrBusy        = errors.New("too many thumbnail requests")
+)
+
+// 读取文件头部用于解析尺寸和 EXIF，EXIF 所在的 APP1 段最大 64KB
+const headSize = 256 * 1024
+
+// Variant 一种派生图片，缩小到 MaxSide×MaxSide 以内
+type Variant struct {
+	Name    string //区分同一对象的不同派生图片，用于 ETag 等
+	MaxSide int
+	Quality int
+	sized   bool //指定尺寸的缩略图，原图不支持本地解码时可由预览服务生成的图片缩放得到
+	path    func(s *storage.PreviewStore, key string) (string, error)
+}
+
+var (
+	Thumbnail = Variant{Name: "thumb", MaxSide: 400, Quality: 80, path: (*storage.PreviewStore).GetThumbnailPath}
+	Preview   = Variant{Name: "preview", MaxSide: 1920, Quality: 85, path: (*storage.PreviewStore).GetCompressedImgPath}
+)
+
+// ThumbnailSizes 允许的缩略图尺寸，适用于手机网格、平板和电视投屏等场景
+var ThumbnailSizes = map[string]int{
+	"small":  256,
+	"medium": 512,
+	"large":  1024,
+	"xlarge": 2048,
+}
+
+// ThumbnailSize 指定尺寸的缩略图，size 需为 ThumbnailSizes 中的值
+func ThumbnailSize(size int) Variant {
+	return Variant{Name: fmt.Sprintf("thumb-%d", size), MaxSide: size, Quality: 80, sized: true, path: func(s *storage.PreviewStore, key string) (string, error) {
+		return s.GetThumbnailSizePath(key, size)
+	}}
+}
+
+// ParseThumbnailSize 按尺寸名或宽度选择缩略图，宽度取不小于它的最小尺寸，超过最大尺寸时取最大尺寸。
+// 都不指定时返回默认缩略图，尺寸名无效时返回 false。
+func ParseThumbnailSize(name string, width int) (Variant, bool) {
+	if len(name) > 0 {
+		size, ok := ThumbnailSizes[name]
+		if !ok {
+			return Variant{}, false
+		}
+		return ThumbnailSize(size), true
+	}
+	if width <= 0 {
+		return Thumbnail, true
+	}
+
+	sizes := make([]int, 0, len(ThumbnailSizes))
+	for _, size := range ThumbnailSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		if size >= width {
+			return ThumbnailSize(size), true
+		}
+	}
+	return ThumbnailSize(sizes[len(sizes)-1]), true
+}
+
+// 一次解码同时生成缺少的所有默认派生图片
+var defaultVariants = []Variant{Thumbnail, Preview}
+
+var sem chan struct{}
+var waiting int32
+
+// 获取派生图片存储，测试时可替换
+var newPreview = storage.NewPreview
+
+func Init() {
+	if env.THUMB_CONCURRENCY > 0 {
+		sem = make(chan struct{}, env.THUMB_CONCURRENCY)
+	}
+}
+
+// Supported 是否支持本地生成
+func Supported(mime string) bool {
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Ensure 返回派生图片路径，文件不存在时在本地生成。
+// ctx 取消时放弃排队，已开始的生成会继续完成，供后续请求使用。
+func Ensure(ctx context.Context, fi *proto.FileInfo, v Variant) (string, error) {
+	store := newPreview()
+	path, err := v.path(store, fi.BETag)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if sem == nil || (!Supported(fi.Mime) && !v.sized) {
+		return "", ErrUnsupported
+	}
+
+	//按派生图片区分，等待其他尺寸的生成不能保证本尺寸已生成
+	err = flight(ctx, fi.BETag+"-"+v.Name, func() error {
+		return generate(ctx, store, fi, v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+var flightMu sync.Mutex
+var flights = map[string]*call{}
+
+// flight 同一派生图片同时只生成一次，其他请求等待其结果。
+// 发起生成的请求在排队时取消，等待的请求重新发起。
+func flight(ctx context.Context, key string, fn func() error) error {
+	flightMu.Lock()
+	for {
+		c, ok := flights[key]
+		if !ok {
+			break
+		}
+		flightMu.Unlock()
+		select {
+		case <-c.done:
+			if !errors.Is(c.err, context.Canceled) && !errors.Is(c.err, context.DeadlineExceeded) {
+				return c.err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		flightMu.Lock()
+	}
+	c := &call{done: make(chan struct{})}
+	flights[key] = c
+	flightMu.Unlock()
+
+	c.err = fn()
+
+	flightMu.Lock()
+	delete(flights, key)
+	flightMu.Unlock()
+	close(c.done)
+	return c.err
+}
+
+// acquire 占用一个生成名额，排队的请求过多时返回 ErrBusy
+func acquire(ctx context.Context) error {
+	if atomic.AddInt32(&waiting, 1) > int32(env.THUMB_MAX_WAITING) {
+		atomic.AddInt32(&waiting, -1)
+		return ErrBusy
+	}
+	defer atomic.AddInt32(&waiting, -1)
+
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func release() {
+	<-sem
+}
+
+func generate(ctx context.Context, store *storage.PreviewStore, fi *proto.FileInfo, v Variant) error {
+	if err := acquire(ctx); err != nil {
+		return err
+	}
+	defer release()
+
+	betag := fi.BETag
+	variants := []Variant{v}
+	open := func() (io.ReadCloser, error) {
+		return store.Mdisk.Get(env.NORMAL_BUCKET, betag, nil)
+	}
+	if Supported(fi.Mime) {
+		variants = append(variants, defaultVariants...)
+	} else {
+		//视频、文档等从预览服务生成的图片缩放
+		path, ok := derivedSource(store, betag)
+		if !ok {
+			return ErrUnsupported
+		}
+		open = func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}
+	}
+
+	paths := map[string]Variant{}
+	for _, dv := range variants {
+		path, err := dv.path(store, betag)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			paths[path] = dv
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	img, orientation, err := decode(open)
+	if err != nil {
+		return err
+	}
+	rgba := toRGBA(img)
+	for path, dv := range paths {
+		w, h := fitSize(rgba.Bounds().Dx(), rgba.Bounds().Dy(), dv.MaxSide)
+		if err := writeJPEG(path, orient(resize(rgba, w, h), orientation), dv.Quality); err != nil {
+			return err
+		}
+	}
+	logger.LogI().Str("betag", betag).Int("variants", len(paths)).Msg("thumbnail generated")
+	return nil
+}
+
+// derivedSource 预览服务已生成的最大图片
+func derivedSource(store *storage.PreviewStore, betag string) (string, bool) {
+	for _, v := range []Variant{Preview, Thumbnail} {
+		if path, err := v.path(store, betag); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// decode 解码图片，同时返回 EXIF 方向。先检查尺寸，超过 THUMB_MAX_PIXELS 的图片不解码。
+func decode(open func() (io.ReadCloser, error)) (image.Image, int, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+
+	head, err := io.ReadAll(io.LimitReader(rc, headSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > env.THUMB_MAX_PIXELS {
+		return nil, 0, fmt.Errorf("%w: %vx%v", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(io.MultiReader(bytes.NewReader(head), rc))
+	if err != nil {
+		return nil, 0, err
+	}
+	orientation := 1
+	if format == "jpeg" {
+		if x, err := exif.DecodeJPEG(head); err == nil && x.Orientation > 0 {
+			orientation = x.Orientation
+		}
+	}
+	return img, orientation, nil
+}
+
+// writeJPEG 先写临时文件再改名，避免读到写了一半的文件
+func writeJPEG(path string, img image.Image, quality int) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: quality})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package thumb
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/proto"
+	"aofs/repository/storage"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		w, h, max    int
+		wantW, wantH int
+	}{
+		{100, 50, 400, 100, 50}, //不放大
+		{4000, 3000, 400, 400, 300},
+		{3000, 4000, 400, 300, 400},
+		{10000, 10, 400, 400, 1},
+	}
+	for _, tt := range tests {
+		if w, h := fitSize(tt.w, tt.h, tt.max); w != tt.wantW || h != tt.wantH {
+			t.Errorf("fitSize(%v, %v, %v) = %v, %v, want %v, %v", tt.w, tt.h, tt.max, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			v := uint8(0)
+			if x >= 2 {
+				v = 200
+			}
+			src.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	dst := resize(src, 2, 1)
+	if got := dst.RGBAAt(0, 0); got.R != 0 {
+		t.Errorf("left pixel: %v", got)
+	}
+	if got := dst.RGBAAt(1, 0); got.R != 200 || got.A != 255 {
+		t.Errorf("right pixel: %v", got)
+	}
+
+	dst = resize(src, 1, 1)
+	if got := dst.RGBAAt(0, 0); got.R != 100 {
+		t.Errorf("average: %v", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 2x1：左红右蓝
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		w, h        int
+		redAt       image.Point
+	}{
+		{1, 2, 1, image.Pt(0, 0)},
+		{2, 2, 1, image.Pt(1, 0)},
+		{3, 2, 1, image.Pt(1, 0)},
+		{4, 2, 1, image.Pt(0, 0)},
+		{5, 1, 2, image.Pt(0, 0)},
+		{6, 1, 2, image.Pt(0, 0)}, //顺时针旋转后左边在上
+		{7, 1, 2, image.Pt(0, 1)},
+		{8, 1, 2, image.Pt(0, 1)}, //逆时针旋转后左边在下
+	}
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		if b := dst.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("orientation %v: size %v", tt.orientation, b)
+			continue
+		}
+		if got := dst.RGBAAt(tt.redAt.X, tt.redAt.Y); got != red {
+			t.Errorf("orientation %v: red not at %v", tt.orientation, tt.redAt)
+		}
+	}
+}
+
+func TestToRGBA(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	if got := toRGBA(src).RGBAAt(0, 0); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("transparent pixel should be white: %v", got)
+	}
+}
+
+func TestParseThumbnailSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		width   int
+		want    int
+		wantOk  bool
+		isSized bool
+	}{
+		{"", 0, Thumbnail.MaxSide, true, false},
+		{"medium", 0, 512, true, true},
+		{"medium", 2000, 512, true, true}, //尺寸名优先
+		{"huge", 0, 0, false, false},
+		{"", 100, 256, true, true},
+		{"", 512, 512, true, true},
+		{"", 513, 1024, true, true},
+		{"", 5000, 2048, true, true},
+	}
+	for _, tt := range tests {
+		v, ok := ParseThumbnailSize(tt.name, tt.width)
+		if ok && v.Name == "" {
+			t.Errorf("ParseThumbnailSize(%q, %v): empty variant name", tt.name, tt.width)
+		}
+		if ok != tt.wantOk || v.MaxSide != tt.want || v.sized != tt.isSized {
+			t.Errorf("ParseThumbnailSize(%q, %v) = %v, %v, want %v, %v", tt.name, tt.width, v.MaxSide, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestDecodeWebP(t *testing.T) {
+	//1x1 无损 WebP
+	data, _ := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if !Supported("image/webp") {
+		t.Fatal("webp not supported")
+	}
+	img, orientation, err := decode(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 || orientation != 1 {
+		t.Errorf("bounds %v, orientation %v", b, orientation)
+	}
+}
+
+// imageStor 只实现生成派生图片用到的方法，读取原图时等待 gate 关闭
+type imageStor struct {
+	storage.MultiDiskStorager
+	dir    string
+	data   []byte
+	opened chan struct{}
+	gate   chan struct{}
+	once   sync.Once
+}
+
+func (s *imageStor) GetDiskPathByBEtag(key string) (string, error) {
+	return s.dir, nil
+}
+
+func (s *imageStor) Get(bucket string, key string, part *proto.Part) (io.ReadCloser, error) {
+	s.once.Do(func() { close(s.opened) })
+	<-s.gate
+	return io.NopCloser(bytes.NewReader(s.data)), nil
+}
+
+func TestEnsureConcurrentVariants(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+	stor := &imageStor{dir: t.TempDir(), data: buf.Bytes(), opened: make(chan struct{}), gate: make(chan struct{})}
+	oldPreview, oldSem := newPreview, sem
+	newPreview = func() *storage.PreviewStore {
+		return &storage.PreviewStore{Mdisk: stor, Bucket: "derive"}
+	}
+	sem = make(chan struct{}, 2)
+	defer func() { newPreview, sem = oldPreview, oldSem }()
+	oldWaiting, oldPixels := env.THUMB_MAX_WAITING, env.THUMB_MAX_PIXELS
+	env.THUMB_MAX_WAITING, env.THUMB_MAX_PIXELS = 32, 1<<20
+	defer func() { env.THUMB_MAX_WAITING, env.THUMB_MAX_PIXELS = oldWaiting, oldPixels }()
+
+	fi := &proto.FileInfo{}
+	fi.BETag, fi.Mime = "0a1b2c3d4e5f", "image/png"
+	ensure := func(v Variant, errs chan<- error) {
+		path, err := Ensure(context.Background(), fi, v)
+		if err == nil {
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			err = fmt.Errorf("%v: %w", v.Name, err)
+		}
+		errs <- err
+	}
+
+	// 默认缩略图生成中时，同一对象其他尺寸的请求不能拿到未生成的路径
+	variants := []Variant{Preview, ThumbnailSize(256), ThumbnailSize(1024), Thumbnail}
+	errs := make(chan error, len(variants)+1)
+	go ensure(Thumbnail, errs)
+	<-stor.opened
+	for _, v := range variants {
+		go ensure(v, errs)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(stor.gate)
+	for i := 0; i < len(variants)+1; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiering
+
+//此文件完成对象在主存储和次存储之间的分层：按规则把冷数据搬到次存储，被访问时再搬回主存储
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/internal/utils"
+	"aofs/repository/dbutils"
+	"aofs/repository/storage"
+	"aofs/services/async"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var stor storage.MultiDiskStorager
+var logger = log4bp.New("", gin.Mode())
+
+var (
+	ErrRunning     = async.ErrJobRunning
+	ErrNoRules     = errors.New("no tiering rules")
+	ErrNoSecondary = errors.New("no secondary storage")
+)
+
+const batchSize = 1000
+
+// 访问时间按天记录，避免每次读取都写数据库
+const touchInterval = 24 * 3600
+
+// 待搬回的对象最多记录的数量，超过时丢弃，下次访问再记录
+const maxPendingRecalls = 10000
+
+// 有存储任务在执行时，每隔该时间重试搬回
+const recallRetryInterval = time.Minute
+
+var rules []Rule
+var chTouch chan string
+var recallLimiter *utils.RateLimiter
+
+var pendingMu sync.Mutex
+var pendingRecalls = map[string]bool{}
+var chRecall = make(chan struct{}, 1)
+
+// Rule 分层规则，设置的条件都满足时对象搬到次存储，未设置的条件不参与判断
+type Rule struct {
+	IdleDays int    `json:"idleDays"` //超过天数未访问
+	MinSize  int64  `json:"minSize"`  //不小于该大小，单位字节
+	Category string `json:"category"` //文件分类，如 video、picture、document
+}
+
+// Match 判断对象是否满足规则
+func (r Rule) Match(obj *dbutils.TieringObject, now time.Time) bool {
+	if r.IdleDays > 0 && now.Unix()-obj.AccessTime < int64(r.IdleDays)*24*3600 {
+		return false
+	}
+	if r.MinSize > 0 && obj.Size < r.MinSize {
+		return false
+	}
+	if len(r.Category) > 0 && r.Category != obj.Category {
+		return false
+	}
+	return true
+}
+
+// ParseRules 解析 JSON 格式的规则列表，每条规则至少设置一个条件
+func ParseRules(s string) ([]Rule, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	var rs []Rule
+	if err := json.Unmarshal([]byte(s), &rs); err != nil {
+		return nil, err
+	}
+	for i, r := range rs {
+		if r.IdleDays <= 0 && r.MinSize <= 0 && len(r.Category) == 0 {
+			return nil, fmt.Errorf("tiering rule %v has no condition", i)
+		}
+	}
+	return rs, nil
+}
+
+func matchAny(rs []Rule, obj *dbutils.TieringObject, now time.Time) bool {
+	//刚因访问搬回的对象暂不搬出，避免在两层之间来回搬
+	if obj.RecallTime > 0 && now.Unix()-obj.RecallTime < int64(env.TIERING_RECALL_HOLD_DAYS)*24*3600 {
+		return false
+	}
+	for _, r := range rs {
+		if r.Match(obj, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func Init() {
+	stor = storage.GetStor()
+	var err error
+	if rules, err = ParseRules(env.TIERING_RULES); err != nil {
+		logger.LogE().Err(err).Str("rules", env.TIERING_RULES).Msg("invalid tiering rules")
+	}
+
+	chTouch = make(chan string, 1024)
+	recallLimiter = utils.NewRateLimiter(env.TIERING_RATE_LIMIT)
+	go doTouch()
+	go doRecall()
+
+	if len(rules) > 0 && env.TIERING_INTERVAL_HOURS > 0 {
+		go timerTiering()
+	}
+}
+
+func timerTiering() {
+	for {
+		time.Sleep(time.Duration(env.TIERING_INTERVAL_HOURS) * time.Hour)
+		task := new(async.AsyncTask)
+		task.Init(0)
+		if err := Start(task, env.TIERING_RATE_LIMIT); err != nil {
+			logger.LogW().Err(err).Msg("skip scheduled tiering")
+		}
+	}
+}
+
+func hasSecondary() bool {
+	for _, id := range stor.GetDiskIds() {
+		if !stor.IsPrimary(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start 后台按规则把主存储上的冷数据搬到次存储，进度通过 task 查询
+func Start(task *async.AsyncTask, rateLimit int64) error {
+	if len(rules) == 0 {
+		return ErrNoRules
+	}
+	if !hasSecondary() {
+		return ErrNoSecondary
+	}
+	if !async.StorageJobs.TryLock() {
+		return ErrRunning
+	}
+
+	go func() {
+		defer async.StorageJobs.Unlock()
+		run(task, utils.NewRateLimiter(rateLimit))
+	}()
+	return nil
+}
+
+func run(task *async.AsyncTask, limiter *utils.RateLimiter) {
+	task.UpdateStatus(async.AsyncTaskStatusProcessing)
+	logger.LogI().Interface("rules", rules).Msg("start tiering")
+
+	now := time.Now()
+	var moved, failed int
+	after := ""
+	for {
+		objs, err := dbutils.GetTieringObjects(proto.TierPrimary, after, batchSize)
+		if err != nil {
+			logger.LogE().Err(err).Msg("failed to list objects")
+			task.UpdateStatus(async.AsyncTaskStatusFailed)
+			return
+		}
+		if len(objs) == 0 {
+			break
+		}
+		task.Total += len(objs)
+
+		for i := range objs {
+			obj := &objs[i]
+			after = obj.BETag
+			task.Processed++
+
+			if !stor.IsPrimary(obj.VolId) {
+				//主存储写满时新对象会分配到次存储，补记其所在层
+				if err := dbutils.SetBETagTier(obj.BETag, proto.TierSecondary); err != nil {
+					logger.LogW().Err(err).Str("betag", obj.BETag).Msg("failed to set tier")
+				}
+				continue
+			}
+			if !matchAny(rules, obj, now) {
+				continue
+			}
+			if err := moveToTier(obj.BETag, obj.Size, proto.TierSecondary, limiter); err != nil {
+				failed++
+				logger.LogW().Err(err).Str("betag", obj.BETag).Msg("failed to move object to secondary storage")
+				if errors.Is(err, storage.ErrEnoughSpace) {
+					//次存储已满，后续对象也放不下
+					logger.LogI().Int("moved", moved).Msg("secondary storage is full, stop tiering")
+					task.UpdateStatus(async.AsyncTaskStatusSuccess)
+					return
+				}
+				continue
+			}
+			moved++
+		}
+	}
+
+	logger.LogI().Int("moved", moved).Int("failed", failed).Msg("finish tiering")
+	task.UpdateStatus(async.AsyncTaskStatusSuccess)
+}
+
+// moveToTier 把对象搬到 tier 层剩余空间最多的盘，并记录所在层
+func moveToTier(betag string, size int64, tier uint8, limiter *utils.RateLimiter) error {
+	dst, err := stor.AllocTierDisk(tier == proto.TierPrimary, size)
+	if err != nil {
+		return err
+	}
+	if _, err := stor.MoveObject(env.NORMAL_BUCKET, betag, dst, limiter); err != nil {
+		return err
+	}
+	return dbutils.SetBETagTier(betag, tier)
+}
+
+// Touch 记录对象被访问，对象在次存储时由后台搬回主存储，不阻塞调用方
+func Touch(betag string) {
+	if chTouch == nil || len(betag) == 0 {
+		return
+	}
+	select {
+	case chTouch <- betag:
+	default:
+		//队列已满时丢弃，下次访问再处理
+	}
+}
+
+func doTouch() {
+	for betag := range chTouch {
+		bi, err := dbutils.GetBETagInfo(betag)
+		if err != nil {
+			continue
+		}
+		if now := time.Now().Unix(); now-bi.AccessTime >= touchInterval {
+			if err := dbutils.UpdateBETagAccessTime(betag, now); err != nil {
+				logger.LogW().Err(err).Str("betag", betag).Msg("failed to update access time")
+			}
+		}
+		if bi.Tier != proto.TierSecondary {
+			continue
+		}
+
+		queueRecall(betag)
+	}
+}
+
+// queueRecall 记录待搬回主存储的对象，由 doRecall 在取得存储任务锁后处理
+func queueRecall(betag string) {
+	pendingMu.Lock()
+	if len(pendingRecalls) < maxPendingRecalls {
+		pendingRecalls[betag] = true
+	}
+	pendingMu.Unlock()
+
+	select {
+	case chRecall <- struct{}{}:
+	default:
+	}
+}
+
+func popRecall() (string, bool) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for betag := range pendingRecalls {
+		delete(pendingRecalls, betag)
+		return betag, true
+	}
+	return "", false
+}
+
+// doRecall 搬回待处理的对象。与其他存储任务互斥，有任务在执行时保留待处理的对象，定时重试
+func doRecall() {
+	ticker := time.NewTicker(recallRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-chRecall:
+		case <-ticker.C:
+		}
+		if !async.StorageJobs.TryLock() {
+			continue
+		}
+		for {
+			betag, ok := popRecall()
+			if !ok {
+				break
+			}
+			recall(betag)
+		}
+		async.StorageJobs.Unlock()
+	}
+}
+
+// recall 把次存储上的对象搬回主存储，调用方需持有存储任务锁
+func recall(betag string) {
+	//排队期间对象可能已被删除或搬回
+	bi, err := dbutils.GetBETagInfo(betag)
+	if err != nil || bi.Tier != proto.TierSecondary {
+		return
+	}
+	//与分层任务一致按原始大小分配，对象文件可能是加密或压缩后的内容
+	size, err := dbutils.GetBETagSize(betag)
+	if err != nil {
+		return
+	}
+	//主存储空间不足时留在次存储，下次访问再尝试
+	if err := moveToTier(betag, size, proto.TierPrimary, recallLimiter); err != nil {
+		logger.LogW().Err(err).Str("betag", betag).Msg("failed to move object back to primary storage")
+		return
+	}
+	if err := dbutils.SetBETagRecalled(betag, time.Now().Unix()); err != nil {
+		logger.LogW().Err(err).Str("betag", betag).Msg("failed to set recall time")
+	}
+	logger.LogI().Str("betag", betag).Msg("object moved back to primary storage")
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiering
+
+import (
+	"aofs/repository/dbutils"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	rs, err := ParseRules(`[{"idleDays":90},{"category":"video","minSize":1073741824}]`)
+	if err != nil || len(rs) != 2 || rs[0].IdleDays != 90 || rs[1].Category != "video" || rs[1].MinSize != 1<<30 {
+		t.Fatalf("ParseRules: %+v, %v", rs, err)
+	}
+	if rs, err := ParseRules(""); err != nil || rs != nil {
+		t.Errorf("empty rules: %+v, %v", rs, err)
+	}
+	if _, err := ParseRules(`[{}]`); err == nil {
+		t.Error("rule without condition should be rejected")
+	}
+	if _, err := ParseRules(`{`); err == nil {
+		t.Error("invalid json should be rejected")
+	}
+}
+
+func TestRuleMatch(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	day := int64(24 * 3600)
+	rs := []Rule{{IdleDays: 90}, {Category: "video", MinSize: 1 << 30}}
+
+	tests := []struct {
+		name string
+		obj  dbutils.TieringObject
+		want bool
+	}{
+		{"idle", dbutils.TieringObject{AccessTime: now.Unix() - 91*day, Size: 10}, true},
+		{"recent", dbutils.TieringObject{AccessTime: now.Unix() - 89*day, Size: 10}, false},
+		{"large video", dbutils.TieringObject{AccessTime: now.Unix(), Size: 2 << 30, Category: "video"}, true},
+		{"small video", dbutils.TieringObject{AccessTime: now.Unix(), Size: 1 << 20, Category: "video"}, false},
+		{"large document", dbutils.TieringObject{AccessTime: now.Unix(), Size: 2 << 30, Category: "document"}, false},
+		{"recalled video", dbutils.TieringObject{AccessTime: now.Unix() - 91*day, RecallTime: now.Unix() - day,
+			Size: 2 << 30, Category: "video"}, false},
+		{"recalled long ago", dbutils.TieringObject{AccessTime: now.Unix() - 91*day, RecallTime: now.Unix() - 91*day, Size: 10}, true},
+	}
+	for _, tt := range tests {
+		if got := matchAny(rs, &tt.obj, now); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQueueRecall(t *testing.T) {
+	queueRecall("a")
+	queueRecall("a")
+	queueRecall("b")
+	got := map[string]bool{}
+	for {
+		betag, ok := popRecall()
+		if !ok {
+			break
+		}
+		if got[betag] {
+			t.Errorf("%v queued twice", betag)
+		}
+		got[betag] = true
+	}
+	if len(got) != 2 {
+		t.Errorf("pending recalls: %v", got)
+	}
+}
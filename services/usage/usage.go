@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Institute of Software, Chinese Academy of Sciences (ISCAS)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package usage
+
+//此文件完成用户已用空间的查询和定期校准。计数随文件记录增删在数据库事务中更新，
+//并发上传相同内容等情况可能使去重后的计数出现少量偏差，定期按文件记录重新统计修正
+
+import (
+	"aofs/internal/env"
+	"aofs/internal/log4bp"
+	"aofs/internal/proto"
+	"aofs/repository/dbutils"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var logger = log4bp.New("", gin.Mode())
+
+var running int32
+
+var ErrRunning = errors.New("usage reconcile is running")
+
+func Init() {
+	if env.USAGE_RECONCILE_HOURS > 0 {
+		go timerReconcile()
+	}
+}
+
+func timerReconcile() {
+	for {
+		time.Sleep(time.Duration(env.USAGE_RECONCILE_HOURS) * time.Hour)
+		if err := ReconcileAll(); err != nil {
+			logger.LogW().Err(err).Msg("skip scheduled usage reconcile")
+		}
+	}
+}
+
+// Get 获取用户已用空间，还没有计数时先按文件记录统计
+func Get(userId proto.UserIdType) (*proto.UserUsage, error) {
+	usage, err := dbutils.GetUserUsage(userId)
+	if err != nil {
+		return nil, err
+	} else if usage != nil {
+		return usage, nil
+	}
+	return Reconcile(userId)
+}
+
+// Reconcile 按文件记录重新统计用户已用空间，计数有偏差时记录日志
+func Reconcile(userId proto.UserIdType) (*proto.UserUsage, error) {
+	before, after, err := dbutils.ReconcileUserUsage(userId)
+	if err != nil {
+		return nil, err
+	}
+	if before.Logical != after.Logical || before.Physical != after.Physical {
+		logger.LogW().Uint("userId", uint(userId)).
+			Int64("logical", before.Logical).Int64("physical", before.Physical).
+			Int64("newLogical", after.Logical).Int64("newPhysical", after.Physical).
+			Msg("usage drift corrected")
+	}
+	return &after, nil
+}
+
+// ReconcileAll 校准所有用户的已用空间
+func ReconcileAll() error {
+	if !atomic.CompareAndSwapInt32(&running, 0, 1) {
+		return ErrRunning
+	}
+	defer atomic.StoreInt32(&running, 0)
+
+	userIds, err := dbutils.GetUsageUserIds()
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if _, err := Reconcile(userId); err != nil {
+			logger.LogE().Err(err).Uint("userId", uint(userId)).Msg("failed to reconcile usage")
+		}
+	}
+	logger.LogI().Int("users", len(userIds)).Msg("usage reconciled")
+	return nil
+}
@@ -17,40 +17,98 @@ package tests
 import (
 	"aofs/internal/proto"
 	"aofs/routers/api"
+	"errors"
+	"fmt"
+	"net/http"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func testDownloadAll(t *testing.T) {
 	t.Run("testDecodeRange", testDecodeRange)
+	t.Run("testArchiveEmpty", testArchiveEmpty)
+	t.Run("testRangeStatus", testRangeStatus)
 }
 
-func testDecodeRange(t *testing.T) {
-	type args struct {
-		rangestr string
+func testArchiveEmpty(t *testing.T) {
+	assert := assert.New(t)
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	for _, body := range []string{`{"uuids":[]}`, `{"uuids":[],"name":""}`} {
+		response := TPost("/space/v1/api/file/archive?userId=1", header, strings.NewReader(body))
+		assert.Equal(http.StatusBadRequest, response.Code, body)
+		assert.Empty(response.Header().Get("Content-Disposition"), body)
 	}
-	tests := []struct {
-		name    string
-		args    args
-		want    *proto.Part
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-		{"", args{"bytes=0-"}, &proto.Part{Start: 0, End: -1}, false},
-		{"", args{"bytes=0-5"}, &proto.Part{Start: 0, End: 5}, false},
-		{"", args{"bytes=-5"}, &proto.Part{Start: 0, End: 5}, false},
-		{"", args{"bytes=-5,"}, nil, true},
-	}
-	for _, tt := range tests {
+}
+
+type rangeArgs struct {
+	rangestr string
+	size     int64
+}
+
+// rangeCases 下载时格式错误或单位不支持的 Range 被忽略，返回完整内容，只有无法满足时返回 416
+var rangeCases = []struct {
+	name    string
+	args    rangeArgs
+	want    []proto.Part
+	wantErr error
+	status  int
+}{
+	{"empty", rangeArgs{"", 10}, nil, nil, http.StatusOK},
+	{"open end", rangeArgs{"bytes=0-", 10}, []proto.Part{{Start: 0, End: 9}}, nil, http.StatusPartialContent},
+	{"closed", rangeArgs{"bytes=0-5", 10}, []proto.Part{{Start: 0, End: 5}}, nil, http.StatusPartialContent},
+	{"end beyond size", rangeArgs{"bytes=5-100", 10}, []proto.Part{{Start: 5, End: 9}}, nil, http.StatusPartialContent},
+	{"suffix", rangeArgs{"bytes=-5", 10}, []proto.Part{{Start: 5, End: 9}}, nil, http.StatusPartialContent},
+	{"suffix beyond size", rangeArgs{"bytes=-50", 10}, []proto.Part{{Start: 0, End: 9}}, nil, http.StatusPartialContent},
+	{"empty element", rangeArgs{"bytes=-5,", 10}, []proto.Part{{Start: 5, End: 9}}, nil, http.StatusPartialContent},
+	{"multiple", rangeArgs{"bytes=0-1, 4-5,-2", 10}, []proto.Part{{Start: 0, End: 1}, {Start: 4, End: 5}, {Start: 8, End: 9}}, nil, http.StatusPartialContent},
+	{"skip unsatisfiable", rangeArgs{"bytes=20-30,0-0", 10}, []proto.Part{{Start: 0, End: 0}}, nil, http.StatusPartialContent},
+	{"unsatisfiable", rangeArgs{"bytes=10-", 10}, nil, api.ErrRangeNotSatisfiable, http.StatusRequestedRangeNotSatisfiable},
+	{"empty file", rangeArgs{"bytes=-5", 0}, nil, api.ErrRangeNotSatisfiable, http.StatusRequestedRangeNotSatisfiable},
+	{"unit", rangeArgs{"items=0-5", 10}, nil, api.ErrInvalidRange, http.StatusOK},
+	{"reversed", rangeArgs{"bytes=5-1", 10}, nil, api.ErrInvalidRange, http.StatusOK},
+	{"not number", rangeArgs{"bytes=a-5", 10}, nil, api.ErrInvalidRange, http.StatusOK},
+	{"no dash", rangeArgs{"bytes=5", 10}, nil, api.ErrInvalidRange, http.StatusOK},
+	{"no range", rangeArgs{"bytes=,", 10}, nil, api.ErrInvalidRange, http.StatusOK},
+}
+
+func testDecodeRange(t *testing.T) {
+	for _, tt := range rangeCases {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := api.DecodeRange(tt.args.rangestr)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("decodeRange() error = %v, wantErr %v", err, tt.wantErr)
+			got, err := api.DecodeRanges(tt.args.rangestr, tt.args.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("DecodeRanges() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("decodeRange() = %v, want %v", got, tt.want)
+				t.Errorf("DecodeRanges() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func testRangeStatus(t *testing.T) {
+	assert := assert.New(t)
+	data := "0123456789"
+	var fi proto.FileInfo
+	rspHead := proto.Rsp{Body: &fi}
+	TPostRsp(fmt.Sprintf("/space/v1/api/file/upload?userId=1&folderPath=/&fileName=range-%v.txt", time.Now().UnixNano()),
+		nil, strings.NewReader(data), &rspHead, assert)
+	if !assert.Equal(int(proto.CodeOk), int(rspHead.Code)) {
+		return
+	}
+
+	for _, tt := range rangeCases {
+		if tt.args.size != int64(len(data)) {
+			continue
+		}
+		response := TGet(fmt.Sprintf("/space/v1/api/file/download?uuid=%s&userId=1", fi.Id), http.Header{"Range": []string{tt.args.rangestr}})
+		assert.Equal(tt.status, response.Code, tt.name)
+		if tt.status == http.StatusOK {
+			assert.Equal(data, response.Body.String(), tt.name)
+		}
+	}
+}